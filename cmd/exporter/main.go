@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,6 +19,11 @@ import (
 
 	"github.com/rhwendt/enphase-exporter/internal/client"
 	"github.com/rhwendt/enphase-exporter/internal/collector"
+	"github.com/rhwendt/enphase-exporter/internal/emissions"
+	"github.com/rhwendt/enphase-exporter/internal/layout"
+	"github.com/rhwendt/enphase-exporter/internal/solar"
+	"github.com/rhwendt/enphase-exporter/internal/state"
+	"github.com/rhwendt/enphase-exporter/internal/tariff"
 )
 
 var (
@@ -78,15 +86,346 @@ func main() {
 	// Start proactive session refresh to prevent data gaps
 	envoyClient.StartSessionRefresh()
 
+	// Exporter-computed counters survive restarts when a state file is configured
+	var store *state.Store
+	if path := viper.GetString("state.file"); path != "" {
+		store = state.NewStore(path)
+	}
+
 	// Create and register collectors
 	productionCollector := collector.NewProductionCollector(envoyClient)
 	prometheus.MustRegister(productionCollector)
+	if store != nil {
+		store.Register(productionCollector)
+	}
+
+	// Day/week/month energy totals reset at midnight in the configured timezone
+	loc, err := loadLocation()
+	if err != nil {
+		log.Fatalf("Invalid TIMEZONE: %v", err)
+	}
+	energyPeriodsCollector := collector.NewEnergyPeriodsCollector(loc)
+	productionCollector.AddObserver(energyPeriodsCollector)
+	prometheus.MustRegister(energyPeriodsCollector)
+	if store != nil {
+		store.Register(energyPeriodsCollector)
+	}
+	log.WithField("timezone", loc.String()).Info("Energy period totals enabled")
+
+	// Solar/grid/home/battery flows and self-consumption ratios
+	energyFlowCollector := collector.NewEnergyFlowCollector()
+	productionCollector.AddObserver(energyFlowCollector)
+	prometheus.MustRegister(energyFlowCollector)
+	if store != nil {
+		store.Register(energyFlowCollector)
+	}
+
+	// Sun position and daylight need the site location
+	site, hasSite, err := loadSite()
+	if err != nil {
+		log.Fatalf("Invalid site configuration: %v", err)
+	}
+	var daylight collector.DaylightFunc
+	if hasSite {
+		// Without a timezone, the container's UTC day can split the site's
+		// day, so sun times use the longitude's mean solar time instead
+		sunLoc := loc
+		if viper.GetString("exporter.timezone") == "" {
+			sunLoc = longitudeLocation(site.Longitude)
+			log.WithField("offset", sunLoc.String()).Warn("TIMEZONE is not set; sun times use an offset from SITE_LONGITUDE and energy periods use system local time")
+		}
+		sunCollector := collector.NewSunCollector(site, sunLoc)
+		prometheus.MustRegister(sunCollector)
+		daylight = sunCollector.IsDaylight
+		log.WithFields(logrus.Fields{
+			"latitude":  site.Latitude,
+			"longitude": site.Longitude,
+		}).Info("Sun metrics enabled")
+	}
+
+	// Clear-sky expected production also needs the array details
+	var clearSkyDay collector.ClearSkyDayFunc
+	if hasSite && viper.GetFloat64("array.dc_capacity_watts") > 0 {
+		array := solar.Array{
+			Tilt:            viper.GetFloat64("array.tilt"),
+			Azimuth:         viper.GetFloat64("array.azimuth"),
+			DCCapacityWatts: viper.GetFloat64("array.dc_capacity_watts"),
+			Losses:          viper.GetFloat64("array.losses"),
+		}
+		if err := array.Validate(); err != nil {
+			log.Fatalf("Invalid array configuration: %v", err)
+		}
+		clearSkyCollector := collector.NewClearSkyCollector(site, array)
+		productionCollector.AddObserver(clearSkyCollector)
+		prometheus.MustRegister(clearSkyCollector)
+		clearSkyDay = clearSkyCollector.Day
+		log.WithFields(logrus.Fields{
+			"tilt":              array.Tilt,
+			"azimuth":           array.Azimuth,
+			"dc_capacity_watts": array.DCCapacityWatts,
+		}).Info("Clear-sky model enabled")
+	}
+
+	// CO2 accounting needs a grid emission factor, profile or CSV file
+	if viper.IsSet("emissions.factor") || viper.IsSet("emissions.hourly") || viper.IsSet("emissions.csv_file") {
+		emissionsConfig := emissions.Config{
+			Factor:  viper.GetFloat64("emissions.factor"),
+			CSVFile: viper.GetString("emissions.csv_file"),
+		}
+		if err := viper.UnmarshalKey("emissions.hourly", &emissionsConfig.Hourly); err != nil {
+			log.Fatalf("Invalid emissions configuration: %v", err)
+		}
+		factors, err := emissions.New(emissionsConfig, loc)
+		if err != nil {
+			log.Fatalf("Invalid emissions configuration: %v", err)
+		}
+		emissionsCollector := collector.NewEmissionsCollector(factors)
+		productionCollector.AddObserver(emissionsCollector)
+		prometheus.MustRegister(emissionsCollector)
+		if store != nil {
+			store.Register(emissionsCollector)
+		}
+		log.Info("Emissions collector enabled")
+	}
+
+	// Cost metrics need a tariff schedule from the config file
+	if viper.IsSet("tariff") {
+		var tariffConfig tariff.Config
+		if err := viper.UnmarshalKey("tariff", &tariffConfig); err != nil {
+			log.Fatalf("Invalid tariff configuration: %v", err)
+		}
+		tou, err := tariff.New(tariffConfig, loc)
+		if err != nil {
+			log.Fatalf("Invalid tariff configuration: %v", err)
+		}
+		tariffCollector := collector.NewTariffCollector(tou)
+		productionCollector.AddObserver(tariffCollector)
+		prometheus.MustRegister(tariffCollector)
+		if store != nil {
+			store.Register(tariffCollector)
+		}
+		log.WithField("periods", tou.PeriodNames()).Info("Tariff collector enabled")
+	}
+
+	if viper.GetInt("billing.cycle_start_day") > 0 {
+		// Read keys individually so env vars and the config file both apply
+		billingConfig := collector.BillingConfig{
+			CycleStartDay: viper.GetInt("billing.cycle_start_day"),
+			TrueUpMonth:   viper.GetInt("billing.true_up_month"),
+		}
+		if err := billingConfig.Validate(); err != nil {
+			log.Fatalf("Invalid billing configuration: %v", err)
+		}
+		billingCollector := collector.NewBillingCycleCollector(billingConfig, loc)
+		productionCollector.AddObserver(billingCollector)
+		prometheus.MustRegister(billingCollector)
+		if store != nil {
+			store.Register(billingCollector)
+		}
+		log.WithFields(logrus.Fields{
+			"cycle_start_day": billingConfig.CycleStartDay,
+			"true_up_month":   billingConfig.TrueUpMonth,
+		}).Info("Billing cycle collector enabled")
+	}
 
 	metersCollector := collector.NewMetersCollector(envoyClient)
 	prometheus.MustRegister(metersCollector)
 
-	invertersCollector := collector.NewInvertersCollector(envoyClient)
+	// Demand tracking uses net meter power on the utility's interval windows
+	if windows := viper.GetStringSlice("demand.windows"); len(windows) > 0 {
+		demandConfig, err := parseDemandConfig(windows)
+		if err != nil {
+			log.Fatalf("Invalid demand configuration: %v", err)
+		}
+		demandCollector := collector.NewDemandCollector(demandConfig, loc)
+		metersCollector.AddObserver(demandCollector)
+		prometheus.MustRegister(demandCollector)
+		if store != nil {
+			store.Register(demandCollector)
+		}
+		log.WithField("windows", windows).Info("Demand collector enabled")
+	}
+
+	// The layout maps inverters to arrays and positions on the roof
+	inverterLayout, err := loadLayout()
+	if err != nil {
+		log.Fatalf("Invalid inverter layout: %v", err)
+	}
+	labels := collector.InverterLabels{
+		Layout:   inverterLayout,
+		Position: viper.GetBool("layout.position_labels"),
+	}
+	if inverterLayout != nil {
+		log.WithField("arrays", inverterLayout.Arrays()).Info("Inverter layout loaded")
+	}
+
+	// Without a configured inventory, every inverter seen is expected
+	expectedInverters := splitList(viper.GetStringSlice("inverters.expected"))
+	invertersConfig := collector.InvertersConfig{
+		Labels:      labels,
+		Expected:    expectedInverters,
+		ForgetAfter: viper.GetDuration("inverters.forget_after"),
+		StaleAfter:  viper.GetDuration("inverters.stale_after"),
+		StaleAction: viper.GetString("inverters.stale_action"),
+		StallAfter:  viper.GetDuration("inverters.stall_after"),
+		Daylight:    daylight,
+		Location:    loc,
+	}
+	if err := invertersConfig.Validate(); err != nil {
+		log.Fatalf("Invalid inverters configuration: %v", err)
+	}
+	invertersCollector := collector.NewInvertersCollector(envoyClient, invertersConfig)
 	prometheus.MustRegister(invertersCollector)
+	if store != nil {
+		store.Register(invertersCollector)
+	}
+	if len(expectedInverters) > 0 {
+		log.WithField("expected", len(expectedInverters)).Info("Inverter inventory configured")
+	}
+	if invertersConfig.StallAfter > 0 && daylight == nil {
+		log.Info("Inverter poll stall detection needs SITE_LATITUDE and SITE_LONGITUDE, disabled")
+	}
+
+	// Peer comparison flags panels producing well below their neighbours
+	if viper.GetBool("collectors.inverter_peers") {
+		peerConfig, err := loadPeerConfig(labels)
+		if err != nil {
+			log.Fatalf("Invalid inverter peer configuration: %v", err)
+		}
+		peersCollector := collector.NewInverterPeersCollector(peerConfig, daylight)
+		invertersCollector.AddObserver(peersCollector)
+		prometheus.MustRegister(peersCollector)
+		log.WithFields(logrus.Fields{
+			"window":    peerConfig.Window,
+			"threshold": peerConfig.Threshold,
+			"daylight":  daylight != nil,
+		}).Info("Inverter peer comparison enabled")
+	}
+
+	// Clipping detection needs each inverter's AC rating
+	if viper.GetBool("collectors.inverter_clipping") {
+		clippingConfig, err := loadClippingConfig(labels)
+		if err != nil {
+			log.Fatalf("Invalid inverter clipping configuration: %v", err)
+		}
+		clippingCollector := collector.NewInverterClippingCollector(clippingConfig)
+		invertersCollector.AddObserver(clippingCollector)
+		prometheus.MustRegister(clippingCollector)
+		if store != nil {
+			store.Register(clippingCollector)
+		}
+		log.WithFields(logrus.Fields{
+			"rating":  clippingConfig.DefaultRating,
+			"ratings": len(clippingConfig.Ratings),
+		}).Info("Inverter clipping detection enabled")
+	}
+
+	// Degradation tracking keeps a daily history per panel
+	var panelTrends *collector.PanelTrendsCollector
+	if viper.GetBool("collectors.panel_trends") {
+		groups, err := loadPeerGroups()
+		if err != nil {
+			log.Fatalf("Invalid inverter peer configuration: %v", err)
+		}
+		trendConfig := collector.TrendConfig{
+			Method:           viper.GetString("panel_trends.method"),
+			Window:           viper.GetDuration("panel_trends.window"),
+			MinDays:          viper.GetInt("panel_trends.min_days"),
+			ClearDayFraction: viper.GetFloat64("panel_trends.clear_day_fraction"),
+			Groups:           groups,
+			Labels:           labels,
+		}
+		if err := trendConfig.Validate(); err != nil {
+			log.Fatalf("Invalid panel trends configuration: %v", err)
+		}
+		if trendConfig.Method == collector.TrendClearSky && clearSkyDay == nil {
+			log.Fatal("Clear-sky panel trends need SITE_LATITUDE, SITE_LONGITUDE and ARRAY_DC_CAPACITY_WATTS")
+		}
+		panelTrends = collector.NewPanelTrendsCollector(trendConfig, loc, clearSkyDay)
+		invertersCollector.AddObserver(panelTrends)
+		prometheus.MustRegister(panelTrends)
+		if store != nil {
+			store.Register(panelTrends)
+		} else {
+			log.Warn("Panel trends are enabled without STATE_FILE; history is lost on restart")
+		}
+		log.WithFields(logrus.Fields{
+			"window": trendConfig.Window,
+			"method": trendConfig.Method,
+		}).Info("Panel degradation tracking enabled")
+	}
+
+	// Shade detection compares panels with their peers by time of day
+	var shadeCollector *collector.ShadeCollector
+	if viper.GetBool("collectors.shade") {
+		groups, err := loadPeerGroups()
+		if err != nil {
+			log.Fatalf("Invalid inverter peer configuration: %v", err)
+		}
+		shadeConfig := collector.ShadeConfig{
+			BucketSize:     viper.GetDuration("shade.bucket"),
+			Days:           viper.GetInt("shade.days"),
+			MinDays:        viper.GetInt("shade.min_days"),
+			Threshold:      viper.GetFloat64("shade.threshold"),
+			Recurrence:     viper.GetFloat64("shade.recurrence"),
+			MinMedianWatts: viper.GetFloat64("shade.min_median_watts"),
+			Groups:         groups,
+			Labels:         labels,
+		}
+		if err := shadeConfig.Validate(); err != nil {
+			log.Fatalf("Invalid shade configuration: %v", err)
+		}
+		shadeCollector = collector.NewShadeCollector(shadeConfig, loc, daylight)
+		invertersCollector.AddObserver(shadeCollector)
+		prometheus.MustRegister(shadeCollector)
+		if store != nil {
+			store.Register(shadeCollector)
+		}
+		log.WithFields(logrus.Fields{
+			"bucket":    shadeConfig.BucketSize,
+			"days":      shadeConfig.Days,
+			"threshold": shadeConfig.Threshold,
+		}).Info("Shade detection enabled")
+	}
+
+	// Live data requires firmware 7.x+ and is opt-in
+	if viper.GetBool("collectors.livedata") {
+		liveDataCollector := collector.NewLiveDataCollector(envoyClient)
+		prometheus.MustRegister(liveDataCollector)
+		// Report-mode gateways only expose IQ Battery power through live data
+		productionCollector.UseLiveDataStorage()
+		log.Info("Live data collector enabled")
+	}
+
+	// Ensemble endpoints only exist on IQ System Controller sites
+	if viper.GetBool("collectors.ensemble") {
+		ensembleCollector := collector.NewEnsembleCollector(envoyClient)
+		prometheus.MustRegister(ensembleCollector)
+		if store != nil {
+			store.Register(ensembleCollector)
+		}
+		log.Info("Ensemble collector enabled")
+	}
+
+	if viper.GetBool("collectors.load_control") {
+		loadControlCollector := collector.NewLoadControlCollector(envoyClient)
+		prometheus.MustRegister(loadControlCollector)
+		log.Info("Load control collector enabled")
+	}
+
+	if viper.GetBool("collectors.ev_chargers") {
+		evChargersCollector := collector.NewEVChargersCollector(envoyClient)
+		prometheus.MustRegister(evChargersCollector)
+		log.Info("EV chargers collector enabled")
+	}
+
+	// Restore state before the first scrape
+	if store != nil {
+		if err := store.Load(); err != nil {
+			log.WithError(err).Warn("Failed to restore exporter state, starting fresh")
+		}
+		store.StartPeriodicSave(time.Duration(viper.GetInt("state.save_interval")) * time.Second)
+	}
 
 	// Register build info metric
 	buildInfo := prometheus.NewGaugeVec(
@@ -109,6 +448,12 @@ func main() {
 	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/health", healthHandler)
 	mux.HandleFunc("/ready", readyHandler)
+	if panelTrends != nil {
+		mux.Handle("/api/panels/trends", panelTrends)
+	}
+	if shadeCollector != nil {
+		mux.Handle("/api/panels/shading", shadeCollector)
+	}
 	mux.HandleFunc("/", rootHandler)
 
 	server := &http.Server{
@@ -145,6 +490,16 @@ func main() {
 		log.WithError(err).Error("Server forced to shutdown")
 	}
 
+	// Save after the server stops so no scrape updates state afterwards
+	if store != nil {
+		store.StopPeriodicSave()
+		if err := store.Save(); err != nil {
+			log.WithError(err).Error("Failed to save exporter state")
+		} else {
+			log.Info("Saved exporter state")
+		}
+	}
+
 	log.Info("Server exited")
 }
 
@@ -181,6 +536,16 @@ func loadConfig() error {
 	viper.SetEnvPrefix("")
 	viper.AutomaticEnv()
 
+	// Optional YAML config file for settings that don't fit in env vars
+	viper.BindEnv("config.file", "CONFIG_FILE")
+	if path := viper.GetString("config.file"); path != "" {
+		viper.SetConfigFile(path)
+		if err := viper.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		log.WithField("path", path).Info("Loaded config file")
+	}
+
 	// Map environment variables to config keys
 	viper.BindEnv("envoy.address", "ENVOY_ADDRESS")
 	viper.BindEnv("envoy.serial", "ENVOY_SERIAL")
@@ -189,10 +554,84 @@ func loadConfig() error {
 	viper.BindEnv("envoy.jwt", "ENVOY_JWT")
 	viper.BindEnv("exporter.port", "EXPORTER_PORT")
 	viper.BindEnv("scrape.interval", "SCRAPE_INTERVAL")
+	viper.BindEnv("collectors.livedata", "COLLECTOR_LIVEDATA")
+	viper.BindEnv("collectors.ensemble", "COLLECTOR_ENSEMBLE")
+	viper.BindEnv("collectors.load_control", "COLLECTOR_LOAD_CONTROL")
+	viper.BindEnv("collectors.ev_chargers", "COLLECTOR_EV_CHARGERS")
+	viper.BindEnv("collectors.inverter_peers", "COLLECTOR_INVERTER_PEERS")
+	viper.BindEnv("inverter_peers.window", "INVERTER_PEERS_WINDOW")
+	viper.BindEnv("inverter_peers.threshold", "INVERTER_PEERS_THRESHOLD")
+	viper.BindEnv("inverter_peers.min_median_watts", "INVERTER_PEERS_MIN_WATTS")
+	viper.BindEnv("inverter_peers.min_samples", "INVERTER_PEERS_MIN_SAMPLES")
+	viper.BindEnv("collectors.inverter_clipping", "COLLECTOR_INVERTER_CLIPPING")
+	viper.BindEnv("inverter_clipping.rating", "INVERTER_CLIPPING_RATING")
+	viper.BindEnv("inverter_clipping.margin", "INVERTER_CLIPPING_MARGIN")
+	viper.BindEnv("inverter_clipping.min_duration", "INVERTER_CLIPPING_MIN_DURATION")
+	viper.BindEnv("collectors.panel_trends", "COLLECTOR_PANEL_TRENDS")
+	viper.BindEnv("panel_trends.method", "PANEL_TRENDS_METHOD")
+	viper.BindEnv("panel_trends.window", "PANEL_TRENDS_WINDOW")
+	viper.BindEnv("panel_trends.min_days", "PANEL_TRENDS_MIN_DAYS")
+	viper.BindEnv("collectors.shade", "COLLECTOR_SHADE")
+	viper.BindEnv("shade.bucket", "SHADE_BUCKET")
+	viper.BindEnv("shade.days", "SHADE_DAYS")
+	viper.BindEnv("shade.threshold", "SHADE_THRESHOLD")
+	viper.BindEnv("shade.min_days", "SHADE_MIN_DAYS")
+	viper.BindEnv("shade.recurrence", "SHADE_RECURRENCE")
+	viper.BindEnv("exporter.timezone", "TIMEZONE")
+	viper.BindEnv("billing.cycle_start_day", "BILLING_CYCLE_START_DAY")
+	viper.BindEnv("billing.true_up_month", "BILLING_TRUE_UP_MONTH")
+	viper.BindEnv("demand.windows", "DEMAND_WINDOWS")
+	viper.BindEnv("emissions.factor", "EMISSIONS_FACTOR")
+	viper.BindEnv("emissions.csv_file", "EMISSIONS_CSV_FILE")
+	viper.BindEnv("site.latitude", "SITE_LATITUDE")
+	viper.BindEnv("site.longitude", "SITE_LONGITUDE")
+	viper.BindEnv("site.elevation", "SITE_ELEVATION")
+	viper.BindEnv("array.tilt", "ARRAY_TILT")
+	viper.BindEnv("array.azimuth", "ARRAY_AZIMUTH")
+	viper.BindEnv("array.dc_capacity_watts", "ARRAY_DC_CAPACITY_WATTS")
+	viper.BindEnv("array.losses", "ARRAY_LOSSES")
+	viper.BindEnv("inverters.expected", "INVERTERS_EXPECTED")
+	viper.BindEnv("inverters.forget_after", "INVERTERS_FORGET_AFTER")
+	viper.BindEnv("inverters.stale_after", "INVERTERS_STALE_AFTER")
+	viper.BindEnv("inverters.stale_action", "INVERTERS_STALE_ACTION")
+	viper.BindEnv("inverters.stall_after", "INVERTERS_STALL_AFTER")
+	viper.BindEnv("layout.csv_file", "LAYOUT_FILE")
+	viper.BindEnv("layout.position_labels", "LAYOUT_POSITION_LABELS")
+	viper.BindEnv("state.file", "STATE_FILE")
+	viper.BindEnv("state.save_interval", "STATE_SAVE_INTERVAL")
 
 	// Set defaults
 	viper.SetDefault("exporter.port", "9090")
 	viper.SetDefault("scrape.interval", 30)
+	viper.SetDefault("collectors.livedata", false)
+	viper.SetDefault("collectors.ensemble", false)
+	viper.SetDefault("collectors.load_control", false)
+	viper.SetDefault("collectors.ev_chargers", false)
+	viper.SetDefault("collectors.inverter_peers", false)
+	viper.SetDefault("collectors.inverter_clipping", false)
+	viper.SetDefault("collectors.panel_trends", false)
+	viper.SetDefault("collectors.shade", false)
+	viper.SetDefault("inverters.forget_after", "720h")
+	viper.SetDefault("inverters.stale_action", collector.StaleZero)
+	viper.SetDefault("inverters.stall_after", "1h")
+	viper.SetDefault("inverter_peers.window", "1h")
+	viper.SetDefault("inverter_peers.threshold", 0.8)
+	viper.SetDefault("inverter_peers.min_median_watts", 20)
+	viper.SetDefault("inverter_peers.min_samples", 10)
+	viper.SetDefault("inverter_clipping.margin", 0.02)
+	viper.SetDefault("inverter_clipping.min_duration", "15m")
+	viper.SetDefault("panel_trends.method", "peers")
+	viper.SetDefault("panel_trends.window", "8760h")
+	viper.SetDefault("panel_trends.min_days", 60)
+	viper.SetDefault("panel_trends.clear_day_fraction", 0.7)
+	viper.SetDefault("shade.bucket", "15m")
+	viper.SetDefault("shade.days", 14)
+	viper.SetDefault("shade.min_days", 5)
+	viper.SetDefault("shade.threshold", 0.7)
+	viper.SetDefault("shade.recurrence", 0.6)
+	viper.SetDefault("shade.min_median_watts", 20)
+	viper.SetDefault("array.losses", 0.14)
+	viper.SetDefault("state.save_interval", 60)
 
 	return nil
 }
@@ -214,9 +653,169 @@ func validateConfig() error {
 		return errMissingConfig("ENVOY_JWT (generate at https://entrez.enphaseenergy.com)")
 	}
 
+	if _, err := loadLocation(); err != nil {
+		return fmt.Errorf("invalid configuration: TIMEZONE: %w", err)
+	}
+
+	if viper.GetString("state.file") != "" && viper.GetInt("state.save_interval") <= 0 {
+		return fmt.Errorf("invalid configuration: STATE_SAVE_INTERVAL must be a positive number of seconds")
+	}
+
 	return nil
 }
 
+// loadLocation returns the configured IANA timezone, defaulting to the
+// system's local time (UTC in the container image).
+func loadLocation() (*time.Location, error) {
+	name := viper.GetString("exporter.timezone")
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// longitudeLocation returns a fixed zone at the whole-hour offset nearest to
+// mean solar time at the given longitude.
+func longitudeLocation(longitude float64) *time.Location {
+	hours := int(math.Round(longitude / 15))
+	return time.FixedZone(fmt.Sprintf("UTC%+d", hours), hours*3600)
+}
+
+// loadSite reads the site coordinates. ok is false if they aren't configured.
+func loadSite() (site solar.Site, ok bool, err error) {
+	if !viper.IsSet("site.latitude") || !viper.IsSet("site.longitude") {
+		return site, false, nil
+	}
+	site = solar.Site{
+		Latitude:  viper.GetFloat64("site.latitude"),
+		Longitude: viper.GetFloat64("site.longitude"),
+		Elevation: viper.GetFloat64("site.elevation"),
+	}
+	return site, true, site.Validate()
+}
+
+// loadLayout reads the inverter layout from the CSV file and the panels list
+// in the config file. It returns nil if neither is configured.
+func loadLayout() (*layout.Layout, error) {
+	var panels []layout.Panel
+	if path := viper.GetString("layout.csv_file"); path != "" {
+		fromFile, err := layout.LoadCSV(path)
+		if err != nil {
+			return nil, err
+		}
+		panels = append(panels, fromFile...)
+	}
+	var fromConfig []layout.Panel
+	if err := viper.UnmarshalKey("layout.panels", &fromConfig); err != nil {
+		return nil, fmt.Errorf("layout.panels: %w", err)
+	}
+	panels = append(panels, fromConfig...)
+	if len(panels) == 0 {
+		return nil, nil
+	}
+	return layout.New(panels)
+}
+
+// loadPeerConfig reads the inverter peer comparison settings.
+func loadPeerConfig(labels collector.InverterLabels) (collector.PeerConfig, error) {
+	groups, err := loadPeerGroups()
+	if err != nil {
+		return collector.PeerConfig{}, err
+	}
+	config := collector.PeerConfig{
+		Window:         viper.GetDuration("inverter_peers.window"),
+		Threshold:      viper.GetFloat64("inverter_peers.threshold"),
+		MinMedianWatts: viper.GetFloat64("inverter_peers.min_median_watts"),
+		MinSamples:     viper.GetInt("inverter_peers.min_samples"),
+		Groups:         groups,
+		Labels:         labels,
+	}
+	return config, config.Validate()
+}
+
+// loadPeerGroups reads the inverter peer groups, which are lists of serial
+// numbers by group name, as a map of serial number to group.
+func loadPeerGroups() (map[string]string, error) {
+	groups := make(map[string]string)
+	for group, serials := range viper.GetStringMapStringSlice("inverter_peers.groups") {
+		for _, serial := range serials {
+			if other, ok := groups[serial]; ok {
+				return nil, fmt.Errorf("inverter %s is in peer groups %q and %q", serial, other, group)
+			}
+			groups[serial] = group
+		}
+	}
+	return groups, nil
+}
+
+// loadClippingConfig reads the inverter clipping settings. Ratings are either
+// watts or a model name such as IQ7PLUS.
+func loadClippingConfig(labels collector.InverterLabels) (collector.ClippingConfig, error) {
+	config := collector.ClippingConfig{
+		Margin:      viper.GetFloat64("inverter_clipping.margin"),
+		MinDuration: viper.GetDuration("inverter_clipping.min_duration"),
+		Ratings:     make(map[string]float64),
+		Labels:      labels,
+	}
+	if value := viper.GetString("inverter_clipping.rating"); value != "" {
+		rating, err := parseRating(value)
+		if err != nil {
+			return config, err
+		}
+		config.DefaultRating = rating
+	}
+	for serial, value := range viper.GetStringMapString("inverter_clipping.ratings") {
+		rating, err := parseRating(value)
+		if err != nil {
+			return config, fmt.Errorf("inverter %s: %w", serial, err)
+		}
+		config.Ratings[serial] = rating
+	}
+	return config, config.Validate()
+}
+
+// parseRating parses an inverter AC rating given in watts or as a model.
+func parseRating(value string) (float64, error) {
+	if watts, err := strconv.ParseFloat(value, 64); err == nil {
+		return watts, nil
+	}
+	if watts, ok := collector.InverterModelRating(value); ok {
+		return watts, nil
+	}
+	return 0, fmt.Errorf("unknown inverter model %q", value)
+}
+
+// parseDemandConfig parses demand windows such as "15m" or "15m,30m". Peaks
+// per billing period follow the billing cycle start day if one is configured.
+func parseDemandConfig(values []string) (collector.DemandConfig, error) {
+	config := collector.DemandConfig{CycleStartDay: 1}
+	if day := viper.GetInt("billing.cycle_start_day"); day > 0 {
+		config.CycleStartDay = day
+	}
+	for _, field := range splitList(values) {
+		window, err := time.ParseDuration(field)
+		if err != nil {
+			return config, fmt.Errorf("invalid demand window %q: %w", field, err)
+		}
+		config.Windows = append(config.Windows, window)
+	}
+	return config, config.Validate()
+}
+
+// splitList flattens a list setting that may also be given as comma-separated
+// environment variable values, dropping empty entries.
+func splitList(values []string) []string {
+	var fields []string
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
 type configError struct {
 	field string
 }
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -173,6 +174,28 @@ func (c *Client) GetConsumptionReport() (*ConsumptionReportResponse, error) {
 	return &result, nil
 }
 
+// GetProductionJSON fetches the legacy production/consumption/storage summary.
+// Older firmware and non-metered Envoy-S Standard units only provide this endpoint.
+func (c *Client) GetProductionJSON() (*ProductionJSONResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	url := c.config.Address + EndpointProductionJSON
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("production.json request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result ProductionJSONResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode production.json response: %w", err)
+	}
+
+	return &result, nil
+}
+
 // GetMeterReadings fetches meter readings from the gateway.
 func (c *Client) GetMeterReadings() (*MeterReadingsResponse, error) {
 	if err := c.ensureAuthenticated(); err != nil {
@@ -236,12 +259,205 @@ func (c *Client) GetInverters() (*InvertersResponse, error) {
 	return &result, nil
 }
 
+// GetEnsembleRelay fetches the IQ System Controller relay state from the gateway.
+func (c *Client) GetEnsembleRelay() (*EnsembleRelayResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	url := c.config.Address + EndpointEnsembleRelay
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("ensemble relay request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result EnsembleRelayResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode ensemble relay response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetEnsembleSecCtrl fetches ensemble secondary control state from the gateway.
+func (c *Client) GetEnsembleSecCtrl() (*EnsembleSecCtrlResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	url := c.config.Address + EndpointEnsembleSecCtrl
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("ensemble secctrl request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result EnsembleSecCtrlResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode ensemble secctrl response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetDryContactStatus fetches load control relay state from the gateway.
+func (c *Client) GetDryContactStatus() (*DryContactStatusResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	url := c.config.Address + EndpointDryContacts
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("dry contacts request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result DryContactStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode dry contacts response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetDryContactSettings fetches load control relay configuration from the gateway.
+func (c *Client) GetDryContactSettings() (*DryContactSettingsResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	url := c.config.Address + EndpointDryContactSettings
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("dry contact settings request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result DryContactSettingsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode dry contact settings response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetGenerator fetches generator configuration and runtime state from the gateway.
+func (c *Client) GetGenerator() (*GeneratorResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	url := c.config.Address + EndpointGenerator
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("generator request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result GeneratorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode generator response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetEVChargers fetches the state of EV chargers paired to the gateway.
+func (c *Client) GetEVChargers() (*EVChargersResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	url := c.config.Address + EndpointEVChargers
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("EV chargers request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result EVChargersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode EV chargers response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// GetLiveData fetches a live data snapshot from the gateway.
+// The live data stream is enabled first if the gateway reports it as disabled,
+// since the status endpoint only returns zeroed power values otherwise.
+func (c *Client) GetLiveData() (*LiveDataResponse, error) {
+	if err := c.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+
+	result, err := c.fetchLiveData()
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Connection.ScStream != "enabled" {
+		clientLog.Info("Live data stream disabled, enabling")
+		if err := c.enableLiveDataStream(); err != nil {
+			return nil, err
+		}
+		if result, err = c.fetchLiveData(); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// fetchLiveData reads /ivp/livedata/status without enabling the stream.
+func (c *Client) fetchLiveData() (*LiveDataResponse, error) {
+	url := c.config.Address + EndpointLiveDataStatus
+	resp, err := c.doRequest("GET", url)
+	if err != nil {
+		return nil, fmt.Errorf("live data request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result LiveDataResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode live data response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// enableLiveDataStream asks the gateway to start populating live data.
+func (c *Client) enableLiveDataStream() error {
+	url := c.config.Address + EndpointLiveDataStream
+	resp, err := c.doRequestWithBody("POST", url, []byte(`{"enable":1}`))
+	if err != nil {
+		return fmt.Errorf("enable live data stream request failed: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
 // doRequest performs an HTTP request with proper error handling.
 func (c *Client) doRequest(method, url string) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, nil)
+	return c.doRequestWithBody(method, url, nil)
+}
+
+// doRequestWithBody performs an HTTP request with an optional JSON body.
+func (c *Client) doRequestWithBody(method, url string, body []byte) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -251,7 +467,7 @@ func (c *Client) doRequest(method, url string) (*http.Response, error) {
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		return nil, fmt.Errorf("request returned status %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	return resp, nil
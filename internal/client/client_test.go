@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 )
@@ -158,6 +159,67 @@ func TestClient_GetConsumptionReport(t *testing.T) {
 	}
 }
 
+func TestClient_GetProductionJSON(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/production.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/check_jwt":
+			w.WriteHeader(http.StatusOK)
+		case "/production.json":
+			w.Write(fixture)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(Config{
+		Address: server.URL,
+		Serial:  "123456789",
+		JWT:     "test-jwt",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.httpClient = server.Client()
+
+	resp, err := client.GetProductionJSON()
+	if err != nil {
+		t.Fatalf("GetProductionJSON() error = %v", err)
+	}
+
+	if len(resp.Production) != 2 || resp.Production[1].Type != "eim" {
+		t.Fatalf("Expected inverters and eim production sections, got %+v", resp.Production)
+	}
+
+	eim := resp.Production[1]
+	if eim.WhToday != 18220 || eim.WhLastSevenDays != 152870 {
+		t.Errorf("Expected whToday 18220 and whLastSevenDays 152870, got %f and %f", eim.WhToday, eim.WhLastSevenDays)
+	}
+
+	if len(eim.Lines) != 2 {
+		t.Errorf("Expected 2 lines, got %d", len(eim.Lines))
+	}
+
+	if resp.Consumption[1].MeasurementType != "net-consumption" {
+		t.Errorf("Expected net-consumption, got '%s'", resp.Consumption[1].MeasurementType)
+	}
+
+	if resp.Storage[0].Type != "acb" {
+		t.Errorf("Expected acb storage, got '%s'", resp.Storage[0].Type)
+	}
+
+	// Meter report endpoints are missing on this gateway
+	_, err = client.GetProductionReport()
+	if !IsNotFound(err) {
+		t.Errorf("Expected not found error for meter reports, got %v", err)
+	}
+}
+
 func TestClient_GetInverters(t *testing.T) {
 	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
@@ -273,6 +335,224 @@ func TestClient_GetMeterReadings(t *testing.T) {
 	}
 }
 
+func TestClient_GetLiveData(t *testing.T) {
+	streamEnabled := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/check_jwt":
+			w.WriteHeader(http.StatusOK)
+		case "/ivp/livedata/stream":
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			streamEnabled = true
+			w.Write([]byte(`{"sc_stream":"enabled"}`))
+		case "/ivp/livedata/status":
+			resp := LiveDataResponse{
+				Connection: LiveDataConnection{ScStream: "disabled"},
+			}
+			if streamEnabled {
+				resp.Connection.ScStream = "enabled"
+				resp.Meters = LiveDataMeters{
+					LastUpdate:     1706400000,
+					MainRelayState: 1,
+					PV:             LiveDataSource{AggPMw: 2500000},
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(Config{
+		Address: server.URL,
+		Serial:  "123456789",
+		JWT:     "test-jwt",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.httpClient = server.Client()
+
+	live, err := client.GetLiveData()
+	if err != nil {
+		t.Fatalf("GetLiveData() error = %v", err)
+	}
+
+	if !streamEnabled {
+		t.Error("Expected live data stream to be enabled")
+	}
+
+	if live.Meters.PV.AggPMw != 2500000 {
+		t.Errorf("Expected PV power 2500000 mW, got %f", live.Meters.PV.AggPMw)
+	}
+}
+
+func TestClient_GetEnsembleRelay(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/check_jwt":
+			w.WriteHeader(http.StatusOK)
+		case "/ivp/ensemble/relay":
+			w.Write([]byte(`{"mains_admin_state":"closed","mains_oper_state":"open","der1_state":1,"der2_state":1,"der3_state":1,"Enchg_grid_mode":"multimode-offgrid","Solar_grid_mode":"multimode-offgrid"}`))
+		case "/ivp/ensemble/secctrl":
+			w.Write([]byte(`{"secctrl":{"shutdown":false,"freq_bias_hz":0.25,"voltage_bias_v":1.5,"agg_soc":80},"relayInfo":{"mains_admin_state":"closed","mains_oper_state":"open","Enchg_grid_mode":"multimode-offgrid","Solar_grid_mode":"multimode-offgrid"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(Config{
+		Address: server.URL,
+		Serial:  "123456789",
+		JWT:     "test-jwt",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.httpClient = server.Client()
+
+	relay, err := client.GetEnsembleRelay()
+	if err != nil {
+		t.Fatalf("GetEnsembleRelay() error = %v", err)
+	}
+
+	if relay.MainsOperState != "open" {
+		t.Errorf("Expected mains_oper_state 'open', got '%s'", relay.MainsOperState)
+	}
+
+	if relay.EnchgGridMode != "multimode-offgrid" {
+		t.Errorf("Expected Enchg_grid_mode 'multimode-offgrid', got '%s'", relay.EnchgGridMode)
+	}
+
+	secctrl, err := client.GetEnsembleSecCtrl()
+	if err != nil {
+		t.Fatalf("GetEnsembleSecCtrl() error = %v", err)
+	}
+
+	if secctrl.SecCtrl.FreqBiasHz != 0.25 {
+		t.Errorf("Expected freq_bias_hz 0.25, got %f", secctrl.SecCtrl.FreqBiasHz)
+	}
+
+	if secctrl.RelayInfo.MainsOperState != "open" {
+		t.Errorf("Expected relayInfo mains_oper_state 'open', got '%s'", secctrl.RelayInfo.MainsOperState)
+	}
+}
+
+func TestClient_GetDryContacts(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/check_jwt":
+			w.WriteHeader(http.StatusOK)
+		case "/ivp/ensemble/dry_contacts":
+			w.Write([]byte(`{"dry_contacts":[{"id":"NC1","status":"closed"}]}`))
+		case "/ivp/ss/dry_contact_settings":
+			w.Write([]byte(`{"dry_contacts":[{"id":"NC1","type":"LOAD","load_name":"Pool Pump","mode":"soc","soc_low":30,"soc_high":70,"grid_action":"apply","micro_grid_action":"shed","gen_action":"shed"}]}`))
+		case "/ivp/ensemble/generator":
+			w.Write([]byte(`{"admin_state":"auto","oper_state":"off","admin_mode":"auto","start_soc":15,"stop_soc":80,"present":1}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(Config{
+		Address: server.URL,
+		Serial:  "123456789",
+		JWT:     "test-jwt",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.httpClient = server.Client()
+
+	status, err := client.GetDryContactStatus()
+	if err != nil {
+		t.Fatalf("GetDryContactStatus() error = %v", err)
+	}
+
+	if len(status.DryContacts) != 1 || status.DryContacts[0].Status != "closed" {
+		t.Errorf("Expected one closed relay, got %+v", status.DryContacts)
+	}
+
+	settings, err := client.GetDryContactSettings()
+	if err != nil {
+		t.Fatalf("GetDryContactSettings() error = %v", err)
+	}
+
+	if settings.DryContacts[0].SocLow != 30 {
+		t.Errorf("Expected soc_low 30, got %f", settings.DryContacts[0].SocLow)
+	}
+
+	gen, err := client.GetGenerator()
+	if err != nil {
+		t.Fatalf("GetGenerator() error = %v", err)
+	}
+
+	if gen.Present != 1 {
+		t.Errorf("Expected generator present, got %d", gen.Present)
+	}
+}
+
+func TestClient_GetEVChargers(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/evse_status.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/check_jwt":
+			w.WriteHeader(http.StatusOK)
+		case "/ivp/evse/status":
+			w.Write(fixture)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(Config{
+		Address: server.URL,
+		Serial:  "123456789",
+		JWT:     "test-jwt",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.httpClient = server.Client()
+
+	chargers, err := client.GetEVChargers()
+	if err != nil {
+		t.Fatalf("GetEVChargers() error = %v", err)
+	}
+
+	if len(chargers.Chargers) != 2 {
+		t.Fatalf("Expected 2 chargers, got %d", len(chargers.Chargers))
+	}
+
+	evse := chargers.Chargers[0]
+	if evse.SerialNumber != "202314000123" {
+		t.Errorf("Expected serial '202314000123', got '%s'", evse.SerialNumber)
+	}
+
+	if evse.State != "charging" || evse.ChargePowerW != 7152.4 {
+		t.Errorf("Expected charging at 7152.4 W, got %s at %f W", evse.State, evse.ChargePowerW)
+	}
+
+	if evse.SessionDurationS != 6300 {
+		t.Errorf("Expected session duration 6300s, got %f", evse.SessionDurationS)
+	}
+
+	if chargers.Chargers[1].FaultCode != "GFCI_TRIP" {
+		t.Errorf("Expected fault code 'GFCI_TRIP', got '%s'", chargers.Chargers[1].FaultCode)
+	}
+}
+
 func TestClient_IsReady(t *testing.T) {
 	client := &Client{
 		ready: false,
@@ -9,6 +9,9 @@ const (
 	EndpointProductionReport  = "/ivp/meters/reports/production"
 	EndpointConsumptionReport = "/ivp/meters/reports/consumption"
 
+	// Legacy production endpoint (slow ~3-5s, used when meter reports are unavailable)
+	EndpointProductionJSON = "/production.json?details=1"
+
 	// Meter endpoints
 	EndpointMeterReadings = "/ivp/meters/readings"
 	EndpointMeters = "/ivp/meters"
@@ -16,6 +19,22 @@ const (
 	// Inverter endpoints
 	EndpointInverters = "/api/v1/production/inverters"
 
+	// Live data endpoints (stream must be enabled before status is populated)
+	EndpointLiveDataStatus = "/ivp/livedata/status"
+	EndpointLiveDataStream = "/ivp/livedata/stream"
+
+	// Ensemble endpoints (IQ System Controller / Enpower sites only)
+	EndpointEnsembleRelay   = "/ivp/ensemble/relay"
+	EndpointEnsembleSecCtrl = "/ivp/ensemble/secctrl"
+	EndpointDryContacts     = "/ivp/ensemble/dry_contacts"
+	EndpointGenerator       = "/ivp/ensemble/generator"
+
+	// System controller settings endpoints
+	EndpointDryContactSettings = "/ivp/ss/dry_contact_settings"
+
+	// EV charger endpoints (IQ EV Chargers paired to the gateway)
+	EndpointEVChargers = "/ivp/evse/status"
+
 	// Inventory endpoints
 	EndpointInventory = "/inventory.json"
 
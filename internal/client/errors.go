@@ -0,0 +1,24 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// StatusError is returned when the gateway responds with a non-200 status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err is caused by the gateway not providing an
+// endpoint, which usually means the firmware or hardware doesn't support it.
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
@@ -99,3 +99,185 @@ type Inverter struct {
 	LastReportWatts int `json:"lastReportWatts"`
 	MaxReportWatts int  `json:"maxReportWatts"`
 }
+
+// LiveDataResponse represents the response from /ivp/livedata/status
+type LiveDataResponse struct {
+	Connection LiveDataConnection `json:"connection"`
+	Meters     LiveDataMeters     `json:"meters"`
+}
+
+// LiveDataConnection describes the state of the gateway's live data stream.
+type LiveDataConnection struct {
+	MqttState string `json:"mqtt_state"`
+	ProvState string `json:"prov_state"`
+	AuthState string `json:"auth_state"`
+	ScStream  string `json:"sc_stream"` // "enabled" or "disabled"
+	ScDebug   string `json:"sc_debug"`
+}
+
+// LiveDataMeters is a single consistent snapshot of all power sources.
+type LiveDataMeters struct {
+	LastUpdate     int64          `json:"last_update"`
+	Soc            float64        `json:"soc"`
+	MainRelayState int            `json:"main_relay_state"` // 1 = closed (on-grid), 0 = open (off-grid)
+	GenRelayState  int            `json:"gen_relay_state"`
+	BackupBatMode  int            `json:"backup_bat_mode"`
+	BackupSoc      float64        `json:"backup_soc"`
+	IsSplitPhase   int            `json:"is_split_phase"`
+	PhaseCount     int            `json:"phase_count"`
+	EncAggSoc      float64        `json:"enc_agg_soc"`
+	EncAggEnergy   float64        `json:"enc_agg_energy"`
+	AcbAggSoc      float64        `json:"acb_agg_soc"`
+	AcbAggEnergy   float64        `json:"acb_agg_energy"`
+	PV             LiveDataSource `json:"pv"`
+	Storage        LiveDataSource `json:"storage"`
+	Grid           LiveDataSource `json:"grid"`
+	Load           LiveDataSource `json:"load"`
+	Generator      LiveDataSource `json:"generator"`
+}
+
+// LiveDataSource contains aggregate and per-phase power for a single source.
+// Values are reported in milliwatts / millivolt-amperes.
+type LiveDataSource struct {
+	AggPMw     float64 `json:"agg_p_mw"`
+	AggSMva    float64 `json:"agg_s_mva"`
+	AggPPhAMw  float64 `json:"agg_p_ph_a_mw"`
+	AggPPhBMw  float64 `json:"agg_p_ph_b_mw"`
+	AggPPhCMw  float64 `json:"agg_p_ph_c_mw"`
+	AggSPhAMva float64 `json:"agg_s_ph_a_mva"`
+	AggSPhBMva float64 `json:"agg_s_ph_b_mva"`
+	AggSPhCMva float64 `json:"agg_s_ph_c_mva"`
+}
+
+// EnsembleRelayResponse represents the response from /ivp/ensemble/relay
+type EnsembleRelayResponse = EnsembleRelayInfo
+
+// EnsembleRelayInfo describes the IQ System Controller main relay.
+type EnsembleRelayInfo struct {
+	MainsAdminState string `json:"mains_admin_state"` // "closed" or "open"
+	MainsOperState  string `json:"mains_oper_state"`  // "closed" or "open"
+	Der1State       int    `json:"der1_state"`
+	Der2State       int    `json:"der2_state"`
+	Der3State       int    `json:"der3_state"`
+	EnchgGridMode   string `json:"Enchg_grid_mode"` // e.g. "multimode-ongrid", "multimode-offgrid"
+	SolarGridMode   string `json:"Solar_grid_mode"`
+}
+
+// EnsembleSecCtrlResponse represents the response from /ivp/ensemble/secctrl
+type EnsembleSecCtrlResponse struct {
+	SecCtrl   EnsembleSecCtrl   `json:"secctrl"`
+	RelayInfo EnsembleRelayInfo `json:"relayInfo"`
+}
+
+// EnsembleSecCtrl contains secondary control state of the ensemble.
+type EnsembleSecCtrl struct {
+	Shutdown     bool    `json:"shutdown"`
+	FreqBiasHz   float64 `json:"freq_bias_hz"`
+	VoltageBiasV float64 `json:"voltage_bias_v"`
+	AggSoc       float64 `json:"agg_soc"`
+	MaxEnergy    float64 `json:"Max_energy"`
+	EncAggSoc    float64 `json:"ENC_agg_soc"`
+	EncAggEnergy float64 `json:"ENC_agg_energy"`
+	AcbAggSoc    float64 `json:"ACB_agg_soc"`
+	AcbAggEnergy float64 `json:"ACB_agg_energy"`
+}
+
+// DryContactStatusResponse represents the response from /ivp/ensemble/dry_contacts
+type DryContactStatusResponse struct {
+	DryContacts []DryContactStatus `json:"dry_contacts"`
+}
+
+// DryContactStatus is the runtime state of a load control relay.
+type DryContactStatus struct {
+	ID     string `json:"id"`     // e.g. "NC1", "NO1"
+	Status string `json:"status"` // "closed" or "open"
+}
+
+// DryContactSettingsResponse represents the response from /ivp/ss/dry_contact_settings
+type DryContactSettingsResponse struct {
+	DryContacts []DryContactSettings `json:"dry_contacts"`
+}
+
+// DryContactSettings is the configuration of a load control relay.
+type DryContactSettings struct {
+	ID                 string  `json:"id"`
+	Type               string  `json:"type"` // "LOAD", "PV", "NONE"
+	LoadName           string  `json:"load_name"`
+	Mode               string  `json:"mode"` // "soc", "manual"
+	SocLow             float64 `json:"soc_low"`
+	SocHigh            float64 `json:"soc_high"`
+	GridAction         string  `json:"grid_action"` // "apply", "shed", "schedule", "none"
+	MicroGridAction    string  `json:"micro_grid_action"`
+	GenAction          string  `json:"gen_action"`
+	EssentialStartTime int     `json:"essential_start_time"` // minutes after midnight
+	EssentialEndTime   int     `json:"essential_end_time"`
+	Priority           int     `json:"priority"`
+	Override           string  `json:"override"`
+	ManualOverride     string  `json:"manual_override"`
+}
+
+// GeneratorResponse represents the response from /ivp/ensemble/generator
+type GeneratorResponse struct {
+	AdminState string  `json:"admin_state"` // "on", "off", "auto"
+	OperState  string  `json:"oper_state"`  // "on" when running, "off" otherwise
+	AdminMode  string  `json:"admin_mode"`  // "auto", "manual"
+	Schedule   int     `json:"schedule"`
+	StartSoc   float64 `json:"start_soc"`
+	StopSoc    float64 `json:"stop_soc"`
+	ExclOn     int     `json:"excl_on"`
+	Present    int     `json:"present"` // 1 if a generator is configured
+	Type       string  `json:"type"`
+}
+
+// EVChargersResponse represents the response from /ivp/evse/status
+type EVChargersResponse struct {
+	Chargers []EVCharger `json:"evse"`
+}
+
+// EVCharger represents a single IQ EV Charger paired to the gateway.
+type EVCharger struct {
+	SerialNumber     string  `json:"serial_number"`
+	Name             string  `json:"name"`
+	State            string  `json:"state"` // "available", "connected", "charging", "suspended", "faulted"
+	Connected        bool    `json:"connected"`
+	ChargePowerW     float64 `json:"charge_power_w"`
+	MaxCurrentA      float64 `json:"max_current_a"`
+	SessionEnergyWh  float64 `json:"session_energy_wh"`
+	SessionStart     int64   `json:"session_start"`
+	SessionDurationS float64 `json:"session_duration_s"`
+	LifetimeEnergyWh float64 `json:"lifetime_energy_wh"`
+	FaultCode        string  `json:"fault_code"`
+}
+
+// ProductionJSONResponse represents the response from /production.json
+type ProductionJSONResponse struct {
+	Production  []ProductionJSONEntry `json:"production"`
+	Consumption []ProductionJSONEntry `json:"consumption"`
+	Storage     []ProductionJSONEntry `json:"storage"`
+}
+
+// ProductionJSONEntry is a single device section of /production.json.
+// Type is "inverters" or "eim" for production/consumption and "acb" for storage.
+type ProductionJSONEntry struct {
+	Type             string                `json:"type"`
+	ActiveCount      int                   `json:"activeCount"`
+	MeasurementType  string                `json:"measurementType,omitempty"` // "production", "total-consumption", "net-consumption"
+	ReadingTime      int64                 `json:"readingTime"`
+	WNow             float64               `json:"wNow"`
+	WhLifetime       float64               `json:"whLifetime"`
+	VarhLeadLifetime float64               `json:"varhLeadLifetime"`
+	VarhLagLifetime  float64               `json:"varhLagLifetime"`
+	VahLifetime      float64               `json:"vahLifetime"`
+	RmsCurrent       float64               `json:"rmsCurrent"`
+	RmsVoltage       float64               `json:"rmsVoltage"`
+	ReactPwr         float64               `json:"reactPwr"`
+	ApprntPwr        float64               `json:"apprntPwr"`
+	PwrFactor        float64               `json:"pwrFactor"`
+	WhToday          float64               `json:"whToday"`
+	WhLastSevenDays  float64               `json:"whLastSevenDays"`
+	VahToday         float64               `json:"vahToday"`
+	WhNow            float64               `json:"whNow"`       // acb only
+	State            string                `json:"state"`       // acb only: "idle", "charging", "discharging"
+	PercentFull      float64               `json:"percentFull"` // acb only
+	Lines            []ProductionJSONEntry `json:"lines,omitempty"`
+}
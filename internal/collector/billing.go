@@ -0,0 +1,279 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Billing periods tracked by BillingCycleCollector.
+const (
+	billingPeriodCycle  = "cycle"
+	billingPeriodTrueUp = "true_up"
+)
+
+// BillingConfig describes a net-metering billing schedule.
+type BillingConfig struct {
+	// CycleStartDay is the day of month a billing cycle starts (1-31).
+	// Months without that day start the cycle on their last day.
+	CycleStartDay int
+	// TrueUpMonth is the month (1-12) whose cycle start begins a new annual
+	// true-up period, or 0 to disable true-up tracking.
+	TrueUpMonth int
+}
+
+// Validate checks the billing configuration.
+func (c BillingConfig) Validate() error {
+	if c.CycleStartDay < 1 || c.CycleStartDay > 31 {
+		return fmt.Errorf("cycle start day must be between 1 and 31, got %d", c.CycleStartDay)
+	}
+	if c.TrueUpMonth < 0 || c.TrueUpMonth > 12 {
+		return fmt.Errorf("true-up month must be 0 (disabled) or 1-12, got %d", c.TrueUpMonth)
+	}
+	return nil
+}
+
+// billingPeriod accumulates grid energy over one billing period.
+type billingPeriod struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// ObservedSince is when data collection began in this period, which is
+	// later than Start if the exporter started mid-period
+	ObservedSince time.Time `json:"observed_since"`
+	// Downtime is how long the exporter was down since ObservedSince, when
+	// no energy was recorded
+	Downtime time.Duration `json:"downtime"`
+	ImportWh float64       `json:"import_wh"`
+	ExportWh float64       `json:"export_wh"`
+}
+
+// BillingCycleCollector tracks net-metering import, export and net energy
+// per billing cycle and true-up year, with an end-of-period projection.
+type BillingCycleCollector struct {
+	config BillingConfig
+	loc    *time.Location
+
+	periods map[string]*billingPeriod
+	mu      sync.Mutex
+
+	energyWh       *prometheus.Desc
+	projectedNetWh *prometheus.Desc
+	periodStart    *prometheus.Desc
+	periodEnd      *prometheus.Desc
+}
+
+// NewBillingCycleCollector creates a BillingCycleCollector. Cycle boundaries
+// are local midnight in loc.
+func NewBillingCycleCollector(config BillingConfig, loc *time.Location) *BillingCycleCollector {
+	return &BillingCycleCollector{
+		config:  config,
+		loc:     loc,
+		periods: make(map[string]*billingPeriod),
+		energyWh: prometheus.NewDesc(
+			"enphase_billing_energy_wh",
+			"Grid energy in the current billing period in watt-hours (net = import - export)",
+			[]string{"flow", "period"},
+			nil,
+		),
+		projectedNetWh: prometheus.NewDesc(
+			"enphase_billing_projected_net_wh",
+			"Projected net grid energy at the end of the billing period at the period-to-date rate",
+			[]string{"period"},
+			nil,
+		),
+		periodStart: prometheus.NewDesc(
+			"enphase_billing_period_start_timestamp",
+			"Unix timestamp of the start of the current billing period",
+			[]string{"period"},
+			nil,
+		),
+		periodEnd: prometheus.NewDesc(
+			"enphase_billing_period_end_timestamp",
+			"Unix timestamp of the end of the current billing period",
+			[]string{"period"},
+			nil,
+		),
+	}
+}
+
+// billingPeriods returns the periods tracked for this configuration.
+func (c *BillingCycleCollector) billingPeriods() []string {
+	if c.config.TrueUpMonth == 0 {
+		return []string{billingPeriodCycle}
+	}
+	return []string{billingPeriodCycle, billingPeriodTrueUp}
+}
+
+// bounds returns the start and end of the billing period containing t.
+func (c *BillingCycleCollector) bounds(period string, t time.Time) (time.Time, time.Time) {
+	if period == billingPeriodTrueUp {
+		t = t.In(c.loc)
+		month := time.Month(c.config.TrueUpMonth)
+		start := billingCycleStart(t.Year(), month, c.config.CycleStartDay, c.loc)
+		if t.Before(start) {
+			start = billingCycleStart(t.Year()-1, month, c.config.CycleStartDay, c.loc)
+		}
+		return start, billingCycleStart(start.Year()+1, month, c.config.CycleStartDay, c.loc)
+	}
+	return billingCycleBounds(t, c.config.CycleStartDay, c.loc)
+}
+
+// billingCycleBounds returns the start and end of the monthly billing cycle
+// containing t for cycles starting on the given day of month.
+func billingCycleBounds(t time.Time, day int, loc *time.Location) (time.Time, time.Time) {
+	t = t.In(loc)
+	start := billingCycleStart(t.Year(), t.Month(), day, loc)
+	if t.Before(start) {
+		start = billingCycleStart(t.Year(), t.Month()-1, day, loc)
+	}
+	return start, billingCycleStart(start.Year(), start.Month()+1, day, loc)
+}
+
+// billingCycleStart returns local midnight on the cycle start day of a month,
+// clamped to the month's last day. Months outside 1-12 are normalized.
+func billingCycleStart(year int, month time.Month, day int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	if lastDay := first.AddDate(0, 1, -1).Day(); day > lastDay {
+		day = lastDay
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, loc)
+}
+
+// roll starts new billing periods whose boundary has passed. Must be called with mu held.
+func (c *BillingCycleCollector) roll(now time.Time) {
+	for _, period := range c.billingPeriods() {
+		p := c.periods[period]
+		if p != nil && !now.Before(p.Start) && now.Before(p.End) {
+			continue
+		}
+		start, end := c.bounds(period, now)
+		c.periods[period] = &billingPeriod{Start: start, End: end, ObservedSince: now}
+	}
+}
+
+// ObserveEnergy implements EnergyObserver.
+func (c *BillingCycleCollector) ObserveEnergy(s EnergySample) {
+	if !s.HasConsumption {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(s.Time)
+	for _, p := range c.periods {
+		p.ImportWh += s.ImportWh
+		p.ExportWh += s.ExportWh
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *BillingCycleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.energyWh
+	ch <- c.projectedNetWh
+	ch <- c.periodStart
+	ch <- c.periodEnd
+}
+
+// Collect implements prometheus.Collector.
+func (c *BillingCycleCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll(now)
+
+	for _, period := range c.billingPeriods() {
+		p := c.periods[period]
+		net := p.ImportWh - p.ExportWh
+
+		for flow, v := range map[string]float64{flowImport: p.ImportWh, flowExport: p.ExportWh, "net": net} {
+			ch <- prometheus.MustNewConstMetric(
+				c.energyWh,
+				prometheus.GaugeValue,
+				v,
+				flow, period,
+			)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.periodStart,
+			prometheus.GaugeValue,
+			float64(p.Start.Unix()),
+			period,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.periodEnd,
+			prometheus.GaugeValue,
+			float64(p.End.Unix()),
+			period,
+		)
+
+		if projected, ok := projectNet(net, p.ObservedSince.Add(p.Downtime), now, p.End); ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.projectedNetWh,
+				prometheus.GaugeValue,
+				projected,
+				period,
+			)
+		}
+	}
+}
+
+// minProjectionWindow avoids wild projections from the first few scrapes.
+const minProjectionWindow = time.Hour
+
+// projectNet extrapolates net energy to the end of the period at the rate
+// observed so far. observedSince is shifted forward by any downtime.
+func projectNet(net float64, observedSince, now, end time.Time) (float64, bool) {
+	observed := now.Sub(observedSince)
+	if observed < minProjectionWindow {
+		return 0, false
+	}
+	remaining := end.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return net + net/observed.Hours()*remaining.Hours(), true
+}
+
+// StateKey implements state.Persister.
+func (c *BillingCycleCollector) StateKey() string {
+	return "billing"
+}
+
+// SaveState implements state.Persister.
+func (c *BillingCycleCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(c.periods)
+}
+
+// RestoreState implements state.Persister. Periods that ended while the
+// exporter was down are replaced on the next roll; otherwise the downtime is
+// left out of the projection rate, as energy wasn't recorded during it.
+func (c *BillingCycleCollector) RestoreState(data json.RawMessage, savedAt time.Time) error {
+	periods := make(map[string]*billingPeriod)
+	if err := json.Unmarshal(data, &periods); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, period := range c.billingPeriods() {
+		p, ok := periods[period]
+		if !ok || p == nil {
+			continue
+		}
+		// Discard periods from a different schedule configuration
+		if start, end := c.bounds(period, p.Start); !start.Equal(p.Start) || !end.Equal(p.End) {
+			continue
+		}
+		if gap := time.Since(savedAt); gap > maxIntegrationGap {
+			p.Downtime += gap
+		}
+		c.periods[period] = p
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rhwendt/enphase-exporter/internal/solar"
+)
+
+// minExpectedFraction is the share of DC capacity the clear-sky model must
+// expect before a performance ratio is reported. Near sunrise and sunset the
+// expected output is small and the ratio meaningless.
+const minExpectedFraction = 0.05
+
+// ClearSkyCollector compares production with a clear-sky model of the array,
+// so a cloudy day can be told apart from a broken array.
+type ClearSkyCollector struct {
+	site  solar.Site
+	array solar.Array
+
+	// Latest production sample and the model's expectation at that time
+	productionW float64
+	expectedW   float64
+	daylight    bool
+	sampled     bool
+	mu          sync.Mutex
+
+	expectedWatts    *prometheus.Desc
+	performanceRatio *prometheus.Desc
+}
+
+// NewClearSkyCollector creates a ClearSkyCollector for an array at site.
+func NewClearSkyCollector(site solar.Site, array solar.Array) *ClearSkyCollector {
+	return &ClearSkyCollector{
+		site:  site,
+		array: array,
+		expectedWatts: prometheus.NewDesc(
+			"enphase_expected_clear_sky_watts",
+			"Expected production under clear skies from the sun position and array orientation",
+			nil,
+			nil,
+		),
+		performanceRatio: prometheus.NewDesc(
+			"enphase_performance_ratio",
+			"Production as a fraction of expected clear-sky production",
+			nil,
+			nil,
+		),
+	}
+}
+
+// ObserveEnergy implements EnergyObserver. The model is evaluated at the
+// sample time so the ratio compares like with like.
+func (c *ClearSkyCollector) ObserveEnergy(s EnergySample) {
+	expected := c.array.ExpectedWatts(solar.SunPosition(s.Time, c.site), c.site)
+	daylight := solar.IsDaylight(s.Time, c.site)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.productionW = s.ProductionW
+	c.expectedW = expected
+	c.daylight = daylight
+	c.sampled = true
+}
+
+// Describe implements prometheus.Collector.
+func (c *ClearSkyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.expectedWatts
+	ch <- c.performanceRatio
+}
+
+// Collect implements prometheus.Collector.
+func (c *ClearSkyCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		c.expectedWatts,
+		prometheus.GaugeValue,
+		c.array.ExpectedWatts(solar.SunPosition(time.Now(), c.site), c.site),
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Zero production at night isn't a fault
+	if c.sampled && c.daylight && c.expectedW >= minExpectedFraction*c.array.DCCapacityWatts {
+		ch <- prometheus.MustNewConstMetric(
+			c.performanceRatio,
+			prometheus.GaugeValue,
+			c.productionW/c.expectedW,
+		)
+	}
+}
+
+// ClearSkyDayFunc returns the expected site energy and peak power under clear
+// skies on the local day starting at day.
+type ClearSkyDayFunc func(day time.Time) (wh, peakW float64)
+
+// clearSkyStep is the integration step for daily clear-sky energy.
+const clearSkyStep = 5 * time.Minute
+
+// Day implements ClearSkyDayFunc by integrating the model over the day.
+func (c *ClearSkyCollector) Day(day time.Time) (wh, peakW float64) {
+	end := day.AddDate(0, 0, 1)
+	for t := day; t.Before(end); t = t.Add(clearSkyStep) {
+		w := c.array.ExpectedWatts(solar.SunPosition(t.Add(clearSkyStep/2), c.site), c.site)
+		wh += w * clearSkyStep.Hours()
+		peakW = max(peakW, w)
+	}
+	return wh, peakW
+}
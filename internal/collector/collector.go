@@ -6,7 +6,15 @@ import "github.com/rhwendt/enphase-exporter/internal/client"
 type EnphaseClient interface {
 	GetProductionReport() (*client.ProductionReportResponse, error)
 	GetConsumptionReport() (*client.ConsumptionReportResponse, error)
+	GetProductionJSON() (*client.ProductionJSONResponse, error)
 	GetMeterReadings() (*client.MeterReadingsResponse, error)
 	GetMeters() (*client.MetersResponse, error)
 	GetInverters() (*client.InvertersResponse, error)
+	GetLiveData() (*client.LiveDataResponse, error)
+	GetEnsembleRelay() (*client.EnsembleRelayResponse, error)
+	GetEnsembleSecCtrl() (*client.EnsembleSecCtrlResponse, error)
+	GetDryContactStatus() (*client.DryContactStatusResponse, error)
+	GetDryContactSettings() (*client.DryContactSettingsResponse, error)
+	GetGenerator() (*client.GeneratorResponse, error)
+	GetEVChargers() (*client.EVChargersResponse, error)
 }
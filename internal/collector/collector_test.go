@@ -1,33 +1,64 @@
 package collector
 
 import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/rhwendt/enphase-exporter/internal/client"
+	"github.com/rhwendt/enphase-exporter/internal/emissions"
+	"github.com/rhwendt/enphase-exporter/internal/layout"
+	"github.com/rhwendt/enphase-exporter/internal/solar"
+	"github.com/rhwendt/enphase-exporter/internal/tariff"
 )
 
 // mockClient implements EnphaseClient for testing
 type mockClient struct {
 	productionReport  *client.ProductionReportResponse
 	consumptionReport *client.ConsumptionReportResponse
+	productionJSON    *client.ProductionJSONResponse
+	reportErr         error
 	inverters         *client.InvertersResponse
 	meterReadings     *client.MeterReadingsResponse
 	meters            *client.MetersResponse
+	metersErr         error
+	liveData          *client.LiveDataResponse
+	ensembleRelay     *client.EnsembleRelayResponse
+	ensembleSecCtrl   *client.EnsembleSecCtrlResponse
+	dryContacts       *client.DryContactStatusResponse
+	dryContactConfig  *client.DryContactSettingsResponse
+	generator         *client.GeneratorResponse
+	evChargers        *client.EVChargersResponse
 	err               error
 }
 
 func (m *mockClient) GetProductionReport() (*client.ProductionReportResponse, error) {
+	if m.reportErr != nil {
+		return nil, m.reportErr
+	}
 	return m.productionReport, m.err
 }
 
 func (m *mockClient) GetConsumptionReport() (*client.ConsumptionReportResponse, error) {
+	if m.reportErr != nil {
+		return nil, m.reportErr
+	}
 	return m.consumptionReport, m.err
 }
 
+func (m *mockClient) GetProductionJSON() (*client.ProductionJSONResponse, error) {
+	return m.productionJSON, m.err
+}
+
 func (m *mockClient) GetInverters() (*client.InvertersResponse, error) {
 	return m.inverters, m.err
 }
@@ -37,9 +68,40 @@ func (m *mockClient) GetMeterReadings() (*client.MeterReadingsResponse, error) {
 }
 
 func (m *mockClient) GetMeters() (*client.MetersResponse, error) {
+	if m.metersErr != nil {
+		return nil, m.metersErr
+	}
 	return m.meters, m.err
 }
 
+func (m *mockClient) GetLiveData() (*client.LiveDataResponse, error) {
+	return m.liveData, m.err
+}
+
+func (m *mockClient) GetEnsembleRelay() (*client.EnsembleRelayResponse, error) {
+	return m.ensembleRelay, m.err
+}
+
+func (m *mockClient) GetEnsembleSecCtrl() (*client.EnsembleSecCtrlResponse, error) {
+	return m.ensembleSecCtrl, m.err
+}
+
+func (m *mockClient) GetDryContactStatus() (*client.DryContactStatusResponse, error) {
+	return m.dryContacts, m.err
+}
+
+func (m *mockClient) GetDryContactSettings() (*client.DryContactSettingsResponse, error) {
+	return m.dryContactConfig, m.err
+}
+
+func (m *mockClient) GetGenerator() (*client.GeneratorResponse, error) {
+	return m.generator, m.err
+}
+
+func (m *mockClient) GetEVChargers() (*client.EVChargersResponse, error) {
+	return m.evChargers, m.err
+}
+
 func TestProductionCollector(t *testing.T) {
 	prodReport := &client.ProductionReportResponse{
 		CreatedAt:  1706400000,
@@ -97,8 +159,8 @@ func TestProductionCollector(t *testing.T) {
 	expectedConsumption := `
 		# HELP enphase_consumption_watts Current consumption in watts
 		# TYPE enphase_consumption_watts gauge
-		enphase_consumption_watts{measurement_type="total-consumption"} 1500
-		enphase_consumption_watts{measurement_type="net-consumption"} -1000
+		enphase_consumption_watts{measurement_type="total-consumption",source="measured"} 1500
+		enphase_consumption_watts{measurement_type="net-consumption",source="measured"} -1000
 	`
 	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedConsumption), "enphase_consumption_watts"); err != nil {
 		t.Errorf("consumption watts mismatch: %v", err)
@@ -124,29 +186,332 @@ func TestProductionCollector(t *testing.T) {
 		t.Errorf("production wh total mismatch: %v", err)
 	}
 
-	// Test grid export/import accumulators exist and have non-negative values.
-	// We use testutil.ToFloat64 via a direct Collect call since elapsed time
-	// between repeated CollectAndCompare calls causes small accumulations.
-	ch := make(chan prometheus.Metric, 100)
-	collector.Collect(ch)
-	close(ch)
+	// Grid counters come from the net CT: delivered = import, received = export
+	expectedGrid := `
+		# HELP enphase_grid_export_wh_total Cumulative energy exported to grid in Wh
+		# TYPE enphase_grid_export_wh_total counter
+		enphase_grid_export_wh_total{source="measured"} 0
+		# HELP enphase_grid_import_wh_total Cumulative energy imported from grid in Wh
+		# TYPE enphase_grid_import_wh_total counter
+		enphase_grid_import_wh_total{source="measured"} 1.5e+06
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedGrid),
+		"enphase_grid_export_wh_total", "enphase_grid_import_wh_total"); err != nil {
+		t.Errorf("grid energy mismatch: %v", err)
+	}
+}
 
-	var foundExport, foundImport bool
-	for m := range ch {
-		desc := m.Desc().String()
-		if strings.Contains(desc, "enphase_grid_export_wh_total") {
-			foundExport = true
-		}
-		if strings.Contains(desc, "enphase_grid_import_wh_total") {
-			foundImport = true
-		}
+func TestProductionCollector_LegacyFallback(t *testing.T) {
+	mock := &mockClient{
+		reportErr: &client.StatusError{StatusCode: 404},
+		productionJSON: &client.ProductionJSONResponse{
+			Production: []client.ProductionJSONEntry{
+				{Type: "inverters", ActiveCount: 30, WNow: 2410, WhLifetime: 14982211},
+				{
+					Type:            "eim",
+					ActiveCount:     1,
+					MeasurementType: "production",
+					WNow:            2455,
+					WhLifetime:      60020905, // doubled on split-phase
+					RmsVoltage:      241.3,
+					WhToday:         18220,
+					WhLastSevenDays: 152870,
+					Lines: []client.ProductionJSONEntry{
+						{WhLifetime: 15005226},
+						{WhLifetime: 15005226},
+					},
+				},
+			},
+			Consumption: []client.ProductionJSONEntry{
+				{Type: "eim", ActiveCount: 1, MeasurementType: "total-consumption", WNow: 1200, WhLifetime: 42120002, WhToday: 21400},
+			},
+			Storage: []client.ProductionJSONEntry{
+				{Type: "acb", ActiveCount: 0},
+			},
+		},
+	}
+
+	collector := NewProductionCollector(mock)
+
+	expected := `
+		# HELP enphase_production_watts Current solar production in watts
+		# TYPE enphase_production_watts gauge
+		enphase_production_watts{device_type="eim"} 2455
+		enphase_production_watts{device_type="inverters"} 2410
+		# HELP enphase_production_wh_total Total lifetime production in watt-hours
+		# TYPE enphase_production_wh_total counter
+		enphase_production_wh_total{device_type="eim"} 3.0010452e+07
+		enphase_production_wh_total{device_type="inverters"} 1.4982211e+07
+		# HELP enphase_production_wh_today Production today in watt-hours as reported by the gateway
+		# TYPE enphase_production_wh_today gauge
+		enphase_production_wh_today{device_type="eim"} 18220
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"enphase_production_watts", "enphase_production_wh_total", "enphase_production_wh_today"); err != nil {
+		t.Errorf("legacy production mismatch: %v", err)
+	}
+
+	// Net power uses the production CT rather than the inverter aggregate
+	expectedNet := `
+		# HELP enphase_net_watts Net power (production - consumption). Positive = exporting, negative = importing
+		# TYPE enphase_net_watts gauge
+		enphase_net_watts 1255
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedNet), "enphase_net_watts"); err != nil {
+		t.Errorf("legacy net watts mismatch: %v", err)
+	}
+
+	// Inactive storage sections are skipped
+	if n := testutil.CollectAndCount(collector, "enphase_storage_watts"); n != 0 {
+		t.Errorf("expected no storage metrics for inactive acb, got %d", n)
+	}
+
+	// The report endpoints are probed again once the refresh interval passes
+	mock.reportErr = nil
+	mock.productionReport = &client.ProductionReportResponse{
+		ReportType: "production",
+		Cumulative: client.MeterReportData{CurrW: 2500},
+	}
+	testutil.CollectAndCount(collector)
+	if !collector.legacy {
+		t.Error("expected legacy mode until the next probe")
+	}
+	collector.legacyProbe = time.Now().Add(-meterTypeRefreshInterval - time.Minute)
+	testutil.CollectAndCount(collector)
+	if collector.legacy {
+		t.Error("expected report endpoints to be used again after a successful probe")
+	}
+}
+
+func TestProductionCollector_MeterRetry(t *testing.T) {
+	mock := &mockClient{
+		productionReport: &client.ProductionReportResponse{
+			ReportType: "production",
+			Cumulative: client.MeterReportData{CurrW: 2500},
+		},
+	}
+	collector := NewProductionCollector(mock)
+
+	// Without meter metadata the next attempt waits for the retry interval
+	testutil.CollectAndCount(collector)
+	failedAt := collector.lastMeterRefresh
+	if failedAt.IsZero() || collector.meterRolesKnown {
+		t.Fatalf("expected a failed refresh to be recorded, got %v", failedAt)
+	}
+	testutil.CollectAndCount(collector)
+	if !collector.lastMeterRefresh.Equal(failedAt) {
+		t.Error("expected no retry within the retry interval")
 	}
-	if !foundExport {
-		t.Error("enphase_grid_export_wh_total metric not found")
+
+	mock.meters = &client.MetersResponse{{Eid: 1, State: "enabled", MeasurementType: "production"}}
+	collector.lastMeterRefresh = time.Now().Add(-meterRetryInterval - time.Second)
+	testutil.CollectAndCount(collector)
+	if !collector.meterRolesKnown {
+		t.Error("expected meter roles after a successful retry")
+	}
+}
+
+func TestProductionCollector_ProductionOnly(t *testing.T) {
+	mock := &mockClient{
+		productionReport: &client.ProductionReportResponse{
+			ReportType: "production",
+			Cumulative: client.MeterReportData{CurrW: 2500, WhDlvdCum: 1500000},
+		},
+		// Stale consumption data must be ignored when no consumption CT is enabled
+		consumptionReport: &client.ConsumptionReportResponse{
+			{ReportType: "total-consumption", Cumulative: client.MeterReportData{CurrW: 0}},
+		},
+		meters: &client.MetersResponse{
+			{Eid: 1, State: "enabled", MeasurementType: "production"},
+			{Eid: 2, State: "disabled", MeasurementType: "net-consumption"},
+		},
+	}
+
+	collector := NewProductionCollector(mock)
+
+	expected := `
+		# HELP enphase_meter_configured Whether an enabled CT meter with this measurement type is installed
+		# TYPE enphase_meter_configured gauge
+		enphase_meter_configured{measurement_type="net-consumption"} 0
+		enphase_meter_configured{measurement_type="production"} 1
+		enphase_meter_configured{measurement_type="total-consumption"} 0
+		# HELP enphase_production_watts Current solar production in watts
+		# TYPE enphase_production_watts gauge
+		enphase_production_watts{device_type="eim"} 2500
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"enphase_meter_configured", "enphase_production_watts"); err != nil {
+		t.Errorf("production-only mismatch: %v", err)
+	}
+
+	for _, name := range []string{"enphase_consumption_watts", "enphase_net_watts", "enphase_grid_export_wh_total"} {
+		if n := testutil.CollectAndCount(collector, name); n != 0 {
+			t.Errorf("expected no %s without consumption CTs, got %d series", name, n)
+		}
 	}
-	if !foundImport {
-		t.Error("enphase_grid_import_wh_total metric not found")
+}
+
+func TestProductionCollector_DerivedConsumption(t *testing.T) {
+	prodReport := &client.ProductionReportResponse{
+		ReportType: "production",
+		Cumulative: client.MeterReportData{CurrW: 3000, WhDlvdCum: 1000000},
 	}
+
+	t.Run("net CT", func(t *testing.T) {
+		mock := &mockClient{
+			productionReport: prodReport,
+			consumptionReport: &client.ConsumptionReportResponse{
+				{ReportType: "net-consumption", Cumulative: client.MeterReportData{CurrW: -1800, WhDlvdCum: 400000, WhRcvdCum: 650000}},
+			},
+			meters: &client.MetersResponse{
+				{Eid: 1, State: "enabled", MeasurementType: "production"},
+				{Eid: 2, State: "enabled", MeasurementType: "net-consumption"},
+			},
+		}
+
+		// total = 3000 + (-1800) W; energy = 1000000 + (400000 - 650000) Wh
+		expected := `
+			# HELP enphase_consumption_watts Current consumption in watts
+			# TYPE enphase_consumption_watts gauge
+			enphase_consumption_watts{measurement_type="net-consumption",source="measured"} -1800
+			enphase_consumption_watts{measurement_type="total-consumption",source="derived"} 1200
+			# HELP enphase_consumption_wh_total Total lifetime consumption in watt-hours
+			# TYPE enphase_consumption_wh_total counter
+			enphase_consumption_wh_total{measurement_type="net-consumption",source="measured"} 400000
+			enphase_consumption_wh_total{measurement_type="total-consumption",source="derived"} 750000
+			# HELP enphase_net_watts Net power (production - consumption). Positive = exporting, negative = importing
+			# TYPE enphase_net_watts gauge
+			enphase_net_watts 1800
+		`
+		if err := testutil.CollectAndCompare(NewProductionCollector(mock), strings.NewReader(expected),
+			"enphase_consumption_watts", "enphase_consumption_wh_total", "enphase_net_watts"); err != nil {
+			t.Errorf("net CT mismatch: %v", err)
+		}
+	})
+
+	t.Run("total CT", func(t *testing.T) {
+		mock := &mockClient{
+			productionReport: prodReport,
+			consumptionReport: &client.ConsumptionReportResponse{
+				{ReportType: "total-consumption", Cumulative: client.MeterReportData{CurrW: 4200, WhDlvdCum: 2500000}},
+			},
+			meters: &client.MetersResponse{
+				{Eid: 1, State: "enabled", MeasurementType: "production"},
+				{Eid: 2, State: "enabled", MeasurementType: "total-consumption"},
+			},
+		}
+
+		// net = 4200 - 3000 W; derived net energy isn't exported
+		expected := `
+			# HELP enphase_consumption_watts Current consumption in watts
+			# TYPE enphase_consumption_watts gauge
+			enphase_consumption_watts{measurement_type="net-consumption",source="derived"} 1200
+			enphase_consumption_watts{measurement_type="total-consumption",source="measured"} 4200
+			# HELP enphase_net_watts Net power (production - consumption). Positive = exporting, negative = importing
+			# TYPE enphase_net_watts gauge
+			enphase_net_watts -1200
+		`
+		collector := NewProductionCollector(mock)
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+			"enphase_consumption_watts", "enphase_net_watts"); err != nil {
+			t.Errorf("total CT mismatch: %v", err)
+		}
+		if n := testutil.CollectAndCount(collector, "enphase_consumption_wh_total"); n != 1 {
+			t.Errorf("expected only the measured energy series, got %d", n)
+		}
+	})
+}
+
+func TestProductionCollector_GridEnergy(t *testing.T) {
+	t.Run("legacy net meter readings", func(t *testing.T) {
+		mock := &mockClient{
+			reportErr: &client.StatusError{StatusCode: 404},
+			productionJSON: &client.ProductionJSONResponse{
+				Production: []client.ProductionJSONEntry{
+					{Type: "eim", ActiveCount: 1, MeasurementType: "production", WNow: 2000},
+				},
+				Consumption: []client.ProductionJSONEntry{
+					{Type: "eim", ActiveCount: 1, MeasurementType: "net-consumption", WNow: -800},
+				},
+			},
+			meters: &client.MetersResponse{
+				{Eid: 1, State: "enabled", MeasurementType: "production"},
+				{Eid: 2, State: "enabled", MeasurementType: "net-consumption"},
+			},
+			meterReadings: &client.MeterReadingsResponse{
+				{Eid: 1, ActEnergyDlvd: 9000000},
+				{
+					Eid:           2,
+					ActEnergyDlvd: 8000000, // doubled on split-phase
+					ActEnergyRcvd: 6000000,
+					Channels: []client.MeterChannel{
+						{ActEnergyDlvd: 2000000, ActEnergyRcvd: 1500000},
+						{ActEnergyDlvd: 2000000, ActEnergyRcvd: 1500000},
+					},
+				},
+			},
+		}
+
+		expected := `
+			# HELP enphase_grid_export_wh_total Cumulative energy exported to grid in Wh
+			# TYPE enphase_grid_export_wh_total counter
+			enphase_grid_export_wh_total{source="measured"} 3e+06
+			# HELP enphase_grid_import_wh_total Cumulative energy imported from grid in Wh
+			# TYPE enphase_grid_import_wh_total counter
+			enphase_grid_import_wh_total{source="measured"} 4e+06
+		`
+		collector := NewProductionCollector(mock)
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+			"enphase_grid_export_wh_total", "enphase_grid_import_wh_total"); err != nil {
+			t.Errorf("legacy grid energy mismatch: %v", err)
+		}
+
+		// A failed meter read re-emits the last measured values rather than
+		// switching to the integrated series
+		mock.meterReadings = nil
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+			"enphase_grid_export_wh_total", "enphase_grid_import_wh_total"); err != nil {
+			t.Errorf("grid energy after failed read mismatch: %v", err)
+		}
+	})
+
+	t.Run("integrated without net CT", func(t *testing.T) {
+		mock := &mockClient{
+			productionReport: &client.ProductionReportResponse{
+				ReportType: "production",
+				Cumulative: client.MeterReportData{CurrW: 3000},
+			},
+			consumptionReport: &client.ConsumptionReportResponse{
+				{ReportType: "total-consumption", Cumulative: client.MeterReportData{CurrW: 1000}},
+			},
+			meters: &client.MetersResponse{
+				{Eid: 2, State: "enabled", MeasurementType: "total-consumption"},
+			},
+		}
+
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(NewProductionCollector(mock))
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sources := make(map[string]string)
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "source" {
+						sources[mf.GetName()] = l.GetValue()
+					}
+				}
+			}
+		}
+		for _, name := range []string{"enphase_grid_export_wh_total", "enphase_grid_import_wh_total"} {
+			if sources[name] != "integrated" {
+				t.Errorf("expected %s source=integrated, got %q", name, sources[name])
+			}
+		}
+	})
 }
 
 func TestInvertersCollector(t *testing.T) {
@@ -167,7 +532,7 @@ func TestInvertersCollector(t *testing.T) {
 		},
 	}
 
-	collector := NewInvertersCollector(mock)
+	collector := NewInvertersCollector(mock, InvertersConfig{})
 
 	expected := `
 		# HELP enphase_inverter_watts Current inverter production in watts
@@ -190,56 +555,1567 @@ func TestInvertersCollector(t *testing.T) {
 	}
 }
 
-func TestMetersCollector(t *testing.T) {
-	mock := &mockClient{
-		meterReadings: &client.MeterReadingsResponse{
-			{
-				Eid:         12345,
-				Voltage:     240.5,
-				Current:     10.3,
-				ActivePower: 2450.0,
-				Freq:        60.0,
-				PwrFactor:   0.99,
-				Channels: []client.MeterChannel{
-					{Voltage: 120.2, Current: 5.1, ActivePower: 612.0, PwrFactor: 0.98},
-					{Voltage: 120.3, Current: 5.2, ActivePower: 625.0, PwrFactor: 0.99},
-				},
-			},
-		},
-		meters: &client.MetersResponse{
-			{
-				Eid:             12345,
-				State:           "enabled",
-				MeasurementType: "production",
-				PhaseMode:       "split",
-				PhaseCount:      2,
-				MeteringStatus:  "normal",
-			},
-		},
+func TestInvertersCollector_Layout(t *testing.T) {
+	l, err := layout.New([]layout.Panel{
+		{SerialNumber: "INV001", Array: "south", RoofFace: "main", Azimuth: 180, Tilt: 25, String: "A", Row: 1, Column: 1},
+		{SerialNumber: "INV002", Array: "south", RoofFace: "main", Azimuth: 180, Tilt: 25, String: "A", Row: 1, Column: 2},
+		{SerialNumber: "INV003", Array: "west"},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	collector := NewMetersCollector(mock)
+	// INV003 is mapped but not reporting; INV009 reports but isn't mapped
+	inverters := client.InvertersResponse{
+		{SerialNumber: "INV001", LastReportDate: 1704067200, LastReportWatts: 300},
+		{SerialNumber: "INV002", LastReportDate: 1704067200, LastReportWatts: 240},
+		{SerialNumber: "INV009", LastReportDate: 1704067200, LastReportWatts: 100},
+	}
+	mock := &mockClient{inverters: &inverters}
+	collector := NewInvertersCollector(mock, InvertersConfig{Labels: InverterLabels{Layout: l, Position: true}})
+	testutil.CollectAndCount(collector)
+
+	// Ten minutes later: 300 W and 240 W averaged over 1/6 h; a report after
+	// a gap longer than maxReportGap adds nothing
+	inverters[0].LastReportDate += 600
+	inverters[1].LastReportDate += 600
+	inverters[2].LastReportDate += 3600
 
-	// Test frequency (only total, not per-phase)
 	expected := `
-		# HELP enphase_frequency_hz Grid frequency in Hz
-		# TYPE enphase_frequency_hz gauge
-		enphase_frequency_hz{measurement_type="production",meter_id="12345"} 60
+		# HELP enphase_array_energy_wh_total Array energy integrated from inverter reports in watt-hours
+		# TYPE enphase_array_energy_wh_total counter
+		enphase_array_energy_wh_total{array="south"} 90
+		enphase_array_energy_wh_total{array="unmapped"} 0
+		# HELP enphase_array_inverters Number of inverters reporting in the array
+		# TYPE enphase_array_inverters gauge
+		enphase_array_inverters{array="south"} 2
+		enphase_array_inverters{array="unmapped"} 1
+		# HELP enphase_array_watts Sum of the latest inverter reports in the array in watts
+		# TYPE enphase_array_watts gauge
+		enphase_array_watts{array="south"} 540
+		enphase_array_watts{array="unmapped"} 100
+		# HELP enphase_inverter_layout_info Physical placement of a mapped inverter (always 1)
+		# TYPE enphase_inverter_layout_info gauge
+		enphase_inverter_layout_info{array="south",azimuth="180",column="1",roof_face="main",row="1",serial_number="INV001",string="A",tilt="25"} 1
+		enphase_inverter_layout_info{array="south",azimuth="180",column="2",roof_face="main",row="1",serial_number="INV002",string="A",tilt="25"} 1
+		enphase_inverter_layout_info{array="west",azimuth="0",column="",roof_face="",row="",serial_number="INV003",string="",tilt="0"} 1
+		# HELP enphase_inverter_watts Current inverter production in watts
+		# TYPE enphase_inverter_watts gauge
+		enphase_inverter_watts{array="south",column="1",row="1",serial_number="INV001",string="A"} 300
+		enphase_inverter_watts{array="south",column="2",row="1",serial_number="INV002",string="A"} 240
+		enphase_inverter_watts{array="unmapped",column="",row="",serial_number="INV009",string=""} 100
 	`
-	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "enphase_frequency_hz"); err != nil {
-		t.Errorf("frequency mismatch: %v", err)
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"enphase_array_energy_wh_total", "enphase_array_inverters", "enphase_array_watts",
+		"enphase_inverter_layout_info", "enphase_inverter_watts"); err != nil {
+		t.Errorf("layout metrics mismatch: %v", err)
 	}
+}
 
-	// Test voltage (total and per-phase)
-	expectedVoltage := `
-		# HELP enphase_voltage_volts Grid voltage in volts
-		# TYPE enphase_voltage_volts gauge
-		enphase_voltage_volts{measurement_type="production",meter_id="12345",phase="total"} 240.5
-		enphase_voltage_volts{measurement_type="production",meter_id="12345",phase="L1"} 120.2
-		enphase_voltage_volts{measurement_type="production",meter_id="12345",phase="L2"} 120.3
+func TestInvertersCollector_Fleet(t *testing.T) {
+	inverters := client.InvertersResponse{
+		{SerialNumber: "INV001", LastReportWatts: 100},
+		{SerialNumber: "INV002", LastReportWatts: 100},
+		{SerialNumber: "INV003", LastReportWatts: 300},
+		{SerialNumber: "INV004", LastReportWatts: 300},
+	}
+	mock := &mockClient{inverters: &inverters}
+	collector := NewInvertersCollector(mock, InvertersConfig{})
+
+	expected := `
+		# HELP enphase_inverters_watts Aggregate of the latest inverter reports in watts
+		# TYPE enphase_inverters_watts gauge
+		enphase_inverters_watts{stat="max"} 300
+		enphase_inverters_watts{stat="median"} 200
+		enphase_inverters_watts{stat="min"} 100
+		enphase_inverters_watts{stat="stddev"} 100
+		enphase_inverters_watts{stat="sum"} 800
 	`
-	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedVoltage), "enphase_voltage_volts"); err != nil {
-		t.Errorf("voltage mismatch: %v", err)
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "enphase_inverters_watts"); err != nil {
+		t.Errorf("fleet metrics mismatch: %v", err)
+	}
+
+	// INV002 drops out of the gateway's list but is still expected
+	mock.inverters = &client.InvertersResponse{inverters[0], inverters[2], inverters[3]}
+	expectedMissing := `
+		# HELP enphase_inverter_missing Whether an expected inverter is missing from the gateway's inverter list (1 = missing)
+		# TYPE enphase_inverter_missing gauge
+		enphase_inverter_missing{serial_number="INV001"} 0
+		enphase_inverter_missing{serial_number="INV002"} 1
+		enphase_inverter_missing{serial_number="INV003"} 0
+		enphase_inverter_missing{serial_number="INV004"} 0
+		# HELP enphase_inverters_expected Number of inverters expected to report
+		# TYPE enphase_inverters_expected gauge
+		enphase_inverters_expected 4
+		# HELP enphase_inverters_reporting Number of inverters returned by the gateway whose report isn't stale
+		# TYPE enphase_inverters_reporting gauge
+		enphase_inverters_reporting 3
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedMissing),
+		"enphase_inverter_missing", "enphase_inverters_expected", "enphase_inverters_reporting"); err != nil {
+		t.Errorf("completeness metrics mismatch: %v", err)
+	}
+
+	// The learned inventory survives a restart
+	data, err := collector.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewInvertersCollector(mock, InvertersConfig{})
+	if err := restored.RestoreState(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.CollectAndCompare(restored, strings.NewReader(expectedMissing),
+		"enphase_inverter_missing", "enphase_inverters_expected", "enphase_inverters_reporting"); err != nil {
+		t.Errorf("restored completeness metrics mismatch: %v", err)
+	}
+
+	// A replaced inverter is forgotten once it has been gone long enough
+	forgetting := NewInvertersCollector(mock, InvertersConfig{ForgetAfter: 24 * time.Hour})
+	if err := forgetting.RestoreState(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if forgetting.seen["INV002"].IsZero() {
+		t.Fatal("expected last-seen times to be restored")
+	}
+	forgetting.seen["INV002"] = time.Now().Add(-48 * time.Hour)
+	expectedForgotten := `
+		# HELP enphase_inverters_expected Number of inverters expected to report
+		# TYPE enphase_inverters_expected gauge
+		enphase_inverters_expected 3
+	`
+	if err := testutil.CollectAndCompare(forgetting, strings.NewReader(expectedForgotten), "enphase_inverters_expected"); err != nil {
+		t.Errorf("forgotten inventory mismatch: %v", err)
+	}
+
+	// A configured inventory isn't extended by unexpected inverters
+	configured := NewInvertersCollector(mock, InvertersConfig{Expected: []string{"INV001", "INV005"}})
+	expectedConfigured := `
+		# HELP enphase_inverter_missing Whether an expected inverter is missing from the gateway's inverter list (1 = missing)
+		# TYPE enphase_inverter_missing gauge
+		enphase_inverter_missing{serial_number="INV001"} 0
+		enphase_inverter_missing{serial_number="INV005"} 1
+		# HELP enphase_inverters_expected Number of inverters expected to report
+		# TYPE enphase_inverters_expected gauge
+		enphase_inverters_expected 2
+	`
+	if err := testutil.CollectAndCompare(configured, strings.NewReader(expectedConfigured),
+		"enphase_inverter_missing", "enphase_inverters_expected"); err != nil {
+		t.Errorf("configured inventory mismatch: %v", err)
+	}
+}
+
+func TestInvertersCollector_Stale(t *testing.T) {
+	now := time.Now().Unix()
+	inverters := client.InvertersResponse{
+		{SerialNumber: "INV001", LastReportDate: now - 60, LastReportWatts: 200},
+		{SerialNumber: "INV002", LastReportDate: now - 7200, LastReportWatts: 150},
+	}
+	mock := &mockClient{inverters: &inverters}
+	sunUp := true
+	config := InvertersConfig{
+		StaleAfter:  30 * time.Minute,
+		StaleAction: StaleZero,
+		StallAfter:  time.Hour,
+		Daylight:    func(time.Time) bool { return sunUp },
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	zeroed := NewInvertersCollector(mock, config)
+	recorder := &inverterRecorder{}
+	zeroed.AddObserver(recorder)
+	expected := `
+		# HELP enphase_inverter_watts Current inverter production in watts
+		# TYPE enphase_inverter_watts gauge
+		enphase_inverter_watts{serial_number="INV001"} 200
+		enphase_inverter_watts{serial_number="INV002"} 0
+		# HELP enphase_inverters_poll_stalled Whether no inverter has reported for the stall threshold while the sun is up (1 = stalled)
+		# TYPE enphase_inverters_poll_stalled gauge
+		enphase_inverters_poll_stalled 0
+	`
+	if err := testutil.CollectAndCompare(zeroed, strings.NewReader(expected),
+		"enphase_inverter_watts", "enphase_inverters_poll_stalled"); err != nil {
+		t.Errorf("zeroed metrics mismatch: %v", err)
+	}
+	if n := testutil.CollectAndCount(zeroed, "enphase_inverter_report_age_seconds"); n != 2 {
+		t.Errorf("expected report age for 2 inverters, got %d", n)
+	}
+	if got := recorder.inverters[0][1].LastReportWatts; got != 0 {
+		t.Errorf("expected observers to see stale watts zeroed, got %d", got)
+	}
+
+	config.StaleAction = StaleDrop
+	dropped := NewInvertersCollector(mock, config)
+	droppedRecorder := &inverterRecorder{}
+	dropped.AddObserver(droppedRecorder)
+	expectedDropped := `
+		# HELP enphase_inverter_watts Current inverter production in watts
+		# TYPE enphase_inverter_watts gauge
+		enphase_inverter_watts{serial_number="INV001"} 200
+		# HELP enphase_inverters_reporting Number of inverters returned by the gateway whose report isn't stale
+		# TYPE enphase_inverters_reporting gauge
+		enphase_inverters_reporting 1
+	`
+	if err := testutil.CollectAndCompare(dropped, strings.NewReader(expectedDropped),
+		"enphase_inverter_watts", "enphase_inverters_reporting"); err != nil {
+		t.Errorf("dropped metrics mismatch: %v", err)
+	}
+	if got := droppedRecorder.inverters[0]; len(got) != 2 || got[1].LastReportWatts != 0 {
+		t.Errorf("expected observers to see dropped inverters zeroed, got %+v", got)
+	}
+
+	// No new report for over an hour is a stall, but only while the sun is up
+	inverters[0].LastReportDate = now - 5400
+	expectedStalled := `
+		# HELP enphase_inverters_poll_stalled Whether no inverter has reported for the stall threshold while the sun is up (1 = stalled)
+		# TYPE enphase_inverters_poll_stalled gauge
+		enphase_inverters_poll_stalled 1
+	`
+	if err := testutil.CollectAndCompare(dropped, strings.NewReader(expectedStalled), "enphase_inverters_poll_stalled"); err != nil {
+		t.Errorf("stalled mismatch: %v", err)
+	}
+	sunUp = false
+	if err := testutil.CollectAndCompare(dropped, strings.NewReader(strings.Replace(expectedStalled, "stalled 1", "stalled 0", 1)),
+		"enphase_inverters_poll_stalled"); err != nil {
+		t.Errorf("night stall mismatch: %v", err)
+	}
+
+	// Inverters stop reporting every night, so stalls need the site location
+	config.Daylight = nil
+	if n := testutil.CollectAndCount(NewInvertersCollector(mock, config), "enphase_inverters_poll_stalled"); n != 0 {
+		t.Errorf("expected no stall flag without daylight, got %d", n)
+	}
+
+	config.StaleAction = "hide"
+	if err := config.Validate(); err == nil {
+		t.Error("expected error for unknown stale action")
+	}
+}
+
+func TestInvertersCollector_Energy(t *testing.T) {
+	now := time.Now().Unix()
+	inverters := client.InvertersResponse{
+		{SerialNumber: "INV001", LastReportDate: now - 600, LastReportWatts: 300},
+		{SerialNumber: "INV002", LastReportDate: now - 600, LastReportWatts: 240},
+	}
+	mock := &mockClient{inverters: &inverters}
+	config := InvertersConfig{Location: time.UTC}
+	collector := NewInvertersCollector(mock, config)
+	testutil.CollectAndCount(collector)
+
+	// 360 W and 120 W averaged over the 10 minutes since the last report
+	inverters[0].LastReportDate, inverters[0].LastReportWatts = now, 360
+	inverters[1].LastReportDate, inverters[1].LastReportWatts = now, 120
+	expected := `
+		# HELP enphase_inverter_energy_today_wh Inverter energy integrated from its reports since local midnight in watt-hours
+		# TYPE enphase_inverter_energy_today_wh gauge
+		enphase_inverter_energy_today_wh{serial_number="INV001"} 60
+		enphase_inverter_energy_today_wh{serial_number="INV002"} 20
+		# HELP enphase_inverter_energy_wh_total Inverter energy integrated from its reports in watt-hours
+		# TYPE enphase_inverter_energy_wh_total counter
+		enphase_inverter_energy_wh_total{serial_number="INV001"} 60
+		enphase_inverter_energy_wh_total{serial_number="INV002"} 20
+		# HELP enphase_inverters_energy_wh_total Energy integrated from all inverter reports in watt-hours
+		# TYPE enphase_inverters_energy_wh_total counter
+		enphase_inverters_energy_wh_total 80
+	`
+	names := []string{"enphase_inverter_energy_today_wh", "enphase_inverter_energy_wh_total", "enphase_inverters_energy_wh_total"}
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), names...); err != nil {
+		t.Errorf("energy metrics mismatch: %v", err)
+	}
+
+	// Counters survive a restart, and a gap longer than maxReportGap after
+	// it isn't integrated
+	data, err := collector.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewInvertersCollector(mock, config)
+	if err := restored.RestoreState(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	inverters[0].LastReportDate += 3600
+	inverters[1].LastReportDate += 3600
+	if err := testutil.CollectAndCompare(restored, strings.NewReader(expected), names...); err != nil {
+		t.Errorf("restored energy metrics mismatch: %v", err)
+	}
+
+	// Daily energy resets with the first report of a new day
+	e := &inverterEnergy{}
+	midnight := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+	e.update(midnight.Add(-10*time.Minute).Unix(), 100, time.UTC)
+	e.update(midnight.Add(-5*time.Minute).Unix(), 120, time.UTC)
+	e.update(midnight.Add(5*time.Minute).Unix(), 60, time.UTC)
+	if e.Wh != 20 || e.DayWh != 10 {
+		t.Errorf("expected 20 Wh lifetime and 10 Wh today, got %v and %v", e.Wh, e.DayWh)
+	}
+	if got := e.today(midnight.Add(24*time.Hour), time.UTC); got != 0 {
+		t.Errorf("expected no energy the next day, got %v", got)
+	}
+}
+
+// clearDay returns 5-minute inverter reports of a clear day whose unclipped
+// output is a parabola peaking at peakW at noon, clipped at ratingW.
+func clearDay(serial string, peakW, ratingW float64) [][]client.Inverter {
+	noon := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	var reports [][]client.Inverter
+	for t := noon.Add(-5 * time.Hour); !t.After(noon.Add(5 * time.Hour)); t = t.Add(5 * time.Minute) {
+		// Reports average the interval before them
+		x := t.Add(-150*time.Second).Sub(noon).Hours() / 5
+		watts := math.Min(math.Max(peakW*(1-x*x), 0), ratingW)
+		reports = append(reports, []client.Inverter{{
+			SerialNumber:    serial,
+			LastReportDate:  t.Unix(),
+			LastReportWatts: int(math.Round(watts)),
+		}})
+	}
+	return reports
+}
+
+func TestInverterClippingCollector(t *testing.T) {
+	if rating, ok := InverterModelRating("IQ7PLUS-72-2-US"); !ok || rating != 290 {
+		t.Errorf("unexpected IQ7+ rating %v (ok=%v)", rating, ok)
+	}
+	if rating, ok := InverterModelRating("iq7+"); !ok || rating != 290 {
+		t.Errorf("unexpected iq7+ rating %v (ok=%v)", rating, ok)
+	}
+
+	config := ClippingConfig{
+		DefaultRating: 290,
+		Ratings:       map[string]float64{"INV003": 380},
+		Margin:        0.02,
+		MinDuration:   15 * time.Minute,
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	collector := NewInverterClippingCollector(config)
+
+	// INV001 is clipped for about 3 hours around noon; INV002 peaks just
+	// below its rating; INV003 has a higher rating and never clips
+	clipped := clearDay("INV001", 320, 290)
+	unclipped := clearDay("INV002", 280, 290)
+	larger := clearDay("INV003", 320, 380)
+	for i := range clipped {
+		collector.ObserveInverters(time.Unix(clipped[i][0].LastReportDate, 0), []client.Inverter{clipped[i][0], unclipped[i][0], larger[i][0]})
+		if clipped[i][0].LastReportDate == time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC).Unix() {
+			expected := `
+				# HELP enphase_inverters_clipping_fraction Fraction of rated inverters currently clipping
+				# TYPE enphase_inverters_clipping_fraction gauge
+				enphase_inverters_clipping_fraction 0.3333333333333333
+			`
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "enphase_inverters_clipping_fraction"); err != nil {
+				t.Errorf("midday clipping fraction mismatch: %v", err)
+			}
+		}
+	}
+
+	tracker := collector.trackers["INV001"]
+	// The true plateau above 290 W lasts 3.06 h; the margin widens it slightly
+	if hours := tracker.Seconds / 3600; hours < 3 || hours > 3.4 {
+		t.Errorf("expected about 3.1 h of clipping, got %.2f h", hours)
+	}
+	// The energy above 290 W is 61.2 Wh
+	if math.Abs(tracker.ClippedWh-61.2) > 3 {
+		t.Errorf("expected about 61.2 Wh clipped, got %.1f Wh", tracker.ClippedWh)
+	}
+	for _, serial := range []string{"INV002", "INV003"} {
+		if tr := collector.trackers[serial]; tr.Seconds != 0 || tr.ClippedWh != 0 {
+			t.Errorf("%s: expected no clipping, got %v s and %v Wh", serial, tr.Seconds, tr.ClippedWh)
+		}
+	}
+
+	// A single report at the rating is a passing peak, not clipping
+	spike := NewInverterClippingCollector(config)
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC).Unix()
+	for i, watts := range []int{250, 260, 290, 260, 250} {
+		spike.ObserveInverters(time.Time{}, []client.Inverter{{SerialNumber: "INV001", LastReportDate: start + int64(i)*300, LastReportWatts: watts}})
+	}
+	if tr := spike.trackers["INV001"]; tr.Seconds != 0 {
+		t.Errorf("expected a brief peak to be ignored, got %v s", tr.Seconds)
+	}
+
+	// An inverter that drops out mid-plateau no longer counts towards the
+	// fraction, and stops clipping once its report is too old
+	dropout := NewInverterClippingCollector(config)
+	for i := range 5 {
+		at := start + int64(i)*300
+		dropout.ObserveInverters(time.Unix(at, 0), []client.Inverter{
+			{SerialNumber: "INV001", LastReportDate: at, LastReportWatts: 290},
+			{SerialNumber: "INV002", LastReportDate: at, LastReportWatts: 290},
+		})
+	}
+	at := start + 5*300
+	dropout.ObserveInverters(time.Unix(at, 0), []client.Inverter{{SerialNumber: "INV002", LastReportDate: at, LastReportWatts: 290}})
+	expectedDropout := `
+		# HELP enphase_inverters_clipping_fraction Fraction of rated inverters currently clipping
+		# TYPE enphase_inverters_clipping_fraction gauge
+		enphase_inverters_clipping_fraction 1
+	`
+	if err := testutil.CollectAndCompare(dropout, strings.NewReader(expectedDropout), "enphase_inverters_clipping_fraction"); err != nil {
+		t.Errorf("dropout clipping fraction mismatch: %v", err)
+	}
+	at += int64(maxReportGap.Seconds())
+	dropout.ObserveInverters(time.Unix(at, 0), []client.Inverter{{SerialNumber: "INV002", LastReportDate: at, LastReportWatts: 290}})
+	if dropout.trackers["INV001"].sustained {
+		t.Error("expected INV001 to stop clipping once its report is stale")
+	}
+
+	// Counters survive a restart
+	data, err := collector.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewInverterClippingCollector(config)
+	if err := restored.RestoreState(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.trackers["INV001"].ClippedWh; got != tracker.ClippedWh {
+		t.Errorf("expected %v Wh after restore, got %v", tracker.ClippedWh, got)
+	}
+
+	if err := (ClippingConfig{Margin: 0.02}).Validate(); err == nil {
+		t.Error("expected error without ratings")
+	}
+}
+
+// observeDays feeds a PanelTrendsCollector two reports ten minutes apart on
+// each of days consecutive days, with watts returning each inverter's output.
+func observeDays(c *PanelTrendsCollector, start time.Time, days int, watts func(day int, serial string) float64) {
+	serials := []string{"INV001", "INV002", "INV003"}
+	for day := 0; day <= days; day++ {
+		for _, offset := range []time.Duration{11 * time.Hour, 11*time.Hour + 10*time.Minute} {
+			t := start.AddDate(0, 0, day).Add(offset)
+			inverters := make([]client.Inverter, len(serials))
+			for i, serial := range serials {
+				inverters[i] = client.Inverter{
+					SerialNumber:    serial,
+					LastReportDate:  t.Unix(),
+					LastReportWatts: int(math.Round(watts(day, serial))),
+				}
+			}
+			c.ObserveInverters(t, inverters)
+		}
+	}
+}
+
+func TestPanelTrendsCollector(t *testing.T) {
+	config := TrendConfig{Method: TrendPeers, Window: 365 * 24 * time.Hour, MinDays: 60, ClearDayFraction: 0.7}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// INV003 loses 2% a year relative to its peers
+	collector := NewPanelTrendsCollector(config, time.UTC, nil)
+	observeDays(collector, start, 200, func(day int, serial string) float64 {
+		if serial == "INV003" {
+			return 3000 * (1 - 0.02*float64(day)/365.25)
+		}
+		return 3000
+	})
+
+	if n := testutil.CollectAndCount(collector, "enphase_inverter_trend_days"); n != 3 {
+		t.Errorf("expected history for 3 inverters, got %d", n)
+	}
+	fit, ok := fitTrend(collector.panels["INV003"].History, config.MinDays, minTrendSpan)
+	if !ok || fit.Days != 200 || math.Abs(fit.Rate-0.02) > 0.001 {
+		t.Errorf("expected a 2%%/year rate over 200 days, got %+v (ok=%v)", fit, ok)
+	}
+	if fit, _ := fitTrend(collector.panels["INV001"].History, config.MinDays, minTrendSpan); math.Abs(fit.Rate) > 0.001 {
+		t.Errorf("expected no degradation for INV001, got %v", fit.Rate)
+	}
+
+	// Too short a history isn't fitted
+	if _, ok := fitTrend(collector.panels["INV003"].History[:80], config.MinDays, minTrendSpan); ok {
+		t.Error("expected no fit for an 80-day history")
+	}
+
+	// The endpoint returns each panel's history and rate
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/panels/trends", nil))
+	var resp struct {
+		Method string `json:"method"`
+		Panels []struct {
+			SerialNumber    string   `json:"serial_number"`
+			DegradationRate *float64 `json:"degradation_rate"`
+			History         []struct {
+				Date             string  `json:"date"`
+				NormalizedEnergy float64 `json:"normalized_energy"`
+			} `json:"history"`
+		} `json:"panels"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding trends: %v", err)
+	}
+	if resp.Method != "peers" || len(resp.Panels) != 3 || resp.Panels[2].SerialNumber != "INV003" {
+		t.Fatalf("unexpected trends response %+v", resp)
+	}
+	if p := resp.Panels[2]; p.DegradationRate == nil || p.History[0].Date != "2024-01-01" || p.History[0].NormalizedEnergy != 1 {
+		t.Errorf("unexpected INV003 trends %+v", p)
+	}
+
+	// History survives a restart
+	data, err := collector.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewPanelTrendsCollector(config, time.UTC, nil)
+	if err := restored.RestoreState(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(restored.panels["INV003"].History); got != 200 {
+		t.Errorf("expected 200 days after restore, got %d", got)
+	}
+
+	// Peer normalization uses the configured peer groups, and a panel with
+	// no peers isn't tracked
+	grouped := config
+	grouped.Groups = map[string]string{"INV002": "east", "INV003": "east"}
+	peers := NewPanelTrendsCollector(grouped, time.UTC, nil)
+	observeDays(peers, start, 1, func(day int, serial string) float64 {
+		return map[string]float64{"INV001": 3000, "INV002": 1500, "INV003": 1000}[serial]
+	})
+	if p := peers.panels["INV002"]; len(p.History) != 1 || math.Abs(p.History[0].NormalizedEnergy-1.2) > 0.001 {
+		t.Errorf("expected INV002 normalized within its group, got %+v", p.History)
+	}
+	if p := peers.panels["INV001"]; len(p.History) != 0 {
+		t.Errorf("expected no history for ungrouped INV001, got %+v", p.History)
+	}
+
+	// The clear-sky model is only used when selected
+	model := func(time.Time) (float64, float64) { return 1500, 9000 }
+	if NewPanelTrendsCollector(config, time.UTC, model).method() != TrendPeers {
+		t.Error("expected peer normalization by default")
+	}
+
+	// Clear-sky normalization needs a full year of history to cancel the
+	// model's seasonal bias
+	config.Method = TrendClearSky
+	if err := config.Validate(); err == nil {
+		t.Error("expected error for a clear-sky window shorter than two years")
+	}
+	config.Window = 2 * 365 * 24 * time.Hour
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	clearSky := NewPanelTrendsCollector(config, time.UTC, model)
+	if _, ok := clearSky.fit(collector.panels["INV003"].History); ok {
+		t.Error("expected no clear-sky fit for a 200-day history")
+	}
+
+	// With a clear-sky model, cloudy days are skipped
+	observeDays(clearSky, start, 10, func(day int, serial string) float64 {
+		if day == 5 {
+			return 1000
+		}
+		return 3000
+	})
+	history := clearSky.panels["INV001"].History
+	if len(history) != 9 || history[0].NormalizedEnergy != 1 || history[0].NormalizedPeak != 1 {
+		t.Errorf("expected 9 clear days normalized to 1, got %+v", history)
+	}
+}
+
+func TestShadeCollector(t *testing.T) {
+	config := ShadeConfig{
+		BucketSize:     15 * time.Minute,
+		Days:           14,
+		MinDays:        3,
+		Threshold:      0.7,
+		Recurrence:     0.6,
+		MinMedianWatts: 20,
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	collector := NewShadeCollector(config, time.UTC, nil)
+
+	// INV003 is shaded to 30% from 09:00 to 10:00 every day, and a passing
+	// cloud shades INV002 at 14:00 on one day
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for day := 0; day < 6; day++ {
+		for minutes := 8 * 60; minutes <= 16*60; minutes += 5 {
+			t := start.AddDate(0, 0, day).Add(time.Duration(minutes) * time.Minute)
+			inverters := []client.Inverter{
+				{SerialNumber: "INV001", LastReportDate: t.Unix(), LastReportWatts: 300},
+				{SerialNumber: "INV002", LastReportDate: t.Unix(), LastReportWatts: 300},
+				{SerialNumber: "INV003", LastReportDate: t.Unix(), LastReportWatts: 300},
+			}
+			if minutes > 9*60 && minutes <= 10*60 {
+				inverters[2].LastReportWatts = 90
+			}
+			if day == 2 && minutes > 14*60 && minutes <= 14*60+30 {
+				inverters[1].LastReportWatts = 50
+			}
+			collector.ObserveInverters(t, inverters)
+			// Scrapes between reports add nothing
+			collector.ObserveInverters(t.Add(time.Minute), inverters)
+		}
+	}
+
+	expected := `
+		# HELP enphase_inverter_shade_energy_lost_wh_per_day Average energy below the peer median during the inverter's shade windows in watt-hours per day
+		# TYPE enphase_inverter_shade_energy_lost_wh_per_day gauge
+		enphase_inverter_shade_energy_lost_wh_per_day{serial_number="INV001"} 0
+		enphase_inverter_shade_energy_lost_wh_per_day{serial_number="INV002"} 0
+		enphase_inverter_shade_energy_lost_wh_per_day{serial_number="INV003"} 210
+		# HELP enphase_inverter_shaded_minutes_per_day Length of the inverter's recurring shade windows in minutes per day
+		# TYPE enphase_inverter_shaded_minutes_per_day gauge
+		enphase_inverter_shaded_minutes_per_day{serial_number="INV001"} 0
+		enphase_inverter_shaded_minutes_per_day{serial_number="INV002"} 0
+		enphase_inverter_shaded_minutes_per_day{serial_number="INV003"} 60
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Errorf("shade metrics mismatch: %v", err)
+	}
+
+	// The report lists the window and the energy lost in it
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/panels/shading", nil))
+	var resp struct {
+		Panels []struct {
+			SerialNumber string `json:"serial_number"`
+			Windows      []struct {
+				Start               string  `json:"start"`
+				End                 string  `json:"end"`
+				DaysShaded          int     `json:"days_shaded"`
+				RelativePerformance float64 `json:"relative_performance"`
+				EnergyLostWh        float64 `json:"energy_lost_wh"`
+			} `json:"windows"`
+		} `json:"panels"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding shading report: %v", err)
+	}
+	if len(resp.Panels) != 1 || resp.Panels[0].SerialNumber != "INV003" || len(resp.Panels[0].Windows) != 1 {
+		t.Fatalf("unexpected shading report %+v", resp)
+	}
+	w := resp.Panels[0].Windows[0]
+	if w.Start != "09:00" || w.End != "10:00" || w.DaysShaded != 6 || math.Abs(w.RelativePerformance-0.3) > 1e-9 || math.Abs(w.EnergyLostWh-1260) > 1e-6 {
+		t.Errorf("unexpected shade window %+v", w)
+	}
+
+	// History survives a restart
+	data, err := collector.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewShadeCollector(config, time.UTC, nil)
+	if err := restored.RestoreState(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.CollectAndCompare(restored, strings.NewReader(expected)); err != nil {
+		t.Errorf("restored shade metrics mismatch: %v", err)
+	}
+
+	config.BucketSize = 7 * time.Minute
+	if err := config.Validate(); err == nil {
+		t.Error("expected error for a bucket size that doesn't divide a day")
+	}
+}
+
+// inverterRecorder records inverter observations.
+type inverterRecorder struct {
+	inverters [][]client.Inverter
+}
+
+func (r *inverterRecorder) ObserveInverters(_ time.Time, inverters []client.Inverter) {
+	r.inverters = append(r.inverters, inverters)
+}
+
+func TestInverterPeersCollector(t *testing.T) {
+	config := PeerConfig{
+		Window:         time.Hour,
+		Threshold:      0.8,
+		MinMedianWatts: 20,
+		MinSamples:     3,
+		Groups:         map[string]string{"INV006": "west", "INV007": "west"},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	night := false
+	collector := NewInverterPeersCollector(config, func(time.Time) bool { return !night })
+
+	// INV004 produces half what its peers do; the west-facing pair produce
+	// less than the south-facing panels but match each other
+	inverters := []client.Inverter{
+		{SerialNumber: "INV001", LastReportWatts: 240},
+		{SerialNumber: "INV002", LastReportWatts: 250},
+		{SerialNumber: "INV003", LastReportWatts: 260},
+		{SerialNumber: "INV004", LastReportWatts: 125},
+		{SerialNumber: "INV006", LastReportWatts: 150},
+		{SerialNumber: "INV007", LastReportWatts: 150},
+	}
+	mock := &mockClient{inverters: (*client.InvertersResponse)(&inverters)}
+	invertersCollector := NewInvertersCollector(mock, InvertersConfig{})
+	recorder := &inverterRecorder{}
+	invertersCollector.AddObserver(recorder)
+	testutil.CollectAndCount(invertersCollector)
+	if len(recorder.inverters) != 1 || len(recorder.inverters[0]) != len(inverters) {
+		t.Fatalf("expected one observation of %d inverters, got %v", len(inverters), recorder.inverters)
+	}
+
+	// Each observation carries new reports
+	observe := func(t time.Time) {
+		for i := range inverters {
+			inverters[i].LastReportDate = t.Unix()
+		}
+		collector.ObserveInverters(t, inverters)
+	}
+	now := time.Now()
+	observe(now.Add(-2 * time.Minute))
+	observe(now.Add(-time.Minute))
+
+	// Scrapes between reports aren't sampled again
+	collector.ObserveInverters(now.Add(-30*time.Second), inverters)
+	if got := len(collector.samples["INV001"]); got != 2 {
+		t.Errorf("expected 2 samples for unchanged reports, got %d", got)
+	}
+
+	// Not enough samples yet
+	if n := testutil.CollectAndCount(collector, "enphase_inverter_relative_performance"); n != 0 {
+		t.Errorf("expected no ratings before %d samples, got %d series", config.MinSamples, n)
+	}
+
+	// Samples at night are ignored
+	night = true
+	observe(now)
+	if n := testutil.CollectAndCount(collector, "enphase_inverter_relative_performance"); n != 0 {
+		t.Errorf("expected night samples to be ignored, got %d series", n)
+	}
+
+	night = false
+	collector.ObserveInverters(now, inverters)
+	expected := `
+		# HELP enphase_inverter_peer_median_watts Median inverter output in the peer group at the last gated sample
+		# TYPE enphase_inverter_peer_median_watts gauge
+		enphase_inverter_peer_median_watts{peer_group="all"} 245
+		enphase_inverter_peer_median_watts{peer_group="west"} 150
+		# HELP enphase_inverter_underperforming Whether the inverter's relative performance is below the threshold (1 = underperforming)
+		# TYPE enphase_inverter_underperforming gauge
+		enphase_inverter_underperforming{peer_group="all",serial_number="INV001"} 0
+		enphase_inverter_underperforming{peer_group="all",serial_number="INV002"} 0
+		enphase_inverter_underperforming{peer_group="all",serial_number="INV003"} 0
+		enphase_inverter_underperforming{peer_group="all",serial_number="INV004"} 1
+		enphase_inverter_underperforming{peer_group="west",serial_number="INV006"} 0
+		enphase_inverter_underperforming{peer_group="west",serial_number="INV007"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"enphase_inverter_peer_median_watts", "enphase_inverter_underperforming"); err != nil {
+		t.Errorf("peer metrics mismatch: %v", err)
+	}
+
+	// Dim scrapes below the minimum median are skipped
+	dim := NewInverterPeersCollector(config, nil)
+	for i := int64(1); i <= 3; i++ {
+		dim.ObserveInverters(now, []client.Inverter{{SerialNumber: "INV001", LastReportDate: i, LastReportWatts: 10}, {SerialNumber: "INV002", LastReportDate: i, LastReportWatts: 0}})
+	}
+	if n := testutil.CollectAndCount(dim); n != 0 {
+		t.Errorf("expected dim scrapes to be skipped, got %d series", n)
+	}
+}
+
+func TestMetersCollector(t *testing.T) {
+	mock := &mockClient{
+		meterReadings: &client.MeterReadingsResponse{
+			{
+				Eid:         12345,
+				Voltage:     240.5,
+				Current:     10.3,
+				ActivePower: 2450.0,
+				Freq:        60.0,
+				PwrFactor:   0.99,
+				Channels: []client.MeterChannel{
+					{Voltage: 120.2, Current: 5.1, ActivePower: 612.0, PwrFactor: 0.98},
+					{Voltage: 120.3, Current: 5.2, ActivePower: 625.0, PwrFactor: 0.99},
+				},
+			},
+		},
+		meters: &client.MetersResponse{
+			{
+				Eid:             12345,
+				State:           "enabled",
+				MeasurementType: "production",
+				PhaseMode:       "split",
+				PhaseCount:      2,
+				MeteringStatus:  "normal",
+			},
+		},
+	}
+
+	collector := NewMetersCollector(mock)
+
+	// Test frequency (only total, not per-phase)
+	expected := `
+		# HELP enphase_frequency_hz Grid frequency in Hz
+		# TYPE enphase_frequency_hz gauge
+		enphase_frequency_hz{measurement_type="production",meter_id="12345"} 60
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "enphase_frequency_hz"); err != nil {
+		t.Errorf("frequency mismatch: %v", err)
+	}
+
+	// Test voltage (total and per-phase)
+	expectedVoltage := `
+		# HELP enphase_voltage_volts Grid voltage in volts
+		# TYPE enphase_voltage_volts gauge
+		enphase_voltage_volts{measurement_type="production",meter_id="12345",phase="total"} 240.5
+		enphase_voltage_volts{measurement_type="production",meter_id="12345",phase="L1"} 120.2
+		enphase_voltage_volts{measurement_type="production",meter_id="12345",phase="L2"} 120.3
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedVoltage), "enphase_voltage_volts"); err != nil {
+		t.Errorf("voltage mismatch: %v", err)
+	}
+}
+
+func TestLiveDataCollector(t *testing.T) {
+	mock := &mockClient{
+		liveData: &client.LiveDataResponse{
+			Connection: client.LiveDataConnection{ScStream: "enabled"},
+			Meters: client.LiveDataMeters{
+				LastUpdate:     1706400000,
+				MainRelayState: 1,
+				PV:             client.LiveDataSource{AggPMw: 2500000},
+				Grid:           client.LiveDataSource{AggPMw: -1000000},
+				Load:           client.LiveDataSource{AggPMw: 1500000},
+			},
+		},
+	}
+
+	collector := NewLiveDataCollector(mock)
+
+	expected := `
+		# HELP enphase_livedata_power_watts Active power by source from live data in watts
+		# TYPE enphase_livedata_power_watts gauge
+		enphase_livedata_power_watts{source="generator"} 0
+		enphase_livedata_power_watts{source="grid"} -1000
+		enphase_livedata_power_watts{source="load"} 1500
+		enphase_livedata_power_watts{source="pv"} 2500
+		enphase_livedata_power_watts{source="storage"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "enphase_livedata_power_watts"); err != nil {
+		t.Errorf("live data power mismatch: %v", err)
+	}
+
+	expectedMode := `
+		# HELP enphase_livedata_grid_mode Current grid mode from the main relay state (1 for the active mode)
+		# TYPE enphase_livedata_grid_mode gauge
+		enphase_livedata_grid_mode{mode="off-grid"} 0
+		enphase_livedata_grid_mode{mode="on-grid"} 1
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedMode), "enphase_livedata_grid_mode"); err != nil {
+		t.Errorf("live data grid mode mismatch: %v", err)
+	}
+}
+
+func TestEnsembleCollector(t *testing.T) {
+	mock := &mockClient{
+		ensembleRelay: &client.EnsembleRelayResponse{
+			MainsAdminState: "closed",
+			MainsOperState:  "closed",
+			EnchgGridMode:   "multimode-ongrid",
+			SolarGridMode:   "multimode-ongrid",
+		},
+		ensembleSecCtrl: &client.EnsembleSecCtrlResponse{},
+	}
+
+	collector := NewEnsembleCollector(mock)
+
+	expectedMode := `
+		# HELP enphase_ensemble_grid_mode Ensemble grid mode (1 for the active mode)
+		# TYPE enphase_ensemble_grid_mode gauge
+		enphase_ensemble_grid_mode{component="encharge",mode="grid-following"} 1
+		enphase_ensemble_grid_mode{component="encharge",mode="grid-forming"} 0
+		enphase_ensemble_grid_mode{component="solar",mode="grid-following"} 1
+		enphase_ensemble_grid_mode{component="solar",mode="grid-forming"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedMode), "enphase_ensemble_grid_mode"); err != nil {
+		t.Errorf("ensemble grid mode mismatch: %v", err)
+	}
+
+	// Grid outage: relay opens and batteries start forming the grid
+	mock.ensembleRelay = &client.EnsembleRelayResponse{
+		MainsAdminState: "closed",
+		MainsOperState:  "open",
+		EnchgGridMode:   "multimode-offgrid",
+		SolarGridMode:   "multimode-offgrid",
+	}
+
+	expectedRelay := `
+		# HELP enphase_ensemble_main_relay_closed Main relay state (1 = closed/on-grid, 0 = open/islanded)
+		# TYPE enphase_ensemble_main_relay_closed gauge
+		enphase_ensemble_main_relay_closed{state="administrative"} 1
+		enphase_ensemble_main_relay_closed{state="operational"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedRelay), "enphase_ensemble_main_relay_closed"); err != nil {
+		t.Errorf("ensemble relay mismatch: %v", err)
+	}
+
+	// Repeated scrapes while islanded must not count additional events
+	expectedEvents := `
+		# HELP enphase_ensemble_islanding_events_total Number of observed transitions from on-grid to islanded
+		# TYPE enphase_ensemble_islanding_events_total counter
+		enphase_ensemble_islanding_events_total 1
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedEvents), "enphase_ensemble_islanding_events_total"); err != nil {
+		t.Errorf("islanding events mismatch: %v", err)
+	}
+
+	if n := testutil.CollectAndCount(collector, "enphase_ensemble_main_relay_last_transition_timestamp"); n != 1 {
+		t.Errorf("expected last transition timestamp after relay change, got %d series", n)
+	}
+}
+
+func TestLoadControlCollector(t *testing.T) {
+	mock := &mockClient{
+		dryContacts: &client.DryContactStatusResponse{
+			DryContacts: []client.DryContactStatus{
+				{ID: "NC1", Status: "closed"},
+				{ID: "NO1", Status: "open"},
+				{ID: "NC2", Status: "closed"},
+			},
+		},
+		dryContactConfig: &client.DryContactSettingsResponse{
+			DryContacts: []client.DryContactSettings{
+				{ID: "NC1", LoadName: "Pool Pump", Mode: "soc", SocLow: 30, SocHigh: 70},
+				{ID: "NO1", LoadName: "EV", Mode: "manual", GridAction: "apply"},
+			},
+		},
+		generator: &client.GeneratorResponse{Present: 1, OperState: "on"},
+		meters: &client.MetersResponse{
+			{Eid: 555, State: "enabled", MeasurementType: "generator"},
+		},
+		meterReadings: &client.MeterReadingsResponse{
+			// Lifetime energy is the sum of the per-phase channels
+			{Eid: 555, ActivePower: 5000, ActEnergyDlvd: 1000, Channels: []client.MeterChannel{
+				{ActEnergyDlvd: 60000},
+				{ActEnergyDlvd: 60000},
+			}},
+		},
+	}
+
+	collector := NewLoadControlCollector(mock)
+
+	expectedRelay := `
+		# HELP enphase_load_control_relay_closed Load control relay state (1 = closed, 0 = open)
+		# TYPE enphase_load_control_relay_closed gauge
+		enphase_load_control_relay_closed{load_name="",relay_id="NC2"} 1
+		enphase_load_control_relay_closed{load_name="EV",relay_id="NO1"} 0
+		enphase_load_control_relay_closed{load_name="Pool Pump",relay_id="NC1"} 1
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedRelay), "enphase_load_control_relay_closed"); err != nil {
+		t.Errorf("relay state mismatch: %v", err)
+	}
+
+	expectedMode := `
+		# HELP enphase_load_control_relay_mode Load control relay mode (1 for the configured mode)
+		# TYPE enphase_load_control_relay_mode gauge
+		enphase_load_control_relay_mode{load_name="",mode="grid",relay_id="NC2"} 0
+		enphase_load_control_relay_mode{load_name="",mode="schedule",relay_id="NC2"} 0
+		enphase_load_control_relay_mode{load_name="",mode="soc",relay_id="NC2"} 0
+		enphase_load_control_relay_mode{load_name="",mode="unknown",relay_id="NC2"} 1
+		enphase_load_control_relay_mode{load_name="EV",mode="grid",relay_id="NO1"} 1
+		enphase_load_control_relay_mode{load_name="EV",mode="schedule",relay_id="NO1"} 0
+		enphase_load_control_relay_mode{load_name="EV",mode="soc",relay_id="NO1"} 0
+		enphase_load_control_relay_mode{load_name="EV",mode="unknown",relay_id="NO1"} 0
+		enphase_load_control_relay_mode{load_name="Pool Pump",mode="grid",relay_id="NC1"} 0
+		enphase_load_control_relay_mode{load_name="Pool Pump",mode="schedule",relay_id="NC1"} 0
+		enphase_load_control_relay_mode{load_name="Pool Pump",mode="soc",relay_id="NC1"} 1
+		enphase_load_control_relay_mode{load_name="Pool Pump",mode="unknown",relay_id="NC1"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedMode), "enphase_load_control_relay_mode"); err != nil {
+		t.Errorf("relay mode mismatch: %v", err)
+	}
+
+	expectedGen := `
+		# HELP enphase_generator_running Generator running state (1 = running)
+		# TYPE enphase_generator_running gauge
+		enphase_generator_running 1
+		# HELP enphase_generator_watts Generator output power in watts (requires a generator meter)
+		# TYPE enphase_generator_watts gauge
+		enphase_generator_watts 5000
+		# HELP enphase_generator_wh_total Lifetime generator energy in watt-hours (requires a generator meter)
+		# TYPE enphase_generator_wh_total counter
+		enphase_generator_wh_total 120000
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedGen), "enphase_generator_running", "enphase_generator_watts", "enphase_generator_wh_total"); err != nil {
+		t.Errorf("generator mismatch: %v", err)
+	}
+
+	// A failed settings fetch backs off instead of retrying every scrape
+	failing := NewLoadControlCollector(&mockClient{err: &client.StatusError{StatusCode: 503}})
+	failing.refreshSettings()
+	if failing.lastRefresh.IsZero() || !failing.settingsWarned {
+		t.Error("expected a failed settings fetch to back off and warn")
+	}
+	failing.client = mock
+	failing.refreshSettings()
+	if failing.settingsWarned || len(failing.settings) != 2 {
+		t.Errorf("expected settings after recovery, got %+v", failing.settings)
+	}
+
+	// The generator meter is kept while meter metadata is unavailable
+	mock.metersErr = &client.StatusError{StatusCode: 503}
+	collector.refreshSettings()
+	if collector.generatorMeter != 555 {
+		t.Errorf("expected generator meter 555 to be kept, got %d", collector.generatorMeter)
+	}
+}
+
+func TestEVChargersCollector(t *testing.T) {
+	// Recorded gateway response shared with the client tests
+	data, err := os.ReadFile("../client/testdata/evse_status.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	var chargers client.EVChargersResponse
+	if err := json.Unmarshal(data, &chargers); err != nil {
+		t.Fatalf("Failed to decode fixture: %v", err)
+	}
+
+	collector := NewEVChargersCollector(&mockClient{evChargers: &chargers})
+
+	expectedState := `
+		# HELP enphase_ev_charger_state EV charger state (1 for the current state)
+		# TYPE enphase_ev_charger_state gauge
+		enphase_ev_charger_state{serial_number="202314000123",state="available"} 0
+		enphase_ev_charger_state{serial_number="202314000123",state="charging"} 1
+		enphase_ev_charger_state{serial_number="202314000123",state="faulted"} 0
+		enphase_ev_charger_state{serial_number="202314000123",state="unknown"} 0
+		enphase_ev_charger_state{serial_number="202314000456",state="available"} 0
+		enphase_ev_charger_state{serial_number="202314000456",state="charging"} 0
+		enphase_ev_charger_state{serial_number="202314000456",state="faulted"} 1
+		enphase_ev_charger_state{serial_number="202314000456",state="unknown"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedState), "enphase_ev_charger_state"); err != nil {
+		t.Errorf("EV charger state mismatch: %v", err)
+	}
+
+	// Only known idle states are available; anything else may be a fault
+	for state, want := range map[string]string{"suspended": "available", "": "unknown", "ground_fault": "unknown"} {
+		if got := evChargerState(state); got != want {
+			t.Errorf("evChargerState(%q) = %q, want %q", state, got, want)
+		}
+	}
+
+	expectedEnergy := `
+		# HELP enphase_ev_charger_power_watts Current EV charging power in watts
+		# TYPE enphase_ev_charger_power_watts gauge
+		enphase_ev_charger_power_watts{serial_number="202314000123"} 7152.4
+		enphase_ev_charger_power_watts{serial_number="202314000456"} 0
+		# HELP enphase_ev_charger_session_wh Energy delivered in the current charging session in watt-hours
+		# TYPE enphase_ev_charger_session_wh gauge
+		enphase_ev_charger_session_wh{serial_number="202314000123"} 12480
+		enphase_ev_charger_session_wh{serial_number="202314000456"} 0
+		# HELP enphase_ev_charger_wh_total Total lifetime energy delivered by the charger in watt-hours
+		# TYPE enphase_ev_charger_wh_total counter
+		enphase_ev_charger_wh_total{serial_number="202314000123"} 1.5234e+06
+		enphase_ev_charger_wh_total{serial_number="202314000456"} 804210
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expectedEnergy),
+		"enphase_ev_charger_power_watts", "enphase_ev_charger_session_wh", "enphase_ev_charger_wh_total"); err != nil {
+		t.Errorf("EV charger energy mismatch: %v", err)
+	}
+}
+
+func TestCollector_StateRoundTrip(t *testing.T) {
+	production := NewProductionCollector(&mockClient{})
+	production.gridExportAccum = 1234.5
+	production.gridImportAccum = 678.9
+	production.energy.Production.delta(5000, "eim")
+
+	ensemble := NewEnsembleCollector(&mockClient{})
+	ensemble.islandingEvents = 3
+	ensemble.lastOperState = relayOpen
+
+	prodData, err := production.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensembleData, err := ensemble.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("short restart", func(t *testing.T) {
+		restoredProd := NewProductionCollector(&mockClient{})
+		if err := restoredProd.RestoreState(prodData, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if restoredProd.energy.Production.Wh != 5000 {
+			t.Errorf("expected lifetime counter baselines to be restored, got %+v", restoredProd.energy.Production)
+		}
+
+		restored := NewEnsembleCollector(&mockClient{})
+		if err := restored.RestoreState(ensembleData, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if restored.islandingEvents != 3 || restored.lastOperState != relayOpen {
+			t.Errorf("unexpected ensemble state: events=%v relay=%q", restored.islandingEvents, restored.lastOperState)
+		}
+	})
+
+	t.Run("long downtime", func(t *testing.T) {
+		restoredProd := NewProductionCollector(&mockClient{})
+		started := restoredProd.lastScrapeTime
+		if err := restoredProd.RestoreState(prodData, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if restoredProd.gridExportAccum != 1234.5 || restoredProd.gridImportAccum != 678.9 {
+			t.Errorf("unexpected accumulators: export=%v import=%v", restoredProd.gridExportAccum, restoredProd.gridImportAccum)
+		}
+		// The next scrape must not integrate across the downtime or credit
+		// energy from it to the current period
+		if !restoredProd.lastScrapeTime.Equal(started) {
+			t.Errorf("expected last scrape time to stay at startup, got %v", restoredProd.lastScrapeTime)
+		}
+		if restoredProd.energy.Production.Valid {
+			t.Error("expected lifetime counter baselines to start fresh")
+		}
+
+		restoredEnsemble := NewEnsembleCollector(&mockClient{})
+		if err := restoredEnsemble.RestoreState(ensembleData, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if restoredEnsemble.islandingEvents != 3 {
+			t.Errorf("expected islanding events to be restored, got %v", restoredEnsemble.islandingEvents)
+		}
+		if restoredEnsemble.lastOperState != "" {
+			t.Errorf("expected relay state to start fresh, got %q", restoredEnsemble.lastOperState)
+		}
+	})
+}
+
+// sampleRecorder records energy samples for testing
+type sampleRecorder struct {
+	samples []EnergySample
+}
+
+func (r *sampleRecorder) ObserveEnergy(s EnergySample) {
+	r.samples = append(r.samples, s)
+}
+
+func TestProductionCollector_EnergySamples(t *testing.T) {
+	mock := &mockClient{
+		productionReport: &client.ProductionReportResponse{
+			ReportType: "production",
+			Cumulative: client.MeterReportData{CurrW: 3000, WhDlvdCum: 10000},
+		},
+		consumptionReport: &client.ConsumptionReportResponse{
+			{ReportType: "total-consumption", Cumulative: client.MeterReportData{CurrW: 1000, WhDlvdCum: 5000}},
+			{ReportType: "net-consumption", Cumulative: client.MeterReportData{CurrW: -2000, WhDlvdCum: 800, WhRcvdCum: 4000}},
+		},
+	}
+	recorder := &sampleRecorder{}
+	collector := NewProductionCollector(mock)
+	collector.AddObserver(recorder)
+
+	collect := func() {
+		ch := make(chan prometheus.Metric, 100)
+		collector.Collect(ch)
+		close(ch)
+	}
+
+	collect()
+	mock.productionReport.Cumulative.WhDlvdCum = 10250
+	(*mock.consumptionReport)[0].Cumulative.WhDlvdCum = 5100
+	(*mock.consumptionReport)[1].Cumulative.WhRcvdCum = 4150
+	collect()
+
+	if len(recorder.samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(recorder.samples))
+	}
+	// The first sample only establishes the baseline
+	if first := recorder.samples[0]; first.ProductionWh != 0 || first.ExportWh != 0 {
+		t.Errorf("expected zero deltas on first sample, got %+v", first)
+	}
+	got := recorder.samples[1]
+	if got.ProductionWh != 250 || got.ConsumptionWh != 100 || got.ExportWh != 150 || got.ImportWh != 0 {
+		t.Errorf("unexpected deltas: %+v", got)
+	}
+	if !got.HasConsumption || got.ProductionW != 3000 || got.ConsumptionW != 1000 {
+		t.Errorf("unexpected power values: %+v", got)
+	}
+	if got.HasStorage {
+		t.Errorf("expected no storage without an acb section or live data, got %+v", got)
+	}
+
+	// Report-mode gateways only expose IQ Battery power through live data
+	mock.liveData = &client.LiveDataResponse{Meters: client.LiveDataMeters{
+		EncAggEnergy: 5000,
+		Storage:      client.LiveDataSource{AggPMw: 1200000},
+	}}
+	collector.UseLiveDataStorage()
+	collect()
+	if got := recorder.samples[2]; !got.HasStorage || got.StorageW != 1200 {
+		t.Errorf("expected 1200 W discharging from live data, got %+v", got)
+	}
+}
+
+func TestEnergyPeriodsCollector(t *testing.T) {
+	loc, err := time.LoadLocation("America/Denver")
+	if err != nil {
+		t.Fatalf("loading America/Denver: %v", err)
+	}
+	collector := NewEnergyPeriodsCollector(loc)
+
+	// Sunday 2024-03-10 is the spring-forward DST transition in Denver
+	collector.ObserveEnergy(EnergySample{Time: time.Date(2024, 3, 10, 23, 30, 0, 0, loc), ProductionWh: 100, ImportWh: 40, HasConsumption: true})
+	// Monday starts a new day and week, but not a new month
+	monday := time.Date(2024, 3, 11, 0, 15, 0, 0, loc)
+	collector.ObserveEnergy(EnergySample{Time: monday, ProductionWh: 10, ImportWh: 5, HasConsumption: true})
+
+	collector.mu.Lock()
+	day := collector.totals[periodDay][flowProduction]
+	week := collector.totals[periodWeek][flowProduction]
+	month := collector.totals[periodMonth][flowProduction]
+	monthImport := collector.totals[periodMonth][flowImport]
+	dayStart := collector.starts[periodDay]
+	collector.mu.Unlock()
+
+	if day != 10 || week != 10 || month != 110 || monthImport != 45 {
+		t.Errorf("unexpected totals: day=%v week=%v month=%v month import=%v", day, week, month, monthImport)
+	}
+	if want := time.Date(2024, 3, 11, 0, 0, 0, 0, loc); !dayStart.Equal(want) {
+		t.Errorf("expected day start %v, got %v", want, dayStart)
+	}
+
+	// The day after spring-forward is only 23 hours long
+	if got := periodStart(periodDay, time.Date(2024, 3, 10, 12, 0, 0, 0, loc), loc); monday.Sub(got) != 23*time.Hour+15*time.Minute {
+		t.Errorf("expected 23h DST day, day started at %v", got)
+	}
+
+	// Totals survive a restart within the same period
+	data, err := collector.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewEnergyPeriodsCollector(loc)
+	if err := restored.RestoreState(data, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	restored.ObserveEnergy(EnergySample{Time: monday.Add(time.Hour), ProductionWh: 5, HasConsumption: true})
+	if got := restored.totals[periodDay][flowProduction]; got != 15 {
+		t.Errorf("expected restored day total 15, got %v", got)
+	}
+}
+
+func TestEnergyPeriodsCollector_ProductionOnly(t *testing.T) {
+	collector := NewEnergyPeriodsCollector(time.UTC)
+	collector.ObserveEnergy(EnergySample{Time: time.Now(), ProductionWh: 500})
+
+	// 3 periods, production flow only
+	if n := testutil.CollectAndCount(collector, "enphase_energy_wh"); n != 3 {
+		t.Errorf("expected 3 production-only series, got %d", n)
+	}
+}
+
+func TestTariffCollector(t *testing.T) {
+	tou, err := tariff.New(tariff.Config{
+		FixedDailyCharge: 0.5,
+		Seasons: []tariff.Season{{
+			ImportRate: 0.20,
+			ExportRate: 0.05,
+			Periods: []tariff.Period{
+				{Name: "peak", Start: "16:00", End: "21:00", ImportRate: 0.50, ExportRate: 0.10},
+			},
+		}},
+	}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := NewTariffCollector(tou)
+
+	// Midday: 3 kWh produced, 1 kWh exported, so 2 kWh self-consumed
+	collector.ObserveEnergy(EnergySample{
+		Time: time.Date(2024, 7, 10, 12, 0, 0, 0, time.UTC), HasConsumption: true,
+		ProductionWh: 3000, ExportWh: 1000,
+	})
+	// Evening peak on the next day: 2 kWh imported
+	collector.ObserveEnergy(EnergySample{
+		Time: time.Date(2024, 7, 11, 18, 0, 0, 0, time.UTC), HasConsumption: true,
+		ImportWh: 2000,
+	})
+	// Production-only samples are ignored
+	collector.ObserveEnergy(EnergySample{Time: time.Date(2024, 7, 11, 19, 0, 0, 0, time.UTC), ProductionWh: 5000})
+
+	expected := `
+		# HELP enphase_cost_fixed_total Fixed daily charges accrued while the exporter was running
+		# TYPE enphase_cost_fixed_total counter
+		enphase_cost_fixed_total 1
+		# HELP enphase_cost_import_total Cost of energy imported from the grid
+		# TYPE enphase_cost_import_total counter
+		enphase_cost_import_total{tariff_period="off-peak"} 0
+		enphase_cost_import_total{tariff_period="peak"} 1
+		# HELP enphase_credit_export_total Credit earned for energy exported to the grid
+		# TYPE enphase_credit_export_total counter
+		enphase_credit_export_total{tariff_period="off-peak"} 0.05
+		enphase_credit_export_total{tariff_period="peak"} 0
+		# HELP enphase_savings_total Import cost avoided by self-consumed solar plus export credit
+		# TYPE enphase_savings_total counter
+		enphase_savings_total{tariff_period="off-peak"} 0.45
+		enphase_savings_total{tariff_period="peak"} 0
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"enphase_cost_fixed_total", "enphase_cost_import_total", "enphase_credit_export_total", "enphase_savings_total"); err != nil {
+		t.Errorf("tariff metrics mismatch: %v", err)
+	}
+}
+
+func TestEnergyFlowCollector(t *testing.T) {
+	collector := NewEnergyFlowCollector()
+	now := time.Now()
+
+	// Midday: 3 kWh produced, 1 kWh exported. Evening: 2 kWh imported.
+	collector.ObserveEnergy(EnergySample{Time: now.Add(-2 * time.Hour), HasConsumption: true, ProductionWh: 3000, ExportWh: 1000})
+	collector.ObserveEnergy(EnergySample{Time: now.Add(-30 * time.Minute), HasConsumption: true, ImportWh: 2000})
+	// Production-only samples are ignored
+	collector.ObserveEnergy(EnergySample{Time: now, ProductionWh: 5000})
+
+	expected := `
+		# HELP enphase_energy_flow_wh_total Energy flowing from one part of the site to another in watt-hours
+		# TYPE enphase_energy_flow_wh_total counter
+		enphase_energy_flow_wh_total{from="grid",to="home"} 2000
+		enphase_energy_flow_wh_total{from="solar",to="grid"} 1000
+		enphase_energy_flow_wh_total{from="solar",to="home"} 2000
+		# HELP enphase_self_consumption_ratio Share of solar production used on site rather than exported, over a rolling window
+		# TYPE enphase_self_consumption_ratio gauge
+		enphase_self_consumption_ratio{window="24h"} 0.6666666666666666
+		enphase_self_consumption_ratio{window="7d"} 0.6666666666666666
+		# HELP enphase_self_sufficiency_ratio Share of home consumption supplied by solar or battery rather than the grid, over a rolling window
+		# TYPE enphase_self_sufficiency_ratio gauge
+		enphase_self_sufficiency_ratio{window="1h"} 0
+		enphase_self_sufficiency_ratio{window="24h"} 0.5
+		enphase_self_sufficiency_ratio{window="7d"} 0.5
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Errorf("energy flow metrics mismatch: %v", err)
+	}
+
+	// Battery flows: solar and grid charging, then discharging to the home
+	// and the grid
+	tests := []struct {
+		name                                          string
+		productionWh, importWh, exportWh, charge, dis float64
+		want                                          flowTotals
+	}{
+		{"charging", 1000, 500, 0, 1500, 0, flowTotals{SolarBattery: 1000, GridBattery: 500}},
+		{"discharging", 0, 0, 200, 0, 1000, flowTotals{BatteryGrid: 200, BatteryHome: 800}},
+		{"surplus", 2000, 0, 500, 1000, 0, flowTotals{SolarGrid: 500, SolarBattery: 1000, SolarHome: 500}},
+		// Solar covers the 2500 Wh home load, so the battery is what's exported
+		{"solar and battery exporting", 3000, 0, 1500, 0, 1000, flowTotals{SolarHome: 2500, SolarGrid: 500, BatteryGrid: 1000}},
+	}
+	for _, tt := range tests {
+		if got := decomposeFlows(tt.productionWh, tt.importWh, tt.exportWh, tt.charge, tt.dis); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// Battery flows are only exported once storage has been seen; charging
+	// at 600 W for a minute stores 10 Wh
+	storage := NewEnergyFlowCollector()
+	storage.ObserveEnergy(EnergySample{Time: now.Add(-time.Minute), HasConsumption: true, HasStorage: true})
+	storage.ObserveEnergy(EnergySample{Time: now, HasConsumption: true, HasStorage: true, StorageW: -600, ProductionWh: 50})
+	if n := testutil.CollectAndCount(storage, "enphase_energy_flow_wh_total"); n != 7 {
+		t.Errorf("expected 7 flow series with storage, got %d", n)
+	}
+	if got := storage.totals.SolarBattery; math.Abs(got-10) > 1e-9 {
+		t.Errorf("expected 10 Wh solar to battery, got %v", got)
+	}
+}
+
+func TestClearSkyCollector(t *testing.T) {
+	site := solar.Site{Latitude: 0, Longitude: 0}
+	array := solar.Array{Tilt: 0, Azimuth: 180, DCCapacityWatts: 10000, Losses: 0.14}
+	collector := NewClearSkyCollector(site, array)
+
+	// Half the expected output at noon on the equinox
+	noon := time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	expectedW := array.ExpectedWatts(solar.SunPosition(noon, site), site)
+	if expectedW < 7000 {
+		t.Fatalf("expected about 9 kW at noon, got %v", expectedW)
+	}
+	collector.ObserveEnergy(EnergySample{Time: noon, ProductionW: expectedW / 2})
+	expected := `
+		# HELP enphase_performance_ratio Production as a fraction of expected clear-sky production
+		# TYPE enphase_performance_ratio gauge
+		enphase_performance_ratio 0.5
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "enphase_performance_ratio"); err != nil {
+		t.Errorf("performance ratio mismatch: %v", err)
+	}
+
+	// No ratio at night
+	collector.ObserveEnergy(EnergySample{Time: noon.Add(12 * time.Hour)})
+	if n := testutil.CollectAndCount(collector, "enphase_performance_ratio"); n != 0 {
+		t.Errorf("expected no performance ratio at night, got %d series", n)
+	}
+	if n := testutil.CollectAndCount(collector, "enphase_expected_clear_sky_watts"); n != 1 {
+		t.Errorf("expected clear-sky watts to always be exported, got %d series", n)
+	}
+}
+
+func TestSunCollector(t *testing.T) {
+	collector := NewSunCollector(solar.Site{Latitude: 39.74, Longitude: -104.99}, time.UTC)
+
+	if n := testutil.CollectAndCount(collector); n != 6 {
+		t.Errorf("expected 6 sun series at a mid-latitude site, got %d", n)
+	}
+	if !collector.IsDaylight(time.Date(2024, 6, 20, 18, 0, 0, 0, time.UTC)) {
+		t.Error("expected daylight at local noon")
+	}
+	if collector.IsDaylight(time.Date(2024, 6, 20, 8, 0, 0, 0, time.UTC)) {
+		t.Error("expected darkness at 02:00 local")
+	}
+}
+
+func TestEmissionsCollector(t *testing.T) {
+	factors, err := emissions.New(emissions.Config{Factor: 0.4}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := NewEmissionsCollector(factors)
+
+	// Production-only samples still displace grid emissions
+	collector.ObserveEnergy(EnergySample{Time: time.Now(), ProductionWh: 5000})
+	if n := testutil.CollectAndCount(collector, "enphase_co2_emitted_kg_total"); n != 0 {
+		t.Errorf("expected no emitted series without consumption CTs, got %d", n)
+	}
+
+	collector.ObserveEnergy(EnergySample{Time: time.Now(), HasConsumption: true, ProductionWh: 1000, ExportWh: 500, ImportWh: 2500})
+	expected := `
+		# HELP enphase_co2_avoided_kg_total Grid CO2 emissions displaced by solar production (self-consumed and exported) in kg
+		# TYPE enphase_co2_avoided_kg_total counter
+		enphase_co2_avoided_kg_total 2.4
+		# HELP enphase_co2_emitted_kg_total CO2 emissions of energy imported from the grid in kg
+		# TYPE enphase_co2_emitted_kg_total counter
+		enphase_co2_emitted_kg_total 1
+		# HELP enphase_grid_emission_factor_kg_per_kwh Current grid emission factor in kg CO2 per kWh
+		# TYPE enphase_grid_emission_factor_kg_per_kwh gauge
+		enphase_grid_emission_factor_kg_per_kwh 0.4
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Errorf("emissions metrics mismatch: %v", err)
+	}
+}
+
+func TestBillingCycleCollector(t *testing.T) {
+	collector := NewBillingCycleCollector(BillingConfig{CycleStartDay: 17, TrueUpMonth: 3}, time.UTC)
+
+	tests := []struct {
+		period     string
+		at         time.Time
+		start, end time.Time
+	}{
+		{billingPeriodCycle, time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC), time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 17, 0, 0, 0, 0, time.UTC)},
+		{billingPeriodCycle, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), time.Date(2023, 12, 17, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{billingPeriodTrueUp, time.Date(2024, 3, 16, 23, 0, 0, 0, time.UTC), time.Date(2023, 3, 17, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{billingPeriodTrueUp, time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		start, end := collector.bounds(tt.period, tt.at)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s at %v: got %v - %v, want %v - %v", tt.period, tt.at, start, end, tt.start, tt.end)
+		}
+	}
+
+	// Cycle days past the end of the month clamp to the last day
+	clamped := NewBillingCycleCollector(BillingConfig{CycleStartDay: 31}, time.UTC)
+	if start, _ := clamped.bounds(billingPeriodCycle, time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)); !start.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected cycle to start on Feb 29, got %v", start)
+	}
+
+	// Samples accumulate into the current cycle
+	now := time.Now()
+	collector.ObserveEnergy(EnergySample{Time: now, HasConsumption: true, ImportWh: 5000, ExportWh: 2000})
+	collector.ObserveEnergy(EnergySample{Time: now, HasConsumption: true, ImportWh: 1000, ExportWh: 3000})
+	expected := `
+		# HELP enphase_billing_energy_wh Grid energy in the current billing period in watt-hours (net = import - export)
+		# TYPE enphase_billing_energy_wh gauge
+		enphase_billing_energy_wh{flow="export",period="cycle"} 5000
+		enphase_billing_energy_wh{flow="export",period="true_up"} 5000
+		enphase_billing_energy_wh{flow="import",period="cycle"} 6000
+		enphase_billing_energy_wh{flow="import",period="true_up"} 6000
+		enphase_billing_energy_wh{flow="net",period="cycle"} 1000
+		enphase_billing_energy_wh{flow="net",period="true_up"} 1000
+	`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "enphase_billing_energy_wh"); err != nil {
+		t.Errorf("billing energy mismatch: %v", err)
+	}
+
+	// No projection until enough of the period has been observed
+	if n := testutil.CollectAndCount(collector, "enphase_billing_projected_net_wh"); n != 0 {
+		t.Errorf("expected no projection yet, got %d series", n)
+	}
+
+	// 10 days observed with 5 days left projects 1.5x the net so far
+	start := time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)
+	projected, ok := projectNet(-2000, start, start.AddDate(0, 0, 10), start.AddDate(0, 0, 15))
+	if !ok || projected != -3000 {
+		t.Errorf("expected projection -3000, got %v (ok=%v)", projected, ok)
+	}
+
+	// Downtime across a restart is left out of the observed duration
+	collector.periods[billingPeriodCycle].ObservedSince = now.Add(-48 * time.Hour)
+	data, err := collector.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewBillingCycleCollector(BillingConfig{CycleStartDay: 17, TrueUpMonth: 3}, time.UTC)
+	if err := restored.RestoreState(data, now.Add(-24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if p := restored.periods[billingPeriodCycle]; p == nil || p.Downtime < 24*time.Hour || p.Downtime > 25*time.Hour {
+		t.Fatalf("expected a day of downtime, got %+v", p)
+	}
+}
+
+func TestDemandCollector(t *testing.T) {
+	if err := (DemandConfig{Windows: []time.Duration{7 * time.Minute}, CycleStartDay: 1}).Validate(); err == nil {
+		t.Error("expected 7m window to be rejected")
+	}
+
+	collector := NewDemandCollector(DemandConfig{Windows: []time.Duration{15 * time.Minute}, CycleStartDay: 1}, time.UTC)
+
+	// One sample per minute from 10:09: 5000 W until 10:15, 4000 W until
+	// 10:30, then exporting. The 10:00 interval is only 6 minutes observed
+	// and must not count; exports count as zero demand.
+	for m := 9; m <= 46; m++ {
+		watts := -3000.0
+		switch {
+		case m < 15:
+			watts = 5000
+		case m < 30:
+			watts = 4000
+		}
+		collector.ObserveNetPower(time.Date(2024, 5, 20, 10, m, 0, 0, time.UTC), watts)
+	}
+
+	w := collector.windows[0]
+	if w.label != "15m" {
+		t.Errorf("expected window label 15m, got %q", w.label)
+	}
+	for _, period := range demandPeriods {
+		peak := w.peaks[period]
+		if peak == nil {
+			t.Fatalf("expected a %s peak", period)
+		}
+		if peak.Watts != 4000 || !peak.At.Equal(time.Date(2024, 5, 20, 10, 15, 0, 0, time.UTC)) {
+			t.Errorf("%s peak: got %v W at %v, want 4000 W at 10:15", period, peak.Watts, peak.At)
+		}
+	}
+	if !w.start.Equal(time.Date(2024, 5, 20, 10, 45, 0, 0, time.UTC)) || w.energyWs != 0 {
+		t.Errorf("expected current interval at 10:45 with no import, got %v with %v Ws", w.start, w.energyWs)
+	}
+
+	// Peaks from an earlier day aren't exported
+	if n := testutil.CollectAndCount(collector, "enphase_demand_peak_watts"); n != 0 {
+		t.Errorf("expected no current-period peaks, got %d series", n)
 	}
 }
 
@@ -248,18 +2124,34 @@ func TestCollector_NilData(t *testing.T) {
 	mock := &mockClient{
 		productionReport:  nil,
 		consumptionReport: nil,
+		productionJSON:    nil,
 		inverters:         nil,
 		meterReadings:     nil,
 		meters:            nil,
+		liveData:          nil,
+		ensembleRelay:     nil,
+		ensembleSecCtrl:   nil,
+		dryContacts:       nil,
+		dryContactConfig:  nil,
+		generator:         nil,
+		evChargers:        nil,
 	}
 
 	prodCollector := NewProductionCollector(mock)
-	invCollector := NewInvertersCollector(mock)
+	invCollector := NewInvertersCollector(mock, InvertersConfig{})
 	meterCollector := NewMetersCollector(mock)
+	liveDataCollector := NewLiveDataCollector(mock)
+	ensembleCollector := NewEnsembleCollector(mock)
+	loadControlCollector := NewLoadControlCollector(mock)
+	evChargersCollector := NewEVChargersCollector(mock)
 
 	// These should not panic
 	ch := make(chan prometheus.Metric, 100)
 	prodCollector.Collect(ch)
 	invCollector.Collect(ch)
 	meterCollector.Collect(ch)
+	liveDataCollector.Collect(ch)
+	ensembleCollector.Collect(ch)
+	loadControlCollector.Collect(ch)
+	evChargersCollector.Collect(ch)
 }
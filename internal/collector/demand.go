@@ -0,0 +1,276 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Periods over which peak demand is tracked.
+const (
+	demandPeriodDay     = "day"
+	demandPeriodBilling = "billing"
+)
+
+var demandPeriods = []string{demandPeriodDay, demandPeriodBilling}
+
+// DemandConfig configures peak demand tracking.
+type DemandConfig struct {
+	// Windows are the demand interval lengths, e.g. 15 and 30 minutes.
+	// Intervals are aligned to local midnight like utility meters.
+	Windows []time.Duration
+	// CycleStartDay is the day of month the billing period starts (1 for
+	// calendar months).
+	CycleStartDay int
+}
+
+// Validate checks the demand configuration.
+func (c DemandConfig) Validate() error {
+	if len(c.Windows) == 0 {
+		return fmt.Errorf("no demand windows configured")
+	}
+	for _, w := range c.Windows {
+		if w < time.Minute || w%time.Minute != 0 || (24*time.Hour)%w != 0 {
+			return fmt.Errorf("demand window %s must be a whole number of minutes that divides a day", w)
+		}
+	}
+	if c.CycleStartDay < 1 || c.CycleStartDay > 31 {
+		return fmt.Errorf("cycle start day must be between 1 and 31, got %d", c.CycleStartDay)
+	}
+	return nil
+}
+
+// demandPeak is the highest completed interval average within a period.
+type demandPeak struct {
+	PeriodStart time.Time `json:"period_start"`
+	Watts       float64   `json:"watts"`
+	At          time.Time `json:"at"`
+}
+
+// demandWindow accumulates import energy over the current interval.
+type demandWindow struct {
+	length   time.Duration
+	label    string
+	start    time.Time
+	energyWs float64
+	covered  time.Duration
+	peaks    map[string]*demandPeak
+}
+
+// DemandCollector tracks interval-average grid import demand from the net
+// meter, and the peak interval per day and billing period.
+type DemandCollector struct {
+	config DemandConfig
+	loc    *time.Location
+
+	windows []*demandWindow
+	lastT   time.Time
+	lastW   float64
+	mu      sync.Mutex
+
+	currentWatts *prometheus.Desc
+	peakWatts    *prometheus.Desc
+	peakTime     *prometheus.Desc
+}
+
+// NewDemandCollector creates a DemandCollector. Intervals and periods are
+// aligned to local midnight in loc.
+func NewDemandCollector(config DemandConfig, loc *time.Location) *DemandCollector {
+	c := &DemandCollector{
+		config: config,
+		loc:    loc,
+		currentWatts: prometheus.NewDesc(
+			"enphase_demand_watts",
+			"Average grid import over the current demand interval so far",
+			[]string{"window"},
+			nil,
+		),
+		peakWatts: prometheus.NewDesc(
+			"enphase_demand_peak_watts",
+			"Highest completed interval-average grid import in the current period",
+			[]string{"window", "period"},
+			nil,
+		),
+		peakTime: prometheus.NewDesc(
+			"enphase_demand_peak_timestamp",
+			"Unix timestamp of the start of the peak demand interval",
+			[]string{"window", "period"},
+			nil,
+		),
+	}
+	for _, w := range config.Windows {
+		c.windows = append(c.windows, &demandWindow{
+			length: w,
+			label:  fmt.Sprintf("%dm", int(w/time.Minute)),
+			peaks:  make(map[string]*demandPeak),
+		})
+	}
+	return c
+}
+
+// intervalStart returns the start of the interval containing t, counted from
+// local midnight.
+func (c *DemandCollector) intervalStart(t time.Time, length time.Duration) time.Time {
+	midnight := periodStart(periodDay, t, c.loc)
+	return midnight.Add(t.Sub(midnight) / length * length)
+}
+
+// periodStart returns the start of the day or billing period containing t.
+func (c *DemandCollector) periodStart(period string, t time.Time) time.Time {
+	if period == demandPeriodBilling {
+		start, _ := billingCycleBounds(t, c.config.CycleStartDay, c.loc)
+		return start
+	}
+	return periodStart(periodDay, t, c.loc)
+}
+
+// ObserveNetPower implements NetPowerObserver. Power is held from one sample
+// to the next and split across interval boundaries. Export counts as zero
+// demand.
+func (c *DemandCollector) ObserveNetPower(t time.Time, watts float64) {
+	importW := watts
+	if importW < 0 {
+		importW = 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.lastT.IsZero() {
+		if gap := t.Sub(c.lastT); gap > 0 && gap < maxIntegrationGap {
+			for _, w := range c.windows {
+				c.integrate(w, c.lastT, t, c.lastW)
+			}
+		}
+	}
+	for _, w := range c.windows {
+		if w.start.IsZero() {
+			w.start = c.intervalStart(t, w.length)
+		}
+	}
+	c.lastT = t
+	c.lastW = importW
+}
+
+// integrate adds constant power over [from, to) to the window, closing any
+// intervals that end along the way. Must be called with mu held.
+func (c *DemandCollector) integrate(w *demandWindow, from, to time.Time, watts float64) {
+	for from.Before(to) {
+		if !from.Before(w.start.Add(w.length)) || from.Before(w.start) {
+			c.closeInterval(w, c.intervalStart(from, w.length))
+		}
+		end := w.start.Add(w.length)
+		segmentEnd := to
+		if end.Before(segmentEnd) {
+			segmentEnd = end
+		}
+		d := segmentEnd.Sub(from)
+		w.energyWs += watts * d.Seconds()
+		w.covered += d
+		from = segmentEnd
+	}
+}
+
+// closeInterval records the finished interval's average as a peak candidate
+// and starts a new interval. Intervals observed for less than half their
+// length aren't considered, so startup doesn't produce a spurious peak.
+func (c *DemandCollector) closeInterval(w *demandWindow, next time.Time) {
+	if !w.start.IsZero() && w.covered >= w.length/2 {
+		avg := w.energyWs / w.covered.Seconds()
+		for _, period := range demandPeriods {
+			periodStart := c.periodStart(period, w.start)
+			peak := w.peaks[period]
+			if peak == nil || !peak.PeriodStart.Equal(periodStart) || avg > peak.Watts {
+				w.peaks[period] = &demandPeak{PeriodStart: periodStart, Watts: avg, At: w.start}
+			}
+		}
+	}
+	w.start = next
+	w.energyWs = 0
+	w.covered = 0
+}
+
+// Describe implements prometheus.Collector.
+func (c *DemandCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.currentWatts
+	ch <- c.peakWatts
+	ch <- c.peakTime
+}
+
+// Collect implements prometheus.Collector.
+func (c *DemandCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, w := range c.windows {
+		if w.covered > 0 && !now.After(w.start.Add(w.length).Add(maxIntegrationGap)) {
+			ch <- prometheus.MustNewConstMetric(
+				c.currentWatts,
+				prometheus.GaugeValue,
+				w.energyWs/w.covered.Seconds(),
+				w.label,
+			)
+		}
+
+		for _, period := range demandPeriods {
+			// A peak from a previous day or billing period no longer applies
+			peak := w.peaks[period]
+			if peak == nil || !peak.PeriodStart.Equal(c.periodStart(period, now)) {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.peakWatts,
+				prometheus.GaugeValue,
+				peak.Watts,
+				w.label, period,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.peakTime,
+				prometheus.GaugeValue,
+				float64(peak.At.Unix()),
+				w.label, period,
+			)
+		}
+	}
+}
+
+// StateKey implements state.Persister.
+func (c *DemandCollector) StateKey() string {
+	return "demand"
+}
+
+// SaveState implements state.Persister. Only peaks are saved; the
+// in-progress interval restarts after a restart.
+func (c *DemandCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	peaks := make(map[string]map[string]*demandPeak, len(c.windows))
+	for _, w := range c.windows {
+		peaks[w.label] = w.peaks
+	}
+	return json.Marshal(peaks)
+}
+
+// RestoreState implements state.Persister.
+func (c *DemandCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var peaks map[string]map[string]*demandPeak
+	if err := json.Unmarshal(data, &peaks); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.windows {
+		for period, peak := range peaks[w.label] {
+			if peak != nil {
+				w.peaks[period] = peak
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rhwendt/enphase-exporter/internal/emissions"
+)
+
+// EmissionsCollector applies grid emission factors to energy samples. Each
+// sample uses the factor in effect when it was taken.
+type EmissionsCollector struct {
+	factors *emissions.Factors
+
+	// Running totals in kg CO2
+	avoidedKg      float64
+	emittedKg      float64
+	hasConsumption bool
+	mu             sync.Mutex
+
+	avoided *prometheus.Desc
+	emitted *prometheus.Desc
+	factor  *prometheus.Desc
+}
+
+// NewEmissionsCollector creates an EmissionsCollector for the given factors.
+func NewEmissionsCollector(factors *emissions.Factors) *EmissionsCollector {
+	return &EmissionsCollector{
+		factors: factors,
+		avoided: prometheus.NewDesc(
+			"enphase_co2_avoided_kg_total",
+			"Grid CO2 emissions displaced by solar production (self-consumed and exported) in kg",
+			nil,
+			nil,
+		),
+		emitted: prometheus.NewDesc(
+			"enphase_co2_emitted_kg_total",
+			"CO2 emissions of energy imported from the grid in kg",
+			nil,
+			nil,
+		),
+		factor: prometheus.NewDesc(
+			"enphase_grid_emission_factor_kg_per_kwh",
+			"Current grid emission factor in kg CO2 per kWh",
+			nil,
+			nil,
+		),
+	}
+}
+
+// ObserveEnergy implements EnergyObserver. Self-consumed plus exported energy
+// is all of production, so avoided emissions don't need consumption CTs;
+// emitted emissions do.
+func (c *EmissionsCollector) ObserveEnergy(s EnergySample) {
+	factor := c.factors.Lookup(s.Time)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.avoidedKg += s.ProductionWh / 1000 * factor
+	if s.HasConsumption {
+		c.hasConsumption = true
+		c.emittedKg += s.ImportWh / 1000 * factor
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *EmissionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.avoided
+	ch <- c.emitted
+	ch <- c.factor
+}
+
+// Collect implements prometheus.Collector.
+func (c *EmissionsCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		c.factor,
+		prometheus.GaugeValue,
+		c.factors.Lookup(time.Now()),
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(
+		c.avoided,
+		prometheus.CounterValue,
+		c.avoidedKg,
+	)
+	if c.hasConsumption {
+		ch <- prometheus.MustNewConstMetric(
+			c.emitted,
+			prometheus.CounterValue,
+			c.emittedKg,
+		)
+	}
+}
+
+// emissionsState is the persisted form of the emissions totals.
+type emissionsState struct {
+	AvoidedKg      float64 `json:"avoided_kg"`
+	EmittedKg      float64 `json:"emitted_kg"`
+	HasConsumption bool    `json:"has_consumption"`
+}
+
+// StateKey implements state.Persister.
+func (c *EmissionsCollector) StateKey() string {
+	return "emissions"
+}
+
+// SaveState implements state.Persister.
+func (c *EmissionsCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(emissionsState{
+		AvoidedKg:      c.avoidedKg,
+		EmittedKg:      c.emittedKg,
+		HasConsumption: c.hasConsumption,
+	})
+}
+
+// RestoreState implements state.Persister.
+func (c *EmissionsCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var st emissionsState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.avoidedKg = st.AvoidedKg
+	c.emittedKg = st.EmittedKg
+	c.hasConsumption = st.HasConsumption
+	return nil
+}
@@ -0,0 +1,63 @@
+package collector
+
+import "time"
+
+// Energy flows reported in an EnergySample.
+const (
+	flowProduction  = "production"
+	flowConsumption = "consumption"
+	flowImport      = "import"
+	flowExport      = "export"
+)
+
+// EnergySample describes one production scrape. Energy values are the change
+// in the gateway's lifetime counters since the previous scrape, so observers
+// can accumulate them into their own periods without handling counter resets.
+type EnergySample struct {
+	Time time.Time
+
+	// Instantaneous power in watts. StorageW is positive when batteries are
+	// discharging and negative when charging.
+	ProductionW  float64
+	ConsumptionW float64
+	StorageW     float64
+
+	// Energy since the previous sample in Wh
+	ProductionWh  float64
+	ConsumptionWh float64
+	ImportWh      float64
+	ExportWh      float64
+
+	// HasConsumption is false on production-only sites, where consumption
+	// and grid values are always zero
+	HasConsumption bool
+	// HasStorage is true when the gateway reported active batteries
+	HasStorage bool
+}
+
+// EnergyObserver receives an EnergySample after each successful production scrape.
+type EnergyObserver interface {
+	ObserveEnergy(EnergySample)
+}
+
+// lifetimeCounter turns a lifetime Wh counter into per-scrape deltas.
+// Source identifies where the value came from (e.g. production CT vs inverter
+// aggregate); switching source would otherwise produce a bogus jump.
+type lifetimeCounter struct {
+	Wh     float64 `json:"wh"`
+	Source string  `json:"source"`
+	Valid  bool    `json:"valid"`
+}
+
+// delta records a new counter value and returns the energy since the previous
+// one. The first value, a source change or a counter reset all return 0.
+func (l *lifetimeCounter) delta(wh float64, source string) float64 {
+	var d float64
+	if l.Valid && l.Source == source && wh >= l.Wh {
+		d = wh - l.Wh
+	}
+	l.Wh = wh
+	l.Source = source
+	l.Valid = true
+	return d
+}
@@ -0,0 +1,307 @@
+package collector
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Endpoints of energy flows.
+const (
+	flowNodeSolar   = "solar"
+	flowNodeGrid    = "grid"
+	flowNodeHome    = "home"
+	flowNodeBattery = "battery"
+)
+
+// flowBucketWidth is the resolution of the rolling ratio windows.
+const flowBucketWidth = 5 * time.Minute
+
+// ratioWindows are the rolling windows self-consumption and self-sufficiency
+// are reported over.
+var ratioWindows = []struct {
+	label    string
+	duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// flowTotals is energy in Wh split by source and destination.
+type flowTotals struct {
+	SolarHome    float64 `json:"solar_home"`
+	SolarGrid    float64 `json:"solar_grid"`
+	SolarBattery float64 `json:"solar_battery"`
+	GridHome     float64 `json:"grid_home"`
+	GridBattery  float64 `json:"grid_battery"`
+	BatteryHome  float64 `json:"battery_home"`
+	BatteryGrid  float64 `json:"battery_grid"`
+}
+
+func (f *flowTotals) add(o flowTotals) {
+	f.SolarHome += o.SolarHome
+	f.SolarGrid += o.SolarGrid
+	f.SolarBattery += o.SolarBattery
+	f.GridHome += o.GridHome
+	f.GridBattery += o.GridBattery
+	f.BatteryHome += o.BatteryHome
+	f.BatteryGrid += o.BatteryGrid
+}
+
+// decomposeFlows splits one sample's energy into flows. All inputs are
+// non-negative Wh. Home consumption is what the energy balance leaves over.
+// Solar serves the home first, then charges the battery, and the rest is
+// exported; export beyond that came from the battery, and import beyond what
+// charged the battery went to the home.
+func decomposeFlows(productionWh, importWh, exportWh, chargeWh, dischargeWh float64) flowTotals {
+	var f flowTotals
+	homeWh := math.Max(0, productionWh+importWh+dischargeWh-exportWh-chargeWh)
+	f.SolarHome = math.Min(productionWh, homeWh)
+	f.SolarBattery = math.Min(chargeWh, productionWh-f.SolarHome)
+	f.SolarGrid = productionWh - f.SolarHome - f.SolarBattery
+	f.BatteryGrid = math.Max(0, math.Min(dischargeWh, exportWh-f.SolarGrid))
+	f.BatteryHome = dischargeWh - f.BatteryGrid
+	f.GridBattery = math.Max(0, math.Min(importWh, chargeWh-f.SolarBattery))
+	f.GridHome = importWh - f.GridBattery
+	return f
+}
+
+// flowSeries is one from/to pair of flowTotals.
+type flowSeries struct {
+	from, to string
+	wh       float64
+}
+
+// series lists the flows, including battery flows only if withStorage is set.
+func (f flowTotals) series(withStorage bool) []flowSeries {
+	series := []flowSeries{
+		{flowNodeSolar, flowNodeHome, f.SolarHome},
+		{flowNodeSolar, flowNodeGrid, f.SolarGrid},
+		{flowNodeGrid, flowNodeHome, f.GridHome},
+	}
+	if withStorage {
+		series = append(series,
+			flowSeries{flowNodeSolar, flowNodeBattery, f.SolarBattery},
+			flowSeries{flowNodeGrid, flowNodeBattery, f.GridBattery},
+			flowSeries{flowNodeBattery, flowNodeHome, f.BatteryHome},
+			flowSeries{flowNodeBattery, flowNodeGrid, f.BatteryGrid},
+		)
+	}
+	return series
+}
+
+// selfConsumption returns the share of production used on site (home or
+// battery). ok is false when nothing was produced.
+func (f flowTotals) selfConsumption() (float64, bool) {
+	produced := f.SolarHome + f.SolarBattery + f.SolarGrid
+	if produced <= 0 {
+		return 0, false
+	}
+	return (f.SolarHome + f.SolarBattery) / produced, true
+}
+
+// selfSufficiency returns the share of home consumption not drawn from the
+// grid. ok is false when nothing was consumed.
+func (f flowTotals) selfSufficiency() (float64, bool) {
+	consumed := f.SolarHome + f.BatteryHome + f.GridHome
+	if consumed <= 0 {
+		return 0, false
+	}
+	return (f.SolarHome + f.BatteryHome) / consumed, true
+}
+
+// flowBucket holds the flows for one flowBucketWidth interval.
+type flowBucket struct {
+	Start time.Time  `json:"start"`
+	Flows flowTotals `json:"flows"`
+}
+
+// EnergyFlowCollector splits energy samples into solar, grid, home and
+// battery flows, and reports self-consumption and self-sufficiency over
+// rolling windows.
+type EnergyFlowCollector struct {
+	totals     flowTotals
+	buckets    []flowBucket
+	hasStorage bool
+	lastSample time.Time
+	mu         sync.Mutex
+
+	flowWh          *prometheus.Desc
+	selfConsumption *prometheus.Desc
+	selfSufficiency *prometheus.Desc
+}
+
+// NewEnergyFlowCollector creates an EnergyFlowCollector.
+func NewEnergyFlowCollector() *EnergyFlowCollector {
+	return &EnergyFlowCollector{
+		flowWh: prometheus.NewDesc(
+			"enphase_energy_flow_wh_total",
+			"Energy flowing from one part of the site to another in watt-hours",
+			[]string{"from", "to"},
+			nil,
+		),
+		selfConsumption: prometheus.NewDesc(
+			"enphase_self_consumption_ratio",
+			"Share of solar production used on site rather than exported, over a rolling window",
+			[]string{"window"},
+			nil,
+		),
+		selfSufficiency: prometheus.NewDesc(
+			"enphase_self_sufficiency_ratio",
+			"Share of home consumption supplied by solar or battery rather than the grid, over a rolling window",
+			[]string{"window"},
+			nil,
+		),
+	}
+}
+
+// ObserveEnergy implements EnergyObserver. Flows need consumption CTs, so
+// production-only samples are ignored. Battery energy is integrated from
+// storage power since the previous sample.
+func (c *EnergyFlowCollector) ObserveEnergy(s EnergySample) {
+	if !s.HasConsumption {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var chargeWh, dischargeWh float64
+	if s.HasStorage {
+		c.hasStorage = true
+		if elapsed := s.Time.Sub(c.lastSample); !c.lastSample.IsZero() && elapsed > 0 && elapsed < maxIntegrationGap {
+			if s.StorageW > 0 {
+				dischargeWh = s.StorageW * elapsed.Hours()
+			} else {
+				chargeWh = -s.StorageW * elapsed.Hours()
+			}
+		}
+	}
+	c.lastSample = s.Time
+
+	flows := decomposeFlows(s.ProductionWh, s.ImportWh, s.ExportWh, chargeWh, dischargeWh)
+	c.totals.add(flows)
+
+	start := s.Time.Truncate(flowBucketWidth)
+	if n := len(c.buckets); n > 0 && c.buckets[n-1].Start.Equal(start) {
+		c.buckets[n-1].Flows.add(flows)
+	} else {
+		c.buckets = append(c.buckets, flowBucket{Start: start, Flows: flows})
+	}
+	c.prune(s.Time)
+}
+
+// prune drops buckets older than the longest window. Must be called with mu held.
+func (c *EnergyFlowCollector) prune(now time.Time) {
+	cutoff := now.Add(-ratioWindows[len(ratioWindows)-1].duration)
+	i := 0
+	for i < len(c.buckets) && !c.buckets[i].Start.After(cutoff) {
+		i++
+	}
+	c.buckets = c.buckets[i:]
+}
+
+// windowFlows sums the buckets starting within d of now. Must be called with mu held.
+func (c *EnergyFlowCollector) windowFlows(now time.Time, d time.Duration) flowTotals {
+	var sum flowTotals
+	cutoff := now.Add(-d)
+	for _, b := range c.buckets {
+		if b.Start.After(cutoff) {
+			sum.add(b.Flows)
+		}
+	}
+	return sum
+}
+
+// Describe implements prometheus.Collector.
+func (c *EnergyFlowCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.flowWh
+	ch <- c.selfConsumption
+	ch <- c.selfSufficiency
+}
+
+// Collect implements prometheus.Collector.
+func (c *EnergyFlowCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Nothing to report until consumption CTs have produced a sample
+	if c.lastSample.IsZero() && c.totals == (flowTotals{}) {
+		return
+	}
+
+	for _, f := range c.totals.series(c.hasStorage) {
+		ch <- prometheus.MustNewConstMetric(
+			c.flowWh,
+			prometheus.CounterValue,
+			f.wh,
+			f.from, f.to,
+		)
+	}
+
+	for _, w := range ratioWindows {
+		sum := c.windowFlows(now, w.duration)
+		if ratio, ok := sum.selfConsumption(); ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.selfConsumption,
+				prometheus.GaugeValue,
+				ratio,
+				w.label,
+			)
+		}
+		if ratio, ok := sum.selfSufficiency(); ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.selfSufficiency,
+				prometheus.GaugeValue,
+				ratio,
+				w.label,
+			)
+		}
+	}
+}
+
+// energyFlowState is the persisted form of the flow totals and ratio windows.
+type energyFlowState struct {
+	Totals     flowTotals   `json:"totals"`
+	Buckets    []flowBucket `json:"buckets"`
+	HasStorage bool         `json:"has_storage"`
+}
+
+// StateKey implements state.Persister.
+func (c *EnergyFlowCollector) StateKey() string {
+	return "energy_flow"
+}
+
+// SaveState implements state.Persister.
+func (c *EnergyFlowCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(energyFlowState{
+		Totals:     c.totals,
+		Buckets:    c.buckets,
+		HasStorage: c.hasStorage,
+	})
+}
+
+// RestoreState implements state.Persister. Buckets that have aged out of
+// every window while the exporter was down are dropped.
+func (c *EnergyFlowCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var st energyFlowState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.totals = st.Totals
+	c.buckets = st.Buckets
+	c.hasStorage = st.HasStorage
+	c.prune(time.Now())
+	return nil
+}
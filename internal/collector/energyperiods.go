@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reporting periods, reset at local midnight. Weeks start on Monday.
+const (
+	periodDay   = "day"
+	periodWeek  = "week"
+	periodMonth = "month"
+)
+
+var (
+	energyPeriods = []string{periodDay, periodWeek, periodMonth}
+	energyFlows   = []string{flowProduction, flowConsumption, flowImport, flowExport}
+)
+
+// EnergyPeriodsCollector accumulates energy samples into day, week and month
+// totals in a local timezone. Totals are computed by the exporter from the
+// lifetime counters, so they don't depend on the gateway's daily values.
+type EnergyPeriodsCollector struct {
+	loc *time.Location
+
+	// Totals in Wh by period and flow, and the start of each current period
+	totals         map[string]map[string]float64
+	starts         map[string]time.Time
+	hasConsumption bool
+	mu             sync.Mutex
+
+	energyWh    *prometheus.Desc
+	periodStart *prometheus.Desc
+}
+
+// NewEnergyPeriodsCollector creates an EnergyPeriodsCollector whose periods
+// reset at midnight in loc.
+func NewEnergyPeriodsCollector(loc *time.Location) *EnergyPeriodsCollector {
+	return &EnergyPeriodsCollector{
+		loc:    loc,
+		totals: make(map[string]map[string]float64),
+		starts: make(map[string]time.Time),
+		energyWh: prometheus.NewDesc(
+			"enphase_energy_wh",
+			"Energy in the current day, week or month in watt-hours (resets at local midnight)",
+			[]string{"flow", "period"},
+			nil,
+		),
+		periodStart: prometheus.NewDesc(
+			"enphase_energy_period_start_timestamp",
+			"Unix timestamp of the start of the current period",
+			[]string{"period"},
+			nil,
+		),
+	}
+}
+
+// ObserveEnergy implements EnergyObserver.
+func (c *EnergyPeriodsCollector) ObserveEnergy(s EnergySample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.roll(s.Time)
+	if s.HasConsumption {
+		c.hasConsumption = true
+	}
+	for _, period := range energyPeriods {
+		totals := c.totals[period]
+		totals[flowProduction] += s.ProductionWh
+		totals[flowConsumption] += s.ConsumptionWh
+		totals[flowImport] += s.ImportWh
+		totals[flowExport] += s.ExportWh
+	}
+}
+
+// roll starts new periods whose boundary has passed. Must be called with mu held.
+func (c *EnergyPeriodsCollector) roll(now time.Time) {
+	for _, period := range energyPeriods {
+		start := periodStart(period, now, c.loc)
+		if !start.Equal(c.starts[period]) || c.totals[period] == nil {
+			c.starts[period] = start
+			c.totals[period] = make(map[string]float64, len(energyFlows))
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *EnergyPeriodsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.energyWh
+	ch <- c.periodStart
+}
+
+// Collect implements prometheus.Collector.
+func (c *EnergyPeriodsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Roll over even without new samples so totals drop to zero at midnight
+	c.roll(time.Now())
+
+	for _, period := range energyPeriods {
+		ch <- prometheus.MustNewConstMetric(
+			c.periodStart,
+			prometheus.GaugeValue,
+			float64(c.starts[period].Unix()),
+			period,
+		)
+		for _, flow := range energyFlows {
+			// Consumption and grid flows are meaningless on production-only sites
+			if flow != flowProduction && !c.hasConsumption {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.energyWh,
+				prometheus.GaugeValue,
+				c.totals[period][flow],
+				flow, period,
+			)
+		}
+	}
+}
+
+// energyPeriodsState is the persisted form of the current period totals.
+type energyPeriodsState struct {
+	Starts         map[string]time.Time          `json:"starts"`
+	Totals         map[string]map[string]float64 `json:"totals"`
+	HasConsumption bool                          `json:"has_consumption"`
+}
+
+// StateKey implements state.Persister.
+func (c *EnergyPeriodsCollector) StateKey() string {
+	return "energy_periods"
+}
+
+// SaveState implements state.Persister.
+func (c *EnergyPeriodsCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(energyPeriodsState{
+		Starts:         c.starts,
+		Totals:         c.totals,
+		HasConsumption: c.hasConsumption,
+	})
+}
+
+// RestoreState implements state.Persister. Periods that ended while the
+// exporter was down are discarded on the next roll.
+func (c *EnergyPeriodsCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var st energyPeriodsState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, period := range energyPeriods {
+		if totals, ok := st.Totals[period]; ok && totals != nil {
+			c.starts[period] = st.Starts[period]
+			c.totals[period] = totals
+		}
+	}
+	c.hasConsumption = st.HasConsumption
+	return nil
+}
+
+// periodStart returns local midnight at the start of the period containing t.
+// Using time.Date keeps boundaries correct across DST transitions.
+func periodStart(period string, t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch period {
+	case periodWeek:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, loc)
+	case periodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
@@ -0,0 +1,268 @@
+package collector
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var ensembleLog = logrus.WithField("collector", "ensemble")
+
+// Relay states reported by the IQ System Controller.
+const (
+	relayClosed = "closed"
+	relayOpen   = "open"
+)
+
+// Grid modes reported by the ensemble. Off-grid means the batteries are
+// forming the grid; on-grid means they are following the utility.
+const (
+	ensembleModeOnGrid  = "multimode-ongrid"
+	ensembleModeOffGrid = "multimode-offgrid"
+
+	gridModeForming   = "grid-forming"
+	gridModeFollowing = "grid-following"
+)
+
+// EnsembleCollector collects IQ System Controller relay and islanding metrics.
+type EnsembleCollector struct {
+	client EnphaseClient
+
+	mainRelayClosed     *prometheus.Desc
+	gridMode            *prometheus.Desc
+	lastTransition      *prometheus.Desc
+	islandingEventsDesc *prometheus.Desc
+	shutdown            *prometheus.Desc
+	freqBias            *prometheus.Desc
+	voltageBias         *prometheus.Desc
+
+	// Relay transition tracking
+	lastOperState   string
+	lastTransitionT time.Time
+	islandingEvents float64
+	mu              sync.Mutex
+}
+
+// NewEnsembleCollector creates a new EnsembleCollector.
+func NewEnsembleCollector(client EnphaseClient) *EnsembleCollector {
+	return &EnsembleCollector{
+		client: client,
+		mainRelayClosed: prometheus.NewDesc(
+			"enphase_ensemble_main_relay_closed",
+			"Main relay state (1 = closed/on-grid, 0 = open/islanded)",
+			[]string{"state"},
+			nil,
+		),
+		gridMode: prometheus.NewDesc(
+			"enphase_ensemble_grid_mode",
+			"Ensemble grid mode (1 for the active mode)",
+			[]string{"component", "mode"},
+			nil,
+		),
+		lastTransition: prometheus.NewDesc(
+			"enphase_ensemble_main_relay_last_transition_timestamp",
+			"Unix timestamp of the last observed main relay transition",
+			nil,
+			nil,
+		),
+		islandingEventsDesc: prometheus.NewDesc(
+			"enphase_ensemble_islanding_events_total",
+			"Number of observed transitions from on-grid to islanded",
+			nil,
+			nil,
+		),
+		shutdown: prometheus.NewDesc(
+			"enphase_ensemble_shutdown",
+			"Ensemble secondary control shutdown state (1 = shut down)",
+			nil,
+			nil,
+		),
+		freqBias: prometheus.NewDesc(
+			"enphase_ensemble_frequency_bias_hz",
+			"Frequency bias applied by the ensemble while grid-forming",
+			nil,
+			nil,
+		),
+		voltageBias: prometheus.NewDesc(
+			"enphase_ensemble_voltage_bias_volts",
+			"Voltage bias applied by the ensemble while grid-forming",
+			nil,
+			nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *EnsembleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.mainRelayClosed
+	ch <- c.gridMode
+	ch <- c.lastTransition
+	ch <- c.islandingEventsDesc
+	ch <- c.shutdown
+	ch <- c.freqBias
+	ch <- c.voltageBias
+}
+
+// Collect implements prometheus.Collector.
+func (c *EnsembleCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	relay, err := c.client.GetEnsembleRelay()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("ensemble_relay").Observe(duration.Seconds())
+	ensembleLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetEnsembleRelay completed")
+	if err != nil {
+		ensembleLog.WithError(err).Error("Failed to get ensemble relay state")
+		return
+	}
+
+	if relay == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.mainRelayClosed,
+		prometheus.GaugeValue,
+		boolToFloat(relay.MainsOperState == relayClosed),
+		"operational",
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.mainRelayClosed,
+		prometheus.GaugeValue,
+		boolToFloat(relay.MainsAdminState == relayClosed),
+		"administrative",
+	)
+
+	c.emitGridMode(ch, "encharge", relay.EnchgGridMode)
+	c.emitGridMode(ch, "solar", relay.SolarGridMode)
+
+	// Track relay transitions between scrapes
+	c.mu.Lock()
+	if relay.MainsOperState != "" && c.lastOperState != "" && relay.MainsOperState != c.lastOperState {
+		c.lastTransitionT = time.Now()
+		if c.lastOperState == relayClosed && relay.MainsOperState == relayOpen {
+			c.islandingEvents++
+			ensembleLog.Warn("Main relay opened, site is islanded")
+		} else {
+			ensembleLog.Info("Main relay closed, site is back on-grid")
+		}
+	}
+	if relay.MainsOperState != "" {
+		c.lastOperState = relay.MainsOperState
+	}
+	lastTransition := c.lastTransitionT
+	islandingEvents := c.islandingEvents
+	c.mu.Unlock()
+
+	if !lastTransition.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			c.lastTransition,
+			prometheus.GaugeValue,
+			float64(lastTransition.Unix()),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.islandingEventsDesc,
+		prometheus.CounterValue,
+		islandingEvents,
+	)
+
+	// Secondary control is informational; relay metrics are still useful without it
+	start = time.Now()
+	secctrl, err := c.client.GetEnsembleSecCtrl()
+	duration = time.Since(start)
+	APICallDuration.WithLabelValues("ensemble_secctrl").Observe(duration.Seconds())
+	ensembleLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetEnsembleSecCtrl completed")
+	if err != nil {
+		ensembleLog.WithError(err).Error("Failed to get ensemble secctrl state")
+		return
+	}
+
+	if secctrl == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.shutdown,
+		prometheus.GaugeValue,
+		boolToFloat(secctrl.SecCtrl.Shutdown),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.freqBias,
+		prometheus.GaugeValue,
+		secctrl.SecCtrl.FreqBiasHz,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.voltageBias,
+		prometheus.GaugeValue,
+		secctrl.SecCtrl.VoltageBiasV,
+	)
+}
+
+// emitGridMode exports grid-forming/grid-following as a state set.
+// Unknown modes report 0 for both states.
+func (c *EnsembleCollector) emitGridMode(ch chan<- prometheus.Metric, component, mode string) {
+	ch <- prometheus.MustNewConstMetric(
+		c.gridMode,
+		prometheus.GaugeValue,
+		boolToFloat(mode == ensembleModeOffGrid),
+		component, gridModeForming,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.gridMode,
+		prometheus.GaugeValue,
+		boolToFloat(mode == ensembleModeOnGrid),
+		component, gridModeFollowing,
+	)
+}
+
+// ensembleState is the persisted form of relay transition tracking.
+type ensembleState struct {
+	IslandingEvents float64   `json:"islanding_events"`
+	LastTransition  time.Time `json:"last_transition"`
+	LastOperState   string    `json:"last_oper_state"`
+}
+
+// StateKey implements state.Persister.
+func (c *EnsembleCollector) StateKey() string {
+	return "ensemble"
+}
+
+// SaveState implements state.Persister.
+func (c *EnsembleCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(ensembleState{
+		IslandingEvents: c.islandingEvents,
+		LastTransition:  c.lastTransitionT,
+		LastOperState:   c.lastOperState,
+	})
+}
+
+// RestoreState implements state.Persister. The last relay state is only
+// restored after a short restart; after longer downtime a transition can't
+// be timestamped, so tracking starts fresh.
+func (c *EnsembleCollector) RestoreState(data json.RawMessage, savedAt time.Time) error {
+	var st ensembleState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.islandingEvents = st.IslandingEvents
+	c.lastTransitionT = st.LastTransition
+	if age := time.Since(savedAt); age >= 0 && age < maxIntegrationGap {
+		c.lastOperState = st.LastOperState
+	}
+	return nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
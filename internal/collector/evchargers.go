@@ -0,0 +1,183 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var evChargersLog = logrus.WithField("collector", "evchargers")
+
+// Normalized EV charger states.
+const (
+	evStateAvailable = "available"
+	evStateCharging  = "charging"
+	evStateFaulted   = "faulted"
+	evStateUnknown   = "unknown"
+)
+
+// EVChargersCollector collects IQ EV Charger metrics via the gateway.
+type EVChargersCollector struct {
+	client EnphaseClient
+
+	// Unrecognized gateway states already logged
+	warned map[string]bool
+	mu     sync.Mutex
+
+	state           *prometheus.Desc
+	connected       *prometheus.Desc
+	powerWatts      *prometheus.Desc
+	sessionWh       *prometheus.Desc
+	sessionDuration *prometheus.Desc
+	whTotal         *prometheus.Desc
+}
+
+// NewEVChargersCollector creates a new EVChargersCollector.
+func NewEVChargersCollector(client EnphaseClient) *EVChargersCollector {
+	return &EVChargersCollector{
+		client: client,
+		warned: make(map[string]bool),
+		state: prometheus.NewDesc(
+			"enphase_ev_charger_state",
+			"EV charger state (1 for the current state)",
+			[]string{"serial_number", "state"},
+			nil,
+		),
+		connected: prometheus.NewDesc(
+			"enphase_ev_charger_connected",
+			"Whether a vehicle is plugged into the charger",
+			[]string{"serial_number"},
+			nil,
+		),
+		powerWatts: prometheus.NewDesc(
+			"enphase_ev_charger_power_watts",
+			"Current EV charging power in watts",
+			[]string{"serial_number"},
+			nil,
+		),
+		sessionWh: prometheus.NewDesc(
+			"enphase_ev_charger_session_wh",
+			"Energy delivered in the current charging session in watt-hours",
+			[]string{"serial_number"},
+			nil,
+		),
+		sessionDuration: prometheus.NewDesc(
+			"enphase_ev_charger_session_duration_seconds",
+			"Duration of the current charging session in seconds",
+			[]string{"serial_number"},
+			nil,
+		),
+		whTotal: prometheus.NewDesc(
+			"enphase_ev_charger_wh_total",
+			"Total lifetime energy delivered by the charger in watt-hours",
+			[]string{"serial_number"},
+			nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *EVChargersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.connected
+	ch <- c.powerWatts
+	ch <- c.sessionWh
+	ch <- c.sessionDuration
+	ch <- c.whTotal
+}
+
+// Collect implements prometheus.Collector.
+func (c *EVChargersCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	chargers, err := c.client.GetEVChargers()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("ev_chargers").Observe(duration.Seconds())
+	evChargersLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetEVChargers completed")
+	if err != nil {
+		evChargersLog.WithError(err).Error("Failed to get EV charger data")
+		return
+	}
+
+	if chargers == nil {
+		return
+	}
+
+	for _, evse := range chargers.Chargers {
+		serial := evse.SerialNumber
+
+		state := evChargerState(evse.State)
+		if state == evStateUnknown {
+			c.warnState(serial, evse.State)
+		}
+		for _, s := range []string{evStateAvailable, evStateCharging, evStateFaulted, evStateUnknown} {
+			ch <- prometheus.MustNewConstMetric(
+				c.state,
+				prometheus.GaugeValue,
+				boolToFloat(s == state),
+				serial, s,
+			)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.connected,
+			prometheus.GaugeValue,
+			boolToFloat(evse.Connected),
+			serial,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.powerWatts,
+			prometheus.GaugeValue,
+			evse.ChargePowerW,
+			serial,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.sessionWh,
+			prometheus.GaugeValue,
+			evse.SessionEnergyWh,
+			serial,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.sessionDuration,
+			prometheus.GaugeValue,
+			evse.SessionDurationS,
+			serial,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.whTotal,
+			prometheus.CounterValue,
+			evse.LifetimeEnergyWh,
+			serial,
+		)
+	}
+}
+
+// warnState logs an unrecognized gateway state once per state value.
+func (c *EVChargersCollector) warnState(serial, state string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.warned[state] {
+		return
+	}
+	c.warned[state] = true
+	evChargersLog.WithFields(logrus.Fields{
+		"serial_number": serial,
+		"state":         state,
+	}).Warn("Unrecognized EV charger state, reporting it as unknown")
+}
+
+// evChargerState maps gateway charger states onto available/charging/faulted.
+// Plugged-in but idle chargers (connected, suspended) count as available;
+// anything else is unknown, so a new fault state isn't hidden as available.
+func evChargerState(state string) string {
+	switch state {
+	case "charging":
+		return evStateCharging
+	case "faulted", "error":
+		return evStateFaulted
+	case "available", "idle", "connected", "suspended":
+		return evStateAvailable
+	default:
+		return evStateUnknown
+	}
+}
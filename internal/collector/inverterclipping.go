@@ -0,0 +1,439 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
+)
+
+const (
+	// clippingFitWindow is how far either side of a plateau unclipped reports
+	// are used to reconstruct the unclipped curve
+	clippingFitWindow = 3 * time.Hour
+	// clippingFitSamples is the number of unclipped reports after a plateau
+	// that are waited for before estimating its clipped energy
+	clippingFitSamples = 3
+)
+
+// inverterModels maps microinverter models to their maximum continuous AC
+// output in watts, from Enphase datasheets.
+var inverterModels = map[string]float64{
+	"M215":    215,
+	"M250":    250,
+	"IQ6":     230,
+	"IQ6PLUS": 280,
+	"IQ7":     240,
+	"IQ7PLUS": 290,
+	"IQ7X":    315,
+	"IQ7A":    349,
+	"IQ8":     240,
+	"IQ8PLUS": 290,
+	"IQ8M":    325,
+	"IQ8A":    349,
+	"IQ8H":    380,
+}
+
+// InverterModelRating returns the continuous AC rating of a microinverter
+// model such as "IQ7+", "IQ7PLUS" or a part number like "IQ7PLUS-72-2-US".
+func InverterModelRating(model string) (float64, bool) {
+	model = strings.ToUpper(strings.ReplaceAll(model, " ", ""))
+	model = strings.ReplaceAll(model, "+", "PLUS")
+	model, _, _ = strings.Cut(model, "-")
+	watts, ok := inverterModels[model]
+	return watts, ok
+}
+
+// ClippingConfig configures inverter clipping detection.
+type ClippingConfig struct {
+	// DefaultRating is the AC rating in watts of inverters not in Ratings;
+	// zero leaves them unrated
+	DefaultRating float64
+	// Ratings overrides the AC rating by serial number
+	Ratings map[string]float64
+	// Margin is the fraction below the rating that still counts as at the
+	// ceiling, since reports are averages over the report interval
+	Margin float64
+	// MinDuration is how long output must stay at the ceiling to count as
+	// clipping rather than a passing peak
+	MinDuration time.Duration
+	// Labels adds layout labels to the per-inverter metrics
+	Labels InverterLabels
+}
+
+// Validate checks the clipping configuration.
+func (c ClippingConfig) Validate() error {
+	if c.DefaultRating < 0 {
+		return fmt.Errorf("default rating must not be negative, got %v", c.DefaultRating)
+	}
+	if c.DefaultRating == 0 && len(c.Ratings) == 0 {
+		return fmt.Errorf("a default rating or per-inverter ratings are required")
+	}
+	for serial, watts := range c.Ratings {
+		if watts <= 0 {
+			return fmt.Errorf("inverter %s: rating must be positive, got %v", serial, watts)
+		}
+	}
+	if c.Margin < 0 || c.Margin >= 0.5 {
+		return fmt.Errorf("margin must be between 0 and 0.5, got %v", c.Margin)
+	}
+	if c.MinDuration < 0 {
+		return fmt.Errorf("minimum duration must not be negative, got %s", c.MinDuration)
+	}
+	return nil
+}
+
+// rating returns an inverter's AC rating, or 0 if it is unrated.
+func (c ClippingConfig) rating(serial string) float64 {
+	if watts, ok := c.Ratings[serial]; ok {
+		return watts
+	}
+	return c.DefaultRating
+}
+
+// clipSample is an unclipped report, timed at the middle of the interval it
+// averages.
+type clipSample struct {
+	t     float64 // Unix seconds
+	watts float64
+}
+
+// clippingTracker follows one inverter's reports for plateaus at its rating.
+type clippingTracker struct {
+	lastReport int64
+	// Current run of reports at the ceiling: where it started, seconds not yet
+	// counted because it hasn't lasted MinDuration, and whether it has
+	runStart  int64
+	pending   float64
+	sustained bool
+	// A finished plateau awaiting enough later reports to estimate its energy
+	plateauStart, plateauEnd int64
+	after                    int
+	samples                  []clipSample
+
+	Seconds   float64 `json:"seconds"`
+	ClippedWh float64 `json:"clipped_wh"`
+}
+
+// update records a report. Only new reports are considered, so the result
+// doesn't depend on scrape timing.
+func (t *clippingTracker) update(date int64, watts, rating float64, config ClippingConfig) {
+	if date <= t.lastReport {
+		return
+	}
+	prev := t.lastReport
+	t.lastReport = date
+	if prev == 0 || time.Duration(date-prev)*time.Second > maxReportGap {
+		// A gap breaks any run and any curve being fitted
+		t.endRun(prev)
+		t.finishPlateau(rating)
+		t.samples = nil
+		return
+	}
+
+	if watts >= rating*(1-config.Margin) {
+		if t.runStart == 0 {
+			// A new plateau ends fitting of the previous one
+			t.finishPlateau(rating)
+			t.runStart = prev
+		}
+		t.pending += float64(date - prev)
+		if !t.sustained && time.Duration(date-t.runStart)*time.Second >= config.MinDuration {
+			t.sustained = true
+		}
+		if t.sustained {
+			t.Seconds += t.pending
+			t.pending = 0
+		}
+		return
+	}
+
+	t.endRun(prev)
+	t.samples = append(t.samples, clipSample{t: float64(prev+date) / 2, watts: watts})
+	cutoff := float64(date) - 2*clippingFitWindow.Seconds()
+	i := 0
+	for i < len(t.samples) && t.samples[i].t < cutoff {
+		i++
+	}
+	t.samples = t.samples[i:]
+	if t.plateauEnd != 0 {
+		t.after++
+		if t.after >= clippingFitSamples {
+			t.finishPlateau(rating)
+		}
+	}
+}
+
+// expire ends a run whose inverter hasn't reported for longer than
+// maxReportGap, so an inverter that stops reporting mid-plateau doesn't stay
+// clipping.
+func (t *clippingTracker) expire(now time.Time) {
+	if t.runStart != 0 && now.Sub(time.Unix(t.lastReport, 0)) > maxReportGap {
+		t.endRun(t.lastReport)
+	}
+}
+
+// endRun ends the current run at end, keeping it for an energy estimate if it
+// was sustained.
+func (t *clippingTracker) endRun(end int64) {
+	if t.sustained {
+		t.plateauStart, t.plateauEnd, t.after = t.runStart, end, 0
+	}
+	t.runStart, t.pending, t.sustained = 0, 0, false
+}
+
+// finishPlateau adds the estimated clipped energy of the last sustained
+// plateau, if any.
+func (t *clippingTracker) finishPlateau(rating float64) {
+	if t.plateauEnd == 0 {
+		return
+	}
+	t.ClippedWh += clippedEnergy(t.samples, t.plateauStart, t.plateauEnd, rating)
+	t.plateauStart, t.plateauEnd, t.after = 0, 0, 0
+}
+
+// clippedEnergy estimates the energy lost to clipping between start and end
+// by fitting a parabola to the unclipped samples around the plateau, which
+// approximates the midday part of a clear-day curve. It returns 0 without
+// unclipped samples on both sides, or if the fit isn't a peak.
+func clippedEnergy(samples []clipSample, start, end int64, rating float64) float64 {
+	from := float64(start) - clippingFitWindow.Seconds()
+	to := float64(end) + clippingFitWindow.Seconds()
+	mid := float64(start+end) / 2
+
+	// Least squares fit of watts = a*x^2 + b*x + c, x in hours from mid
+	var sums [5]float64 // sums of x^0..x^4
+	var rhs [3]float64  // sums of y*x^0..y*x^2
+	var before, after bool
+	for _, s := range samples {
+		if s.t < from || s.t > to {
+			continue
+		}
+		before = before || s.t < float64(start)
+		after = after || s.t > float64(end)
+		x := (s.t - mid) / 3600
+		xp := 1.0
+		for i := range sums {
+			if i < len(rhs) {
+				rhs[i] += s.watts * xp
+			}
+			sums[i] += xp
+			xp *= x
+		}
+	}
+	if !before || !after || sums[0] < 3 {
+		return 0
+	}
+	a, b, c, ok := solve3([3][3]float64{
+		{sums[4], sums[3], sums[2]},
+		{sums[3], sums[2], sums[1]},
+		{sums[2], sums[1], sums[0]},
+	}, [3]float64{rhs[2], rhs[1], rhs[0]})
+	if !ok || a >= 0 {
+		return 0
+	}
+
+	// Integrate the excess over the rating minute by minute
+	var wh float64
+	for t := float64(start); t < float64(end); t += 60 {
+		step := math.Min(60, float64(end)-t)
+		x := (t + step/2 - mid) / 3600
+		if excess := a*x*x + b*x + c - rating; excess > 0 {
+			wh += excess * step / 3600
+		}
+	}
+	return wh
+}
+
+// solve3 solves a 3x3 linear system by Cramer's rule.
+func solve3(m [3][3]float64, v [3]float64) (x, y, z float64, ok bool) {
+	det := func(m [3][3]float64) float64 {
+		return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+			m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+			m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	}
+	d := det(m)
+	if math.Abs(d) < 1e-9 {
+		return 0, 0, 0, false
+	}
+	var result [3]float64
+	for col := range result {
+		mc := m
+		for row := range mc {
+			mc[row][col] = v[row]
+		}
+		result[col] = det(mc) / d
+	}
+	return result[0], result[1], result[2], true
+}
+
+// InverterClippingCollector detects inverters whose output is held at their
+// AC rating, e.g. IQ7s on modules with more DC capacity than they can convert.
+type InverterClippingCollector struct {
+	config ClippingConfig
+
+	trackers map[string]*clippingTracker
+	// current holds the rated inverters in the last observation
+	current map[string]bool
+	mu      sync.Mutex
+
+	ratedWatts       *prometheus.Desc
+	clipping         *prometheus.Desc
+	clippingSeconds  *prometheus.Desc
+	clippedEnergy    *prometheus.Desc
+	clippingFraction *prometheus.Desc
+}
+
+// NewInverterClippingCollector creates an InverterClippingCollector.
+func NewInverterClippingCollector(config ClippingConfig) *InverterClippingCollector {
+	return &InverterClippingCollector{
+		config:   config,
+		trackers: make(map[string]*clippingTracker),
+		current:  make(map[string]bool),
+		ratedWatts: prometheus.NewDesc(
+			"enphase_inverter_rated_watts",
+			"Continuous AC rating of the inverter in watts",
+			config.Labels.names(),
+			nil,
+		),
+		clipping: prometheus.NewDesc(
+			"enphase_inverter_clipping",
+			"Whether the inverter's output has been held at its rating for the minimum duration (1 = clipping)",
+			config.Labels.names(),
+			nil,
+		),
+		clippingSeconds: prometheus.NewDesc(
+			"enphase_inverter_clipping_seconds_total",
+			"Time the inverter's output has been held at its rating",
+			config.Labels.names(),
+			nil,
+		),
+		clippedEnergy: prometheus.NewDesc(
+			"enphase_inverter_clipped_energy_wh_total",
+			"Estimated energy lost to clipping in watt-hours",
+			config.Labels.names(),
+			nil,
+		),
+		clippingFraction: prometheus.NewDesc(
+			"enphase_inverters_clipping_fraction",
+			"Fraction of rated inverters currently clipping",
+			nil,
+			nil,
+		),
+	}
+}
+
+// ObserveInverters implements InverterObserver.
+func (c *InverterClippingCollector) ObserveInverters(now time.Time, inverters []client.Inverter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.current = make(map[string]bool)
+	for _, inv := range inverters {
+		rating := c.config.rating(inv.SerialNumber)
+		if rating <= 0 {
+			continue
+		}
+		c.current[inv.SerialNumber] = true
+		t := c.trackers[inv.SerialNumber]
+		if t == nil {
+			t = &clippingTracker{}
+			c.trackers[inv.SerialNumber] = t
+		}
+		t.update(inv.LastReportDate, float64(inv.LastReportWatts), rating, c.config)
+	}
+	for _, t := range c.trackers {
+		t.expire(now)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *InverterClippingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.ratedWatts
+	ch <- c.clipping
+	ch <- c.clippingSeconds
+	ch <- c.clippedEnergy
+	ch <- c.clippingFraction
+}
+
+// Collect implements prometheus.Collector.
+func (c *InverterClippingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var clipping int
+	for serial, t := range c.trackers {
+		labels := c.config.Labels.values(serial)
+		if t.sustained && c.current[serial] {
+			clipping++
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.ratedWatts,
+			prometheus.GaugeValue,
+			c.config.rating(serial),
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.clipping,
+			prometheus.GaugeValue,
+			boolToFloat(t.sustained),
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.clippingSeconds,
+			prometheus.CounterValue,
+			t.Seconds,
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.clippedEnergy,
+			prometheus.CounterValue,
+			t.ClippedWh,
+			labels...,
+		)
+	}
+
+	if len(c.current) > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			c.clippingFraction,
+			prometheus.GaugeValue,
+			float64(clipping)/float64(len(c.current)),
+		)
+	}
+}
+
+// StateKey implements state.Persister.
+func (c *InverterClippingCollector) StateKey() string {
+	return "inverter_clipping"
+}
+
+// SaveState implements state.Persister. Only the counters are saved; a
+// plateau in progress restarts after a restart.
+func (c *InverterClippingCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(c.trackers)
+}
+
+// RestoreState implements state.Persister.
+func (c *InverterClippingCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var trackers map[string]*clippingTracker
+	if err := json.Unmarshal(data, &trackers); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for serial, t := range trackers {
+		if t != nil {
+			c.trackers[serial] = &clippingTracker{Seconds: t.Seconds, ClippedWh: t.ClippedWh}
+		}
+	}
+	return nil
+}
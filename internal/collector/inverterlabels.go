@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/rhwendt/enphase-exporter/internal/layout"
+)
+
+// unmappedArray is the array label of inverters missing from the layout.
+const unmappedArray = "unmapped"
+
+// InverterLabels adds physical layout labels to per-inverter metrics. The
+// zero value labels inverters by serial number only.
+type InverterLabels struct {
+	Layout *layout.Layout
+	// Position adds string, row and column labels
+	Position bool
+}
+
+// names returns the label names for a per-inverter metric, followed by extra.
+func (l InverterLabels) names(extra ...string) []string {
+	names := []string{"serial_number"}
+	if l.Layout != nil {
+		names = append(names, "array")
+		if l.Position {
+			names = append(names, "string", "row", "column")
+		}
+	}
+	return append(names, extra...)
+}
+
+// values returns the label values for an inverter, matching names.
+func (l InverterLabels) values(serial string, extra ...string) []string {
+	values := []string{serial}
+	if l.Layout != nil {
+		p, ok := l.Layout.Lookup(serial)
+		if !ok {
+			p.Array = unmappedArray
+		}
+		values = append(values, p.Array)
+		if l.Position {
+			values = append(values, p.String, positionLabel(p.Row), positionLabel(p.Column))
+		}
+	}
+	return append(values, extra...)
+}
+
+// array returns the inverter's array, or "" without a layout.
+func (l InverterLabels) array(serial string) string {
+	if l.Layout == nil {
+		return ""
+	}
+	if p, ok := l.Layout.Lookup(serial); ok {
+		return p.Array
+	}
+	return unmappedArray
+}
+
+// positionLabel formats a row or column, leaving unset positions empty.
+func positionLabel(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
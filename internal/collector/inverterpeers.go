@@ -0,0 +1,267 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
+)
+
+// defaultPeerGroup is the peer group of inverters not assigned to one.
+const defaultPeerGroup = "all"
+
+// DaylightFunc reports whether the sun is up at t. Checks that expect
+// production use it to stay quiet at night.
+type DaylightFunc func(t time.Time) bool
+
+// PeerConfig configures peer-based underperformance detection.
+type PeerConfig struct {
+	// Window is how far back samples are compared
+	Window time.Duration
+	// Threshold is the relative performance below which an inverter is
+	// flagged, e.g. 0.8 for 20% below its peers' median
+	Threshold float64
+	// MinMedianWatts skips scrapes where the peer median is below this, so
+	// dawn, dusk and heavy overcast don't produce noisy ratios
+	MinMedianWatts float64
+	// MinSamples is the number of gated samples needed before an inverter
+	// is rated
+	MinSamples int
+	// Groups maps serial numbers to peer groups, e.g. arrays facing
+	// different directions. Unlisted inverters are compared within their
+	// layout array, or with each other without a layout.
+	Groups map[string]string
+	// Labels adds layout labels to the per-inverter metrics
+	Labels InverterLabels
+}
+
+// Validate checks the peer configuration.
+func (c PeerConfig) Validate() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("window must be positive, got %s", c.Window)
+	}
+	if c.Threshold <= 0 || c.Threshold >= 1 {
+		return fmt.Errorf("threshold must be between 0 and 1, got %v", c.Threshold)
+	}
+	if c.MinMedianWatts < 0 {
+		return fmt.Errorf("minimum median watts must not be negative, got %v", c.MinMedianWatts)
+	}
+	if c.MinSamples < 1 {
+		return fmt.Errorf("minimum samples must be at least 1, got %d", c.MinSamples)
+	}
+	return nil
+}
+
+// peerSample is one inverter's output alongside its peer group's median.
+type peerSample struct {
+	t       time.Time
+	watts   float64
+	medianW float64
+}
+
+// InverterPeersCollector compares each inverter's output with the median of
+// its peer group over a rolling window, to spot a bad panel among many.
+type InverterPeersCollector struct {
+	config   PeerConfig
+	daylight DaylightFunc
+
+	samples map[string][]peerSample
+	groupOf map[string]string
+	medians map[string]peerSample
+	// lastReport is each inverter's last sampled report date
+	lastReport map[string]int64
+	mu         sync.Mutex
+
+	relativePerformance *prometheus.Desc
+	underperforming     *prometheus.Desc
+	peerMedianWatts     *prometheus.Desc
+}
+
+// NewInverterPeersCollector creates an InverterPeersCollector. Samples are
+// only taken while daylight reports the sun is up; a nil daylight relies on
+// MinMedianWatts alone.
+func NewInverterPeersCollector(config PeerConfig, daylight DaylightFunc) *InverterPeersCollector {
+	return &InverterPeersCollector{
+		config:     config,
+		daylight:   daylight,
+		samples:    make(map[string][]peerSample),
+		groupOf:    make(map[string]string),
+		medians:    make(map[string]peerSample),
+		lastReport: make(map[string]int64),
+		relativePerformance: prometheus.NewDesc(
+			"enphase_inverter_relative_performance",
+			"Inverter energy relative to its peer group's median over the rolling window (1 = typical)",
+			config.Labels.names("peer_group"),
+			nil,
+		),
+		underperforming: prometheus.NewDesc(
+			"enphase_inverter_underperforming",
+			"Whether the inverter's relative performance is below the threshold (1 = underperforming)",
+			config.Labels.names("peer_group"),
+			nil,
+		),
+		peerMedianWatts: prometheus.NewDesc(
+			"enphase_inverter_peer_median_watts",
+			"Median inverter output in the peer group at the last gated sample",
+			[]string{"peer_group"},
+			nil,
+		),
+	}
+}
+
+// median returns the median of values, which it sorts in place.
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
+
+// peerGroup returns the peer group of an inverter: its configured group, its
+// layout array, or defaultPeerGroup.
+func peerGroup(groups map[string]string, labels InverterLabels, serial string) string {
+	if g, ok := groups[serial]; ok && g != "" {
+		return g
+	}
+	if labels.Layout != nil {
+		return labels.array(serial)
+	}
+	return defaultPeerGroup
+}
+
+// ObserveInverters implements InverterObserver.
+func (c *InverterPeersCollector) ObserveInverters(t time.Time, inverters []client.Inverter) {
+	if c.daylight != nil && !c.daylight(t) {
+		return
+	}
+
+	byGroup := make(map[string][]client.Inverter)
+	for _, inv := range inverters {
+		g := peerGroup(c.config.Groups, c.config.Labels, inv.SerialNumber)
+		byGroup[g] = append(byGroup[g], inv)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Only new reports are sampled, so scraping faster than inverters
+	// report doesn't weight their readings by the scrape interval
+	fresh := make(map[string]bool)
+	for _, inv := range inverters {
+		if inv.LastReportDate > c.lastReport[inv.SerialNumber] {
+			c.lastReport[inv.SerialNumber] = inv.LastReportDate
+			fresh[inv.SerialNumber] = true
+		}
+	}
+
+	for g, group := range byGroup {
+		// A median of one inverter is itself
+		if len(group) < 2 {
+			continue
+		}
+		watts := make([]float64, len(group))
+		for i, inv := range group {
+			watts[i] = float64(inv.LastReportWatts)
+		}
+		medianW := median(watts)
+		if medianW < c.config.MinMedianWatts || medianW <= 0 {
+			continue
+		}
+		c.medians[g] = peerSample{t: t, medianW: medianW}
+
+		for _, inv := range group {
+			if !fresh[inv.SerialNumber] {
+				continue
+			}
+			c.groupOf[inv.SerialNumber] = g
+			c.samples[inv.SerialNumber] = append(c.samples[inv.SerialNumber], peerSample{
+				t:       t,
+				watts:   float64(inv.LastReportWatts),
+				medianW: medianW,
+			})
+		}
+	}
+	c.prune(t)
+}
+
+// prune drops samples older than the window, and inverters and groups with
+// none left. Must be called with mu held.
+func (c *InverterPeersCollector) prune(now time.Time) {
+	cutoff := now.Add(-c.config.Window)
+	for g, m := range c.medians {
+		if m.t.Before(cutoff) {
+			delete(c.medians, g)
+		}
+	}
+	for serial, samples := range c.samples {
+		i := 0
+		for i < len(samples) && samples[i].t.Before(cutoff) {
+			i++
+		}
+		if i == len(samples) {
+			delete(c.samples, serial)
+			delete(c.groupOf, serial)
+			continue
+		}
+		c.samples[serial] = samples[i:]
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *InverterPeersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.relativePerformance
+	ch <- c.underperforming
+	ch <- c.peerMedianWatts
+}
+
+// Collect implements prometheus.Collector.
+func (c *InverterPeersCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(time.Now())
+
+	for serial, samples := range c.samples {
+		if len(samples) < c.config.MinSamples {
+			continue
+		}
+		// Ratio of sums weights bright samples more than dim ones
+		var watts, medianW float64
+		for _, s := range samples {
+			watts += s.watts
+			medianW += s.medianW
+		}
+		ratio := watts / medianW
+		labels := c.config.Labels.values(serial, c.groupOf[serial])
+
+		ch <- prometheus.MustNewConstMetric(
+			c.relativePerformance,
+			prometheus.GaugeValue,
+			ratio,
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.underperforming,
+			prometheus.GaugeValue,
+			boolToFloat(ratio < c.config.Threshold),
+			labels...,
+		)
+	}
+
+	for g, m := range c.medians {
+		ch <- prometheus.MustNewConstMetric(
+			c.peerMedianWatts,
+			prometheus.GaugeValue,
+			m.medianW,
+			g,
+		)
+	}
+}
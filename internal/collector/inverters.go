@@ -1,53 +1,330 @@
 package collector
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
 )
 
 var invertersLog = logrus.WithField("collector", "inverters")
 
+// maxReportGap is the longest gap between an inverter's reports that is
+// integrated into energy. Inverters report every 5-15 minutes while
+// producing; longer gaps span the night or an outage.
+const maxReportGap = 20 * time.Minute
+
+// Actions for inverters whose last report is older than StaleAfter.
+const (
+	StaleZero = "zero"
+	StaleDrop = "drop"
+)
+
+// InvertersConfig configures the InvertersCollector.
+type InvertersConfig struct {
+	Labels InverterLabels
+	// Expected lists the serial numbers that should report. If empty, every
+	// inverter in the layout or seen since the state file was created is
+	// expected.
+	Expected []string
+	// ForgetAfter is how long a learned inverter that isn't in the layout
+	// may be absent from the gateway's list before it is no longer
+	// expected, e.g. after a replacement; zero keeps it forever
+	ForgetAfter time.Duration
+	// StaleAfter is the report age after which an inverter's watts are
+	// zeroed or dropped according to StaleAction; zero disables it
+	StaleAfter  time.Duration
+	StaleAction string
+	// StallAfter is how long no inverter may have a new report, while
+	// Daylight reports the sun is up, before the gateway's inverter polling
+	// is considered stalled. It needs Daylight, since inverters stop
+	// reporting every night; zero or a nil Daylight disables it
+	StallAfter time.Duration
+	Daylight   DaylightFunc
+	// Location is where daily inverter energy resets at midnight; nil uses
+	// the local timezone
+	Location *time.Location
+}
+
+// Validate checks the inverters configuration.
+func (c InvertersConfig) Validate() error {
+	if c.StaleAfter < 0 {
+		return fmt.Errorf("stale threshold must not be negative, got %s", c.StaleAfter)
+	}
+	if c.StaleAction != StaleZero && c.StaleAction != StaleDrop {
+		return fmt.Errorf("stale action must be %q or %q, got %q", StaleZero, StaleDrop, c.StaleAction)
+	}
+	if c.StallAfter < 0 {
+		return fmt.Errorf("stall threshold must not be negative, got %s", c.StallAfter)
+	}
+	if c.ForgetAfter < 0 {
+		return fmt.Errorf("forget threshold must not be negative, got %s", c.ForgetAfter)
+	}
+	return nil
+}
+
 // InvertersCollector collects per-inverter metrics from the Enphase gateway.
 type InvertersCollector struct {
-	client EnphaseClient
+	client      EnphaseClient
+	labels      InverterLabels
+	staleAfter  time.Duration
+	staleAction string
+	stallAfter  time.Duration
+	daylight    DaylightFunc
+	loc         *time.Location
+
+	observers []InverterObserver
+	energy    map[string]*inverterEnergy
+	warned    map[string]bool
+	expected  map[string]bool
+	learn     bool
+	// seen is when each learned inverter was last in the gateway's list
+	seen        map[string]time.Time
+	forgetAfter time.Duration
+	mu          sync.Mutex
 
 	inverterWatts    *prometheus.Desc
 	inverterMaxWatts *prometheus.Desc
 	inverterLastReport *prometheus.Desc
+	layoutInfo       *prometheus.Desc
+	arrayWatts       *prometheus.Desc
+	arrayEnergy      *prometheus.Desc
+	arrayInverters   *prometheus.Desc
+	fleetWatts       *prometheus.Desc
+	reporting        *prometheus.Desc
+	expectedCount    *prometheus.Desc
+	inverterMissing  *prometheus.Desc
+	reportAge        *prometheus.Desc
+	newestReportAge  *prometheus.Desc
+	pollStalled      *prometheus.Desc
+	inverterEnergyWh *prometheus.Desc
+	inverterTodayWh  *prometheus.Desc
+	fleetEnergyWh    *prometheus.Desc
+}
+
+// invertersState is the persisted state of the InvertersCollector.
+type invertersState struct {
+	Expected []string                   `json:"expected,omitempty"`
+	Seen     map[string]time.Time       `json:"seen,omitempty"`
+	Energy   map[string]*inverterEnergy `json:"energy"`
+}
+
+// inverterEnergy integrates an inverter's reported power between reports.
+type inverterEnergy struct {
+	LastReport int64     `json:"last_report"`
+	Wh         float64   `json:"wh"`
+	DayStart   time.Time `json:"day_start"`
+	DayWh      float64   `json:"day_wh"`
+}
+
+// update records a report and adds the energy since the previous one to the
+// lifetime and daily totals. The reported watts are the inverter's average
+// since its previous report, so energy is credited to the day of the report
+// that ends the interval.
+func (e *inverterEnergy) update(date int64, watts float64, loc *time.Location) {
+	if date <= e.LastReport {
+		return
+	}
+	var wh float64
+	if e.LastReport != 0 {
+		if gap := time.Duration(date-e.LastReport) * time.Second; gap <= maxReportGap {
+			wh = watts * gap.Hours()
+		}
+	}
+	if day := periodStart(periodDay, time.Unix(date, 0), loc); !day.Equal(e.DayStart) {
+		e.DayStart = day
+		e.DayWh = 0
+	}
+	e.Wh += wh
+	e.DayWh += wh
+	e.LastReport = date
+}
+
+// today returns the energy reported so far on the day containing now.
+func (e *inverterEnergy) today(now time.Time, loc *time.Location) float64 {
+	if !e.DayStart.Equal(periodStart(periodDay, now, loc)) {
+		return 0
+	}
+	return e.DayWh
+}
+
+// InverterObserver receives the inverter list after each successful inverters
+// scrape.
+type InverterObserver interface {
+	ObserveInverters(t time.Time, inverters []client.Inverter)
 }
 
 // NewInvertersCollector creates a new InvertersCollector.
-func NewInvertersCollector(client EnphaseClient) *InvertersCollector {
+func NewInvertersCollector(client EnphaseClient, config InvertersConfig) *InvertersCollector {
+	labels := config.Labels
+	expected := make(map[string]bool)
+	for _, serial := range config.Expected {
+		expected[serial] = true
+	}
+	learn := len(expected) == 0
+	loc := config.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	if learn && labels.Layout != nil {
+		for _, serial := range labels.Layout.Serials() {
+			expected[serial] = true
+		}
+	}
 	return &InvertersCollector{
-		client: client,
+		client:      client,
+		labels:      labels,
+		staleAfter:  config.StaleAfter,
+		staleAction: config.StaleAction,
+		stallAfter:  config.StallAfter,
+		daylight:    config.Daylight,
+		loc:         loc,
+		energy:      make(map[string]*inverterEnergy),
+		warned:      make(map[string]bool),
+		expected:    expected,
+		learn:       learn,
+		seen:        make(map[string]time.Time),
+		forgetAfter: config.ForgetAfter,
 		inverterWatts: prometheus.NewDesc(
 			"enphase_inverter_watts",
 			"Current inverter production in watts",
-			[]string{"serial_number"},
+			labels.names(),
 			nil,
 		),
 		inverterMaxWatts: prometheus.NewDesc(
 			"enphase_inverter_max_watts",
 			"Maximum reported inverter production in watts",
-			[]string{"serial_number"},
+			labels.names(),
 			nil,
 		),
 		inverterLastReport: prometheus.NewDesc(
 			"enphase_inverter_last_report_timestamp",
 			"Unix timestamp of last inverter report",
-			[]string{"serial_number"},
+			labels.names(),
+			nil,
+		),
+		layoutInfo: prometheus.NewDesc(
+			"enphase_inverter_layout_info",
+			"Physical placement of a mapped inverter (always 1)",
+			[]string{"serial_number", "array", "roof_face", "azimuth", "tilt", "string", "row", "column"},
+			nil,
+		),
+		arrayWatts: prometheus.NewDesc(
+			"enphase_array_watts",
+			"Sum of the latest inverter reports in the array in watts",
+			[]string{"array"},
+			nil,
+		),
+		arrayEnergy: prometheus.NewDesc(
+			"enphase_array_energy_wh_total",
+			"Array energy integrated from inverter reports in watt-hours",
+			[]string{"array"},
+			nil,
+		),
+		arrayInverters: prometheus.NewDesc(
+			"enphase_array_inverters",
+			"Number of inverters reporting in the array",
+			[]string{"array"},
+			nil,
+		),
+		fleetWatts: prometheus.NewDesc(
+			"enphase_inverters_watts",
+			"Aggregate of the latest inverter reports in watts",
+			[]string{"stat"},
+			nil,
+		),
+		reporting: prometheus.NewDesc(
+			"enphase_inverters_reporting",
+			"Number of inverters returned by the gateway whose report isn't stale",
+			nil,
+			nil,
+		),
+		expectedCount: prometheus.NewDesc(
+			"enphase_inverters_expected",
+			"Number of inverters expected to report",
+			nil,
+			nil,
+		),
+		inverterMissing: prometheus.NewDesc(
+			"enphase_inverter_missing",
+			"Whether an expected inverter is missing from the gateway's inverter list (1 = missing)",
+			labels.names(),
+			nil,
+		),
+		reportAge: prometheus.NewDesc(
+			"enphase_inverter_report_age_seconds",
+			"Seconds since the inverter's last report",
+			labels.names(),
+			nil,
+		),
+		newestReportAge: prometheus.NewDesc(
+			"enphase_inverters_newest_report_age_seconds",
+			"Seconds since the most recent report from any inverter",
+			nil,
+			nil,
+		),
+		pollStalled: prometheus.NewDesc(
+			"enphase_inverters_poll_stalled",
+			"Whether no inverter has reported for the stall threshold while the sun is up (1 = stalled)",
+			nil,
+			nil,
+		),
+		inverterEnergyWh: prometheus.NewDesc(
+			"enphase_inverter_energy_wh_total",
+			"Inverter energy integrated from its reports in watt-hours",
+			labels.names(),
+			nil,
+		),
+		inverterTodayWh: prometheus.NewDesc(
+			"enphase_inverter_energy_today_wh",
+			"Inverter energy integrated from its reports since local midnight in watt-hours",
+			labels.names(),
+			nil,
+		),
+		fleetEnergyWh: prometheus.NewDesc(
+			"enphase_inverters_energy_wh_total",
+			"Energy integrated from all inverter reports in watt-hours",
+			nil,
 			nil,
 		),
 	}
 }
 
+// AddObserver registers an observer for inverter reports. Observers are
+// called synchronously from Collect and must not block.
+func (c *InvertersCollector) AddObserver(o InverterObserver) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observers = append(c.observers, o)
+}
+
 // Describe implements prometheus.Collector.
 func (c *InvertersCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.inverterWatts
 	ch <- c.inverterMaxWatts
 	ch <- c.inverterLastReport
+	ch <- c.layoutInfo
+	ch <- c.arrayWatts
+	ch <- c.arrayEnergy
+	ch <- c.arrayInverters
+	ch <- c.fleetWatts
+	ch <- c.reporting
+	ch <- c.expectedCount
+	ch <- c.inverterMissing
+	ch <- c.reportAge
+	ch <- c.newestReportAge
+	ch <- c.pollStalled
+	ch <- c.inverterEnergyWh
+	ch <- c.inverterTodayWh
+	ch <- c.fleetEnergyWh
 }
 
 // Collect implements prometheus.Collector.
@@ -66,24 +343,379 @@ func (c *InvertersCollector) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
-	for _, inv := range *inverters {
+	// Watts see stale reports zeroed or dropped; report ages, energy and
+	// completeness use the gateway's list as-is. Observers always see stale
+	// reports zeroed, so they can tell a silent inverter from a missing one.
+	current := c.current(start, *inverters, c.staleAction)
+
+	c.mu.Lock()
+	observers := c.observers
+	c.mu.Unlock()
+	if len(observers) > 0 {
+		observed := c.current(start, *inverters, StaleZero)
+		for _, o := range observers {
+			o.ObserveInverters(start, observed)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, inv := range current {
 		ch <- prometheus.MustNewConstMetric(
 			c.inverterWatts,
 			prometheus.GaugeValue,
 			float64(inv.LastReportWatts),
-			inv.SerialNumber,
+			c.labels.values(inv.SerialNumber)...,
 		)
+	}
+
+	var newest int64
+	for _, inv := range *inverters {
+		e := c.energy[inv.SerialNumber]
+		if e == nil {
+			e = &inverterEnergy{}
+			c.energy[inv.SerialNumber] = e
+		}
+		e.update(inv.LastReportDate, float64(inv.LastReportWatts), c.loc)
+		newest = max(newest, inv.LastReportDate)
+
+		labels := c.labels.values(inv.SerialNumber)
+		if inv.LastReportDate > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				c.reportAge,
+				prometheus.GaugeValue,
+				reportAge(start, inv.LastReportDate).Seconds(),
+				labels...,
+			)
+		}
 		ch <- prometheus.MustNewConstMetric(
 			c.inverterMaxWatts,
 			prometheus.GaugeValue,
 			float64(inv.MaxReportWatts),
-			inv.SerialNumber,
+			labels...,
 		)
 		ch <- prometheus.MustNewConstMetric(
 			c.inverterLastReport,
 			prometheus.GaugeValue,
 			float64(inv.LastReportDate),
-			inv.SerialNumber,
+			labels...,
+		)
+	}
+
+	if newest > 0 {
+		age := reportAge(start, newest)
+		ch <- prometheus.MustNewConstMetric(
+			c.newestReportAge,
+			prometheus.GaugeValue,
+			age.Seconds(),
 		)
+		if c.stallAfter > 0 && c.daylight != nil {
+			ch <- prometheus.MustNewConstMetric(
+				c.pollStalled,
+				prometheus.GaugeValue,
+				boolToFloat(age > c.stallAfter && c.expectReports(start)),
+			)
+		}
+	}
+
+	c.collectEnergy(ch, start)
+	c.collectFleet(ch, start, *inverters, current)
+	if c.labels.Layout != nil {
+		c.checkLayout(*inverters)
+		c.collectArrays(ch, current)
+	}
+}
+
+// collectEnergy exports per-inverter and fleet energy, including inverters
+// that have since stopped reporting so the counters never go backwards. Must
+// be called with mu held.
+func (c *InvertersCollector) collectEnergy(ch chan<- prometheus.Metric, now time.Time) {
+	var total float64
+	for serial, e := range c.energy {
+		total += e.Wh
+		labels := c.labels.values(serial)
+		ch <- prometheus.MustNewConstMetric(
+			c.inverterEnergyWh,
+			prometheus.CounterValue,
+			e.Wh,
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.inverterTodayWh,
+			prometheus.GaugeValue,
+			e.today(now, c.loc),
+			labels...,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.fleetEnergyWh,
+		prometheus.CounterValue,
+		total,
+	)
+}
+
+// reportAge returns the time since a report, clamped at zero for gateway
+// clocks running ahead of ours.
+func reportAge(now time.Time, reportDate int64) time.Duration {
+	return max(now.Sub(time.Unix(reportDate, 0)), 0)
+}
+
+// stale reports whether an inverter's last report is older than StaleAfter.
+func (c *InvertersCollector) stale(now time.Time, inv client.Inverter) bool {
+	return c.staleAfter > 0 && reportAge(now, inv.LastReportDate) > c.staleAfter
+}
+
+// current returns inverters with the given stale action applied.
+func (c *InvertersCollector) current(now time.Time, inverters []client.Inverter, action string) []client.Inverter {
+	if c.staleAfter <= 0 {
+		return inverters
+	}
+	current := make([]client.Inverter, 0, len(inverters))
+	for _, inv := range inverters {
+		if c.stale(now, inv) {
+			if action == StaleDrop {
+				continue
+			}
+			inv.LastReportWatts = 0
+		}
+		current = append(current, inv)
+	}
+	return current
+}
+
+// expectReports reports whether inverters should have been reporting for
+// the whole stall window. Must only be called with a daylight function.
+func (c *InvertersCollector) expectReports(now time.Time) bool {
+	// Inverters wake some time after sunrise, so require the sun to have
+	// been up for the whole window
+	return c.daylight(now) && c.daylight(now.Add(-c.stallAfter))
+}
+
+// collectFleet exports aggregates across current inverters and compares the
+// inverters reporting with those expected. Must be called with mu held.
+func (c *InvertersCollector) collectFleet(ch chan<- prometheus.Metric, now time.Time, inverters, current []client.Inverter) {
+	reporting := make(map[string]bool, len(inverters))
+	var fresh int
+	for _, inv := range inverters {
+		reporting[inv.SerialNumber] = true
+		if !c.stale(now, inv) {
+			fresh++
+		}
+		if c.learn {
+			c.expected[inv.SerialNumber] = true
+			c.seen[inv.SerialNumber] = now
+		}
+	}
+	if c.learn && c.forgetAfter > 0 {
+		c.forget(now)
+	}
+	watts := make([]float64, 0, len(current))
+	for _, inv := range current {
+		watts = append(watts, float64(inv.LastReportWatts))
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.reporting,
+		prometheus.GaugeValue,
+		float64(fresh),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.expectedCount,
+		prometheus.GaugeValue,
+		float64(len(c.expected)),
+	)
+	for serial := range c.expected {
+		ch <- prometheus.MustNewConstMetric(
+			c.inverterMissing,
+			prometheus.GaugeValue,
+			boolToFloat(!reporting[serial]),
+			c.labels.values(serial)...,
+		)
+	}
+
+	if len(watts) == 0 {
+		return
+	}
+	var sum float64
+	for _, w := range watts {
+		sum += w
+	}
+	mean := sum / float64(len(watts))
+	var variance float64
+	for _, w := range watts {
+		variance += (w - mean) * (w - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(watts)))
+	// median sorts watts, so min and max follow from it
+	medianW := median(watts)
+
+	for _, stat := range []struct {
+		name  string
+		value float64
+	}{
+		{"sum", sum},
+		{"min", watts[0]},
+		{"max", watts[len(watts)-1]},
+		{"median", medianW},
+		{"stddev", stddev},
+	} {
+		ch <- prometheus.MustNewConstMetric(
+			c.fleetWatts,
+			prometheus.GaugeValue,
+			stat.value,
+			stat.name,
+		)
+	}
+}
+
+// checkLayout warns once about each inverter that reports but isn't in the
+// layout, and each mapped inverter that doesn't report. Must be called with
+// mu held.
+func (c *InvertersCollector) checkLayout(inverters []client.Inverter) {
+	serials := make([]string, len(inverters))
+	for i, inv := range inverters {
+		serials[i] = inv.SerialNumber
+	}
+	unmapped, missing := c.labels.Layout.Check(serials)
+	for _, serial := range unmapped {
+		if !c.warned[serial] {
+			c.warned[serial] = true
+			invertersLog.WithField("serial_number", serial).Warn("Inverter is not in the layout, labelling it array=unmapped")
+		}
+	}
+	for _, serial := range missing {
+		if !c.warned[serial] {
+			c.warned[serial] = true
+			invertersLog.WithField("serial_number", serial).Warn("Inverter in the layout is not reported by the gateway")
+		}
+	}
+}
+
+// collectArrays exports layout info for every mapped inverter, so placement
+// is known for missing ones too, and per-array aggregates of current ones.
+// Must be called with mu held.
+func (c *InvertersCollector) collectArrays(ch chan<- prometheus.Metric, current []client.Inverter) {
+	for _, serial := range c.labels.Layout.Serials() {
+		p, _ := c.labels.Layout.Lookup(serial)
+		ch <- prometheus.MustNewConstMetric(
+			c.layoutInfo,
+			prometheus.GaugeValue,
+			1,
+			p.SerialNumber, p.Array, p.RoofFace,
+			strconv.FormatFloat(p.Azimuth, 'f', -1, 64), strconv.FormatFloat(p.Tilt, 'f', -1, 64),
+			p.String, positionLabel(p.Row), positionLabel(p.Column),
+		)
+	}
+
+	watts := make(map[string]float64)
+	reporting := make(map[string]int)
+	for _, inv := range current {
+		array := c.labels.array(inv.SerialNumber)
+		watts[array] += float64(inv.LastReportWatts)
+		reporting[array]++
+	}
+
+	// Energy includes inverters that have since stopped reporting, so the
+	// counter never goes backwards
+	energy := make(map[string]float64)
+	for serial, e := range c.energy {
+		energy[c.labels.array(serial)] += e.Wh
+	}
+
+	for array, wh := range energy {
+		ch <- prometheus.MustNewConstMetric(
+			c.arrayWatts,
+			prometheus.GaugeValue,
+			watts[array],
+			array,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.arrayEnergy,
+			prometheus.CounterValue,
+			wh,
+			array,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.arrayInverters,
+			prometheus.GaugeValue,
+			float64(reporting[array]),
+			array,
+		)
+	}
+}
+
+// forget drops learned inverters that haven't been in the gateway's list for
+// forgetAfter. Inverters in the layout are always expected. Must be called
+// with mu held.
+func (c *InvertersCollector) forget(now time.Time) {
+	for serial := range c.expected {
+		if c.labels.Layout != nil {
+			if _, ok := c.labels.Layout.Lookup(serial); ok {
+				continue
+			}
+		}
+		seen, ok := c.seen[serial]
+		if !ok {
+			// Learned before last-seen times were kept
+			c.seen[serial] = now
+			continue
+		}
+		if now.Sub(seen) > c.forgetAfter {
+			invertersLog.WithFields(logrus.Fields{
+				"serial_number": serial,
+				"last_seen":     seen,
+			}).Info("Inverter no longer reported by the gateway, removing it from the expected inventory")
+			delete(c.expected, serial)
+			delete(c.seen, serial)
+		}
+	}
+}
+
+// StateKey implements state.Persister.
+func (c *InvertersCollector) StateKey() string {
+	return "inverters"
+}
+
+// SaveState implements state.Persister. Only a learned inventory is saved; a
+// configured one comes from the config each start.
+func (c *InvertersCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := invertersState{Energy: c.energy}
+	if c.learn {
+		for serial := range c.expected {
+			st.Expected = append(st.Expected, serial)
+		}
+		sort.Strings(st.Expected)
+		st.Seen = c.seen
+	}
+	return json.Marshal(st)
+}
+
+// RestoreState implements state.Persister. Each inverter's last report time
+// is restored too, so power isn't integrated across downtime longer than
+// maxReportGap.
+func (c *InvertersCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var st invertersState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.learn {
+		for _, serial := range st.Expected {
+			c.expected[serial] = true
+			if seen, ok := st.Seen[serial]; ok {
+				c.seen[serial] = seen
+			}
+		}
+	}
+	for serial, e := range st.Energy {
+		if e != nil {
+			c.energy[serial] = e
+		}
 	}
+	return nil
 }
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
+)
+
+var liveDataLog = logrus.WithField("collector", "livedata")
+
+// Grid modes derived from the main relay state.
+const (
+	gridModeOnGrid  = "on-grid"
+	gridModeOffGrid = "off-grid"
+)
+
+// LiveDataCollector collects a consistent power snapshot from /ivp/livedata/status.
+type LiveDataCollector struct {
+	client EnphaseClient
+
+	powerWatts        *prometheus.Desc
+	apparentPowerVA   *prometheus.Desc
+	gridMode          *prometheus.Desc
+	backupBatteryMode *prometheus.Desc
+	batterySoc        *prometheus.Desc
+	lastUpdate        *prometheus.Desc
+}
+
+// NewLiveDataCollector creates a new LiveDataCollector.
+func NewLiveDataCollector(client EnphaseClient) *LiveDataCollector {
+	return &LiveDataCollector{
+		client: client,
+		powerWatts: prometheus.NewDesc(
+			"enphase_livedata_power_watts",
+			"Active power by source from live data in watts",
+			[]string{"source"},
+			nil,
+		),
+		apparentPowerVA: prometheus.NewDesc(
+			"enphase_livedata_apparent_power_va",
+			"Apparent power by source from live data in volt-amperes",
+			[]string{"source"},
+			nil,
+		),
+		gridMode: prometheus.NewDesc(
+			"enphase_livedata_grid_mode",
+			"Current grid mode from the main relay state (1 for the active mode)",
+			[]string{"mode"},
+			nil,
+		),
+		backupBatteryMode: prometheus.NewDesc(
+			"enphase_livedata_backup_battery_mode",
+			"Backup battery mode as reported by the gateway",
+			nil,
+			nil,
+		),
+		batterySoc: prometheus.NewDesc(
+			"enphase_livedata_battery_soc_percent",
+			"Aggregate battery state of charge in percent",
+			nil,
+			nil,
+		),
+		lastUpdate: prometheus.NewDesc(
+			"enphase_livedata_last_update_timestamp",
+			"Unix timestamp of the live data snapshot",
+			nil,
+			nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *LiveDataCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.powerWatts
+	ch <- c.apparentPowerVA
+	ch <- c.gridMode
+	ch <- c.backupBatteryMode
+	ch <- c.batterySoc
+	ch <- c.lastUpdate
+}
+
+// Collect implements prometheus.Collector.
+func (c *LiveDataCollector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	live, err := c.client.GetLiveData()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("livedata").Observe(duration.Seconds())
+	liveDataLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetLiveData completed")
+	if err != nil {
+		liveDataLog.WithError(err).Error("Failed to get live data")
+		return
+	}
+
+	if live == nil {
+		return
+	}
+
+	meters := live.Meters
+	sources := []struct {
+		name string
+		data client.LiveDataSource
+	}{
+		{"pv", meters.PV},
+		{"grid", meters.Grid},
+		{"load", meters.Load},
+		{"storage", meters.Storage},
+		{"generator", meters.Generator},
+	}
+	for _, src := range sources {
+		// Live data reports milliwatts; convert to watts
+		ch <- prometheus.MustNewConstMetric(
+			c.powerWatts,
+			prometheus.GaugeValue,
+			src.data.AggPMw/1000,
+			src.name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.apparentPowerVA,
+			prometheus.GaugeValue,
+			src.data.AggSMva/1000,
+			src.name,
+		)
+	}
+
+	onGrid := boolToFloat(meters.MainRelayState == 1)
+	ch <- prometheus.MustNewConstMetric(
+		c.gridMode,
+		prometheus.GaugeValue,
+		onGrid,
+		gridModeOnGrid,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.gridMode,
+		prometheus.GaugeValue,
+		1-onGrid,
+		gridModeOffGrid,
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.backupBatteryMode,
+		prometheus.GaugeValue,
+		float64(meters.BackupBatMode),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.batterySoc,
+		prometheus.GaugeValue,
+		meters.Soc,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.lastUpdate,
+		prometheus.GaugeValue,
+		float64(meters.LastUpdate),
+	)
+}
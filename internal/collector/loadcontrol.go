@@ -0,0 +1,292 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
+)
+
+var loadControlLog = logrus.WithField("collector", "loadcontrol")
+
+const loadControlSettingsRefreshInterval = 15 * time.Minute
+
+// loadControlSettingsRetryInterval is how often relay settings are retried
+// while they have never been fetched successfully.
+const loadControlSettingsRetryInterval = time.Minute
+
+// Normalized load control relay modes.
+const (
+	relayModeSoc      = "soc"
+	relayModeSchedule = "schedule"
+	relayModeGrid     = "grid"
+	relayModeUnknown  = "unknown"
+)
+
+// generatorMeasurementType is the /ivp/meters measurement type of a generator meter.
+const generatorMeasurementType = "generator"
+
+// LoadControlCollector collects IQ System Controller load control relay and generator metrics.
+type LoadControlCollector struct {
+	client EnphaseClient
+
+	// Cached relay settings and generator meter, refreshed periodically
+	settings       map[string]client.DryContactSettings
+	generatorMeter int64
+	lastRefresh    time.Time
+	settingsWarned bool
+	mu             sync.RWMutex
+
+	relayClosed      *prometheus.Desc
+	relayMode        *prometheus.Desc
+	generatorPresent *prometheus.Desc
+	generatorRunning *prometheus.Desc
+	generatorWatts   *prometheus.Desc
+	generatorWhTotal *prometheus.Desc
+}
+
+// NewLoadControlCollector creates a new LoadControlCollector.
+func NewLoadControlCollector(client EnphaseClient) *LoadControlCollector {
+	return &LoadControlCollector{
+		client: client,
+		relayClosed: prometheus.NewDesc(
+			"enphase_load_control_relay_closed",
+			"Load control relay state (1 = closed, 0 = open)",
+			[]string{"relay_id", "load_name"},
+			nil,
+		),
+		relayMode: prometheus.NewDesc(
+			"enphase_load_control_relay_mode",
+			"Load control relay mode (1 for the configured mode)",
+			[]string{"relay_id", "load_name", "mode"},
+			nil,
+		),
+		generatorPresent: prometheus.NewDesc(
+			"enphase_generator_present",
+			"Whether a generator is configured on the system controller",
+			nil,
+			nil,
+		),
+		generatorRunning: prometheus.NewDesc(
+			"enphase_generator_running",
+			"Generator running state (1 = running)",
+			nil,
+			nil,
+		),
+		generatorWatts: prometheus.NewDesc(
+			"enphase_generator_watts",
+			"Generator output power in watts (requires a generator meter)",
+			nil,
+			nil,
+		),
+		generatorWhTotal: prometheus.NewDesc(
+			"enphase_generator_wh_total",
+			"Lifetime generator energy in watt-hours (requires a generator meter)",
+			nil,
+			nil,
+		),
+	}
+}
+
+// refreshSettings reloads relay configuration and discovers a generator meter.
+func (c *LoadControlCollector) refreshSettings() {
+	settings, err := c.client.GetDryContactSettings()
+	if err != nil {
+		// Back off until the next retry and only warn once per outage
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.lastRefresh = time.Now()
+		if c.settingsWarned {
+			loadControlLog.WithError(err).Debug("Failed to fetch load control settings")
+		} else {
+			loadControlLog.WithError(err).Warn("Failed to fetch load control settings, relay modes may be unknown")
+			c.settingsWarned = true
+		}
+		return
+	}
+
+	// Keep the previous generator meter if metadata can't be fetched
+	c.mu.RLock()
+	generatorMeter := c.generatorMeter
+	c.mu.RUnlock()
+	meters, err := c.client.GetMeters()
+	if err != nil {
+		loadControlLog.WithError(err).Warn("Failed to fetch meter metadata for generator discovery")
+	} else if meters != nil {
+		generatorMeter = 0
+		for _, m := range *meters {
+			if m.MeasurementType == generatorMeasurementType && m.State == "enabled" {
+				generatorMeter = m.Eid
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.settingsWarned {
+		loadControlLog.Info("Load control settings fetched")
+		c.settingsWarned = false
+	}
+	if settings != nil {
+		c.settings = make(map[string]client.DryContactSettings, len(settings.DryContacts))
+		for _, s := range settings.DryContacts {
+			c.settings[s.ID] = s
+		}
+	}
+	c.generatorMeter = generatorMeter
+	c.lastRefresh = time.Now()
+}
+
+// Describe implements prometheus.Collector.
+func (c *LoadControlCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.relayClosed
+	ch <- c.relayMode
+	ch <- c.generatorPresent
+	ch <- c.generatorRunning
+	ch <- c.generatorWatts
+	ch <- c.generatorWhTotal
+}
+
+// Collect implements prometheus.Collector.
+func (c *LoadControlCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	sinceRefresh := time.Since(c.lastRefresh)
+	needsRefresh := sinceRefresh > loadControlSettingsRefreshInterval || (c.settings == nil && sinceRefresh > loadControlSettingsRetryInterval)
+	c.mu.RUnlock()
+	if needsRefresh {
+		c.refreshSettings()
+	}
+
+	c.collectRelays(ch)
+	c.collectGenerator(ch)
+}
+
+func (c *LoadControlCollector) collectRelays(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	status, err := c.client.GetDryContactStatus()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("dry_contacts").Observe(duration.Seconds())
+	loadControlLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetDryContactStatus completed")
+	if err != nil {
+		loadControlLog.WithError(err).Error("Failed to get load control relay state")
+		return
+	}
+
+	if status == nil {
+		return
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, relay := range status.DryContacts {
+		settings, ok := c.settings[relay.ID]
+		ch <- prometheus.MustNewConstMetric(
+			c.relayClosed,
+			prometheus.GaugeValue,
+			boolToFloat(relay.Status == relayClosed),
+			relay.ID, settings.LoadName,
+		)
+
+		mode := relayModeUnknown
+		if ok {
+			mode = relayMode(settings)
+		}
+		for _, m := range []string{relayModeSoc, relayModeSchedule, relayModeGrid, relayModeUnknown} {
+			ch <- prometheus.MustNewConstMetric(
+				c.relayMode,
+				prometheus.GaugeValue,
+				boolToFloat(m == mode),
+				relay.ID, settings.LoadName, m,
+			)
+		}
+	}
+}
+
+func (c *LoadControlCollector) collectGenerator(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	gen, err := c.client.GetGenerator()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("generator").Observe(duration.Seconds())
+	loadControlLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetGenerator completed")
+	if err != nil {
+		loadControlLog.WithError(err).Error("Failed to get generator state")
+		return
+	}
+
+	if gen == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.generatorPresent,
+		prometheus.GaugeValue,
+		boolToFloat(gen.Present == 1),
+	)
+	if gen.Present != 1 {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		c.generatorRunning,
+		prometheus.GaugeValue,
+		boolToFloat(gen.OperState == "on"),
+	)
+
+	c.mu.RLock()
+	generatorMeter := c.generatorMeter
+	c.mu.RUnlock()
+	if generatorMeter == 0 {
+		return
+	}
+
+	start = time.Now()
+	readings, err := c.client.GetMeterReadings()
+	duration = time.Since(start)
+	APICallDuration.WithLabelValues("meters").Observe(duration.Seconds())
+	if err != nil {
+		loadControlLog.WithError(err).Error("Failed to get generator meter readings")
+		return
+	}
+
+	if readings == nil {
+		return
+	}
+
+	for _, reading := range *readings {
+		if reading.Eid != generatorMeter {
+			continue
+		}
+		delivered, _ := readingEnergy(reading)
+		ch <- prometheus.MustNewConstMetric(
+			c.generatorWatts,
+			prometheus.GaugeValue,
+			reading.ActivePower,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.generatorWhTotal,
+			prometheus.CounterValue,
+			delivered,
+		)
+	}
+}
+
+// relayMode normalizes relay settings into soc, schedule or grid mode.
+// Relays without SoC thresholds or an essential window simply follow
+// their grid/microgrid actions. Unrecognized modes are unknown rather than
+// guessed.
+func relayMode(s client.DryContactSettings) string {
+	switch {
+	case s.Mode == relayModeSoc:
+		return relayModeSoc
+	case s.Mode != "manual":
+		loadControlLog.WithFields(logrus.Fields{"relay_id": s.ID, "mode": s.Mode}).Debug("Unrecognized load control relay mode")
+		return relayModeUnknown
+	case s.GridAction == relayModeSchedule || s.EssentialStartTime != s.EssentialEndTime:
+		return relayModeSchedule
+	default:
+		return relayModeGrid
+	}
+}
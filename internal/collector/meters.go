@@ -22,6 +22,8 @@ type MetersCollector struct {
 	meterTypesMu sync.RWMutex
 	lastRefresh  time.Time
 
+	observers []NetPowerObserver
+
 	voltage         *prometheus.Desc
 	current         *prometheus.Desc
 	activePower     *prometheus.Desc
@@ -31,6 +33,12 @@ type MetersCollector struct {
 	energyReceived  *prometheus.Desc
 }
 
+// NetPowerObserver receives the net-consumption meter's active power after
+// each meters scrape. Positive watts are imported from the grid.
+type NetPowerObserver interface {
+	ObserveNetPower(t time.Time, watts float64)
+}
+
 // NewMetersCollector creates a new MetersCollector.
 func NewMetersCollector(client EnphaseClient) *MetersCollector {
 	c := &MetersCollector{
@@ -105,6 +113,14 @@ func (c *MetersCollector) refreshMeterTypes() {
 	c.lastRefresh = time.Now()
 }
 
+// AddObserver registers an observer for net meter power. Observers are
+// called synchronously from Collect and must not block.
+func (c *MetersCollector) AddObserver(o NetPowerObserver) {
+	c.meterTypesMu.Lock()
+	defer c.meterTypesMu.Unlock()
+	c.observers = append(c.observers, o)
+}
+
 func (c *MetersCollector) getMeasurementType(eid int64) string {
 	c.meterTypesMu.RLock()
 	defer c.meterTypesMu.RUnlock()
@@ -149,10 +165,20 @@ func (c *MetersCollector) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
+	c.meterTypesMu.RLock()
+	observers := c.observers
+	c.meterTypesMu.RUnlock()
+
 	for _, reading := range *readings {
 		meterID := fmt.Sprintf("%d", reading.Eid)
 		measurementType := c.getMeasurementType(reading.Eid)
 
+		if measurementType == measurementNetConsumption {
+			for _, o := range observers {
+				o.ObserveNetPower(start, reading.ActivePower)
+			}
+		}
+
 		// Total meter values
 		ch <- prometheus.MustNewConstMetric(
 			c.voltage,
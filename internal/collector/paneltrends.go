@@ -0,0 +1,461 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
+)
+
+var trendsLog = logrus.WithField("collector", "panel_trends")
+
+// minTrendSpan is the shortest history a degradation rate is fitted to.
+// Shorter spans are dominated by seasonal and soiling effects.
+const minTrendSpan = 90 * 24 * time.Hour
+
+// minClearSkySpan is the shortest history a clear-sky normalized rate is
+// fitted to. The clear-sky model has no temperature term and a fixed diffuse
+// share, so its seasonal bias is far larger than a degradation rate and only
+// cancels out over a full year.
+const minClearSkySpan = 365 * 24 * time.Hour
+
+// Normalization methods for daily panel output.
+const (
+	TrendPeers    = "peers"
+	TrendClearSky = "clear_sky"
+)
+
+// TrendConfig configures long-term panel degradation tracking.
+type TrendConfig struct {
+	// Method is how daily output is normalized, TrendPeers or TrendClearSky
+	Method string
+	// Window is how much daily history is kept and fitted
+	Window time.Duration
+	// MinDays is the number of recorded days needed before a rate is fitted
+	MinDays int
+	// ClearDayFraction is the share of the clear-sky daily energy the whole
+	// site must produce for a day to be recorded with clear-sky normalization
+	ClearDayFraction float64
+	// Groups maps serial numbers to peer groups for peer normalization, as
+	// for peer comparison; unlisted inverters are grouped by layout array
+	Groups map[string]string
+	// Labels adds layout labels to the per-inverter metrics
+	Labels InverterLabels
+}
+
+// Validate checks the trend configuration.
+func (c TrendConfig) Validate() error {
+	if c.Method != TrendPeers && c.Method != TrendClearSky {
+		return fmt.Errorf("method must be %q or %q, got %q", TrendPeers, TrendClearSky, c.Method)
+	}
+	if c.Window < minTrendSpan {
+		return fmt.Errorf("window must be at least %s, got %s", minTrendSpan, c.Window)
+	}
+	// Clear days are rare in winter, so a year-long window rarely holds a
+	// full year of them
+	if c.Method == TrendClearSky && c.Window < 2*minClearSkySpan {
+		return fmt.Errorf("clear-sky window must be at least %s, got %s", 2*minClearSkySpan, c.Window)
+	}
+	if c.MinDays < 2 {
+		return fmt.Errorf("minimum days must be at least 2, got %d", c.MinDays)
+	}
+	if c.ClearDayFraction <= 0 || c.ClearDayFraction > 1 {
+		return fmt.Errorf("clear day fraction must be between 0 and 1, got %v", c.ClearDayFraction)
+	}
+	return nil
+}
+
+// trendDay is one inverter's output on one local day, and its output relative
+// to the reference for that day.
+type trendDay struct {
+	Day              time.Time `json:"day"`
+	EnergyWh         float64   `json:"energy_wh"`
+	PeakW            float64   `json:"peak_watts"`
+	NormalizedEnergy float64   `json:"normalized_energy"`
+	NormalizedPeak   float64   `json:"normalized_peak"`
+}
+
+// panelTracker accumulates an inverter's current day and keeps its history.
+type panelTracker struct {
+	Energy  inverterEnergy `json:"energy"`
+	PeakDay time.Time      `json:"peak_day"`
+	PeakW   float64        `json:"peak_watts"`
+	History []trendDay     `json:"history"`
+}
+
+// trendFit is a least squares line through normalized daily energy.
+type trendFit struct {
+	// Rate is the fractional loss per year relative to the start of the fit;
+	// positive means degrading
+	Rate float64
+	Days int
+}
+
+// fitTrend fits normalized energy against time. ok is false if there are too
+// few days or they span less than minSpan.
+func fitTrend(history []trendDay, minDays int, minSpan time.Duration) (fit trendFit, ok bool) {
+	if len(history) < minDays || len(history) < 2 {
+		return fit, false
+	}
+	first := history[0].Day
+	if history[len(history)-1].Day.Sub(first) < minSpan {
+		return fit, false
+	}
+
+	var sx, sy, sxx, sxy float64
+	for _, d := range history {
+		x := d.Day.Sub(first).Hours() / 24 / 365.25
+		sx += x
+		sy += d.NormalizedEnergy
+		sxx += x * x
+		sxy += x * d.NormalizedEnergy
+	}
+	n := float64(len(history))
+	denom := n*sxx - sx*sx
+	if denom == 0 {
+		return fit, false
+	}
+	slope := (n*sxy - sx*sy) / denom
+	intercept := (sy - slope*sx) / n
+	if intercept <= 0 {
+		return fit, false
+	}
+	return trendFit{Rate: -slope / intercept, Days: len(history)}, true
+}
+
+// PanelTrendsCollector keeps a daily history of each inverter's energy and
+// peak, normalized to remove weather, and fits a trend to estimate how fast
+// each panel is degrading.
+type PanelTrendsCollector struct {
+	config   TrendConfig
+	loc      *time.Location
+	clearSky ClearSkyDayFunc
+
+	// day is the local day being accumulated
+	day    time.Time
+	panels map[string]*panelTracker
+	mu     sync.Mutex
+
+	degradationRate  *prometheus.Desc
+	normalizedEnergy *prometheus.Desc
+	trendDays        *prometheus.Desc
+}
+
+// NewPanelTrendsCollector creates a PanelTrendsCollector whose days end at
+// midnight in loc. With TrendPeers, daily output is normalized by the median
+// of the inverter's peer group: its configured group, its array, or all
+// inverters without a layout. This hides
+// degradation shared by every panel. With TrendClearSky it is normalized by
+// clearSky's expectation and only clear days are recorded; clearSky is
+// ignored for TrendPeers.
+func NewPanelTrendsCollector(config TrendConfig, loc *time.Location, clearSky ClearSkyDayFunc) *PanelTrendsCollector {
+	if config.Method != TrendClearSky {
+		clearSky = nil
+	}
+	return &PanelTrendsCollector{
+		config:   config,
+		loc:      loc,
+		clearSky: clearSky,
+		panels:   make(map[string]*panelTracker),
+		degradationRate: prometheus.NewDesc(
+			"enphase_inverter_degradation_rate",
+			"Estimated fractional loss of normalized daily energy per year (0.005 = 0.5%/year)",
+			config.Labels.names(),
+			nil,
+		),
+		normalizedEnergy: prometheus.NewDesc(
+			"enphase_inverter_normalized_energy",
+			"Inverter energy relative to the reference on the last recorded day (1 = typical)",
+			config.Labels.names(),
+			nil,
+		),
+		trendDays: prometheus.NewDesc(
+			"enphase_inverter_trend_days",
+			"Number of days in the inverter's degradation history",
+			config.Labels.names(),
+			nil,
+		),
+	}
+}
+
+// method returns how daily output is normalized.
+func (c *PanelTrendsCollector) method() string {
+	if c.clearSky != nil {
+		return TrendClearSky
+	}
+	return TrendPeers
+}
+
+// fit fits a degradation rate to history with the minimum span for the
+// normalization method.
+func (c *PanelTrendsCollector) fit(history []trendDay) (trendFit, bool) {
+	minSpan := minTrendSpan
+	if c.clearSky != nil {
+		minSpan = minClearSkySpan
+	}
+	return fitTrend(history, c.config.MinDays, minSpan)
+}
+
+// ObserveInverters implements InverterObserver.
+func (c *PanelTrendsCollector) ObserveInverters(t time.Time, inverters []client.Inverter) {
+	today := periodStart(periodDay, t, c.loc)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.day.IsZero() && today.After(c.day) {
+		c.finishDay(c.day)
+	}
+	c.day = today
+
+	for _, inv := range inverters {
+		p := c.panels[inv.SerialNumber]
+		if p == nil {
+			p = &panelTracker{}
+			c.panels[inv.SerialNumber] = p
+		}
+		if inv.LastReportDate > p.Energy.LastReport {
+			day := periodStart(periodDay, time.Unix(inv.LastReportDate, 0), c.loc)
+			if !day.Equal(p.PeakDay) {
+				p.PeakDay = day
+				p.PeakW = 0
+			}
+			p.PeakW = max(p.PeakW, float64(inv.LastReportWatts))
+		}
+		p.Energy.update(inv.LastReportDate, float64(inv.LastReportWatts), c.loc)
+	}
+}
+
+// finishDay normalizes and records each inverter's output on day. Must be
+// called with mu held.
+func (c *PanelTrendsCollector) finishDay(day time.Time) {
+	energy := make(map[string]float64)
+	peak := make(map[string]float64)
+	for serial, p := range c.panels {
+		if p.Energy.DayStart.Equal(day) && p.Energy.DayWh > 0 {
+			energy[serial] = p.Energy.DayWh
+			if p.PeakDay.Equal(day) {
+				peak[serial] = p.PeakW
+			}
+		}
+	}
+	if len(energy) == 0 {
+		return
+	}
+
+	refEnergy := make(map[string]float64)
+	refPeak := make(map[string]float64)
+	if c.clearSky != nil {
+		expectedWh, expectedPeak := c.clearSky(day)
+		var total float64
+		for _, wh := range energy {
+			total += wh
+		}
+		if expectedWh <= 0 || total < c.config.ClearDayFraction*expectedWh {
+			trendsLog.WithField("day", day.Format(time.DateOnly)).Debug("Skipping day that wasn't clear")
+			return
+		}
+		// The model is for the whole site, so each panel gets an equal share
+		n := float64(len(energy))
+		for serial := range energy {
+			refEnergy[serial] = expectedWh / n
+			refPeak[serial] = expectedPeak / n
+		}
+	} else {
+		groups := make(map[string][]string)
+		for serial := range energy {
+			g := peerGroup(c.config.Groups, c.config.Labels, serial)
+			groups[g] = append(groups[g], serial)
+		}
+		for _, serials := range groups {
+			// A median of one inverter is itself
+			if len(serials) < 2 {
+				continue
+			}
+			energies := make([]float64, len(serials))
+			peaks := make([]float64, len(serials))
+			for i, serial := range serials {
+				energies[i] = energy[serial]
+				peaks[i] = peak[serial]
+			}
+			medianWh, medianPeak := median(energies), median(peaks)
+			for _, serial := range serials {
+				refEnergy[serial] = medianWh
+				refPeak[serial] = medianPeak
+			}
+		}
+	}
+
+	cutoff := day.Add(-c.config.Window)
+	for serial, wh := range energy {
+		if refEnergy[serial] <= 0 {
+			continue
+		}
+		d := trendDay{
+			Day:              day,
+			EnergyWh:         wh,
+			PeakW:            peak[serial],
+			NormalizedEnergy: wh / refEnergy[serial],
+		}
+		if refPeak[serial] > 0 {
+			d.NormalizedPeak = peak[serial] / refPeak[serial]
+		}
+		p := c.panels[serial]
+		p.History = append(p.History, d)
+		i := 0
+		for i < len(p.History) && p.History[i].Day.Before(cutoff) {
+			i++
+		}
+		p.History = p.History[i:]
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *PanelTrendsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.degradationRate
+	ch <- c.normalizedEnergy
+	ch <- c.trendDays
+}
+
+// Collect implements prometheus.Collector.
+func (c *PanelTrendsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for serial, p := range c.panels {
+		if len(p.History) == 0 {
+			continue
+		}
+		labels := c.config.Labels.values(serial)
+		ch <- prometheus.MustNewConstMetric(
+			c.trendDays,
+			prometheus.GaugeValue,
+			float64(len(p.History)),
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.normalizedEnergy,
+			prometheus.GaugeValue,
+			p.History[len(p.History)-1].NormalizedEnergy,
+			labels...,
+		)
+		if fit, ok := c.fit(p.History); ok {
+			ch <- prometheus.MustNewConstMetric(
+				c.degradationRate,
+				prometheus.GaugeValue,
+				fit.Rate,
+				labels...,
+			)
+		}
+	}
+}
+
+// trendsResponse is the JSON served by the trends endpoint.
+type trendsResponse struct {
+	Method     string        `json:"method"`
+	WindowDays int           `json:"window_days"`
+	Panels     []panelTrends `json:"panels"`
+}
+
+type panelTrends struct {
+	SerialNumber    string           `json:"serial_number"`
+	Array           string           `json:"array,omitempty"`
+	DegradationRate *float64         `json:"degradation_rate"`
+	History         []trendDayRecord `json:"history"`
+}
+
+type trendDayRecord struct {
+	Date             string  `json:"date"`
+	EnergyWh         float64 `json:"energy_wh"`
+	PeakW            float64 `json:"peak_watts"`
+	NormalizedEnergy float64 `json:"normalized_energy"`
+	NormalizedPeak   float64 `json:"normalized_peak"`
+}
+
+// ServeHTTP serves each panel's daily history and fitted degradation rate as
+// JSON, sorted by serial number.
+func (c *PanelTrendsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	resp := trendsResponse{
+		Method:     c.method(),
+		WindowDays: int(c.config.Window.Hours() / 24),
+		Panels:     []panelTrends{},
+	}
+	for serial, p := range c.panels {
+		if len(p.History) == 0 {
+			continue
+		}
+		panel := panelTrends{
+			SerialNumber: serial,
+			Array:        peerGroup(c.config.Groups, c.config.Labels, serial),
+			History:      make([]trendDayRecord, len(p.History)),
+		}
+		if fit, ok := c.fit(p.History); ok {
+			panel.DegradationRate = &fit.Rate
+		}
+		for i, d := range p.History {
+			panel.History[i] = trendDayRecord{
+				Date:             d.Day.In(c.loc).Format(time.DateOnly),
+				EnergyWh:         d.EnergyWh,
+				PeakW:            d.PeakW,
+				NormalizedEnergy: d.NormalizedEnergy,
+				NormalizedPeak:   d.NormalizedPeak,
+			}
+		}
+		resp.Panels = append(resp.Panels, panel)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(resp.Panels, func(i, j int) bool {
+		return resp.Panels[i].SerialNumber < resp.Panels[j].SerialNumber
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		trendsLog.WithError(err).Warn("Failed to write panel trends")
+	}
+}
+
+// panelTrendsState is the persisted state of the PanelTrendsCollector.
+type panelTrendsState struct {
+	Day    time.Time                `json:"day"`
+	Panels map[string]*panelTracker `json:"panels"`
+}
+
+// StateKey implements state.Persister.
+func (c *PanelTrendsCollector) StateKey() string {
+	return "panel_trends"
+}
+
+// SaveState implements state.Persister.
+func (c *PanelTrendsCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(panelTrendsState{Day: c.day, Panels: c.panels})
+}
+
+// RestoreState implements state.Persister. A day that was in progress is
+// recorded with the next observation, so it may be partial; peer
+// normalization is unaffected and a partial day fails the clear-sky check.
+func (c *PanelTrendsCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var st panelTrendsState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.day = st.Day
+	for serial, p := range st.Panels {
+		if p != nil {
+			c.panels[serial] = p
+		}
+	}
+	return nil
+}
@@ -1,15 +1,45 @@
 package collector
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
 )
 
 var productionLog = logrus.WithField("collector", "production")
 
+// CT measurement types reported by /ivp/meters and the meter report endpoints.
+const (
+	measurementProduction       = "production"
+	measurementNetConsumption   = "net-consumption"
+	measurementTotalConsumption = "total-consumption"
+)
+
+// Values of the source label on consumption metrics.
+const (
+	consumptionSourceMeasured = "measured"
+	consumptionSourceDerived  = "derived"
+)
+
+// maxIntegrationGap is the longest gap between scrapes that power is
+// integrated across. Longer gaps (downtime, restarts) are skipped.
+const maxIntegrationGap = 5 * time.Minute
+
+// meterRetryInterval is how often meter metadata is retried while it has
+// never been fetched successfully.
+const meterRetryInterval = time.Minute
+
+// Values of the source label on grid import/export counters.
+const (
+	gridSourceMeasured   = "measured"
+	gridSourceIntegrated = "integrated"
+)
+
 // ProductionCollector collects production and consumption metrics from the Enphase gateway.
 type ProductionCollector struct {
 	client EnphaseClient
@@ -23,22 +53,67 @@ type ProductionCollector struct {
 	// Production counters
 	productionWhTotal *prometheus.Desc
 
+	// Daily/weekly production (legacy /production.json only)
+	productionWhToday         *prometheus.Desc
+	productionWhLastSevenDays *prometheus.Desc
+
 	// Consumption gauges
 	consumptionWatts *prometheus.Desc
 
 	// Consumption counters
 	consumptionWhTotal *prometheus.Desc
 
+	// Daily/weekly consumption (legacy /production.json only)
+	consumptionWhToday         *prometheus.Desc
+	consumptionWhLastSevenDays *prometheus.Desc
+
+	// Storage (legacy /production.json acb section)
+	storageWatts       *prometheus.Desc
+	storageWh          *prometheus.Desc
+	storagePercentFull *prometheus.Desc
+
 	// Net (production - consumption)
 	netWatts *prometheus.Desc
 
-	// Grid import/export accumulators
+	// Grid import/export, measured by the net CT or integrated from net power
 	gridExportWhTotal *prometheus.Desc
 	gridImportWhTotal *prometheus.Desc
 	gridExportAccum   float64
 	gridImportAccum   float64
 	lastScrapeTime    time.Time
-	mu                sync.Mutex
+	// lastGrid is the latest net CT reading, re-emitted when a read fails
+	lastGrid *gridReading
+
+	// CT roles discovered from /ivp/meters, refreshed periodically
+	meterConfigured  *prometheus.Desc
+	meterRoles       map[string]bool
+	meterRolesKnown  bool
+	netMeterEid      int64
+	lastMeterRefresh time.Time
+	meterWarned      bool
+
+	// legacy is set while the meter report endpoints return 404. They're
+	// probed again every meterTypeRefreshInterval, since a booting or
+	// updating gateway can return 404 temporarily.
+	legacy      bool
+	legacyProbe time.Time
+	mu          sync.Mutex
+
+	// liveStorage reads battery power from /ivp/livedata/status, which covers
+	// IQ Batteries that /production.json and the report endpoints omit
+	liveStorage bool
+
+	// Lifetime counters from the previous scrape, for EnergySample deltas
+	energy    energyCounters
+	observers []EnergyObserver
+}
+
+// energyCounters holds the last lifetime value of each energy flow.
+type energyCounters struct {
+	Production  lifetimeCounter `json:"production"`
+	Consumption lifetimeCounter `json:"consumption"`
+	Import      lifetimeCounter `json:"import"`
+	Export      lifetimeCounter `json:"export"`
 }
 
 // NewProductionCollector creates a new ProductionCollector.
@@ -76,19 +151,63 @@ func NewProductionCollector(client EnphaseClient) *ProductionCollector {
 			[]string{"device_type"},
 			nil,
 		),
-		// Consumption metrics (labeled by measurement_type: "total-consumption" or "net-consumption")
+		productionWhToday: prometheus.NewDesc(
+			"enphase_production_wh_today",
+			"Production today in watt-hours as reported by the gateway",
+			[]string{"device_type"},
+			nil,
+		),
+		productionWhLastSevenDays: prometheus.NewDesc(
+			"enphase_production_wh_last_seven_days",
+			"Production over the last seven days in watt-hours as reported by the gateway",
+			[]string{"device_type"},
+			nil,
+		),
+		// Consumption metrics (labeled by measurement_type: "total-consumption" or "net-consumption",
+		// and source: "measured" by a CT or "derived" from production)
 		consumptionWatts: prometheus.NewDesc(
 			"enphase_consumption_watts",
 			"Current consumption in watts",
-			[]string{"measurement_type"},
+			[]string{"measurement_type", "source"},
 			nil,
 		),
 		consumptionWhTotal: prometheus.NewDesc(
 			"enphase_consumption_wh_total",
 			"Total lifetime consumption in watt-hours",
+			[]string{"measurement_type", "source"},
+			nil,
+		),
+		consumptionWhToday: prometheus.NewDesc(
+			"enphase_consumption_wh_today",
+			"Consumption today in watt-hours as reported by the gateway",
+			[]string{"measurement_type"},
+			nil,
+		),
+		consumptionWhLastSevenDays: prometheus.NewDesc(
+			"enphase_consumption_wh_last_seven_days",
+			"Consumption over the last seven days in watt-hours as reported by the gateway",
 			[]string{"measurement_type"},
 			nil,
 		),
+		// Storage metrics
+		storageWatts: prometheus.NewDesc(
+			"enphase_storage_watts",
+			"Current storage power in watts. Positive = discharging, negative = charging",
+			[]string{"device_type"},
+			nil,
+		),
+		storageWh: prometheus.NewDesc(
+			"enphase_storage_wh",
+			"Energy currently stored in watt-hours",
+			[]string{"device_type"},
+			nil,
+		),
+		storagePercentFull: prometheus.NewDesc(
+			"enphase_storage_percent_full",
+			"Storage state of charge in percent",
+			[]string{"device_type"},
+			nil,
+		),
 		// Net metrics
 		netWatts: prometheus.NewDesc(
 			"enphase_net_watts",
@@ -96,23 +215,79 @@ func NewProductionCollector(client EnphaseClient) *ProductionCollector {
 			nil,
 			nil,
 		),
-		// Grid import/export accumulators
+		// Grid import/export (source: "measured" by the net CT or "integrated" from net power)
 		gridExportWhTotal: prometheus.NewDesc(
 			"enphase_grid_export_wh_total",
-			"Cumulative energy exported to grid in Wh (resets on restart)",
-			nil,
+			"Cumulative energy exported to grid in Wh",
+			[]string{"source"},
 			nil,
 		),
 		gridImportWhTotal: prometheus.NewDesc(
 			"enphase_grid_import_wh_total",
-			"Cumulative energy imported from grid in Wh (resets on restart)",
+			"Cumulative energy imported from grid in Wh",
+			[]string{"source"},
 			nil,
+		),
+		meterConfigured: prometheus.NewDesc(
+			"enphase_meter_configured",
+			"Whether an enabled CT meter with this measurement type is installed",
+			[]string{"measurement_type"},
 			nil,
 		),
 		lastScrapeTime: time.Now(),
 	}
 }
 
+// deviceReading is a normalized production or consumption reading.
+// The label is the device_type for production and measurement_type for consumption.
+type deviceReading struct {
+	label   string
+	watts   float64
+	whTotal float64
+
+	// Energy received (exported) by a net-consumption CT, from whRcvdCum
+	whReceived float64
+
+	// derived is set when the value was computed rather than measured by a CT
+	derived bool
+
+	// RMS values are only reported by metered (eim) devices
+	electrical  bool
+	voltage     float64
+	current     float64
+	powerFactor float64
+
+	// Daily/weekly totals are only available from /production.json
+	daily           bool
+	whToday         float64
+	whLastSevenDays float64
+}
+
+// storageReading is a normalized AC battery reading.
+type storageReading struct {
+	label       string
+	watts       float64
+	whNow       float64
+	percentFull float64
+}
+
+// productionSnapshot is a normalized view of a single scrape, built from either
+// the meter report endpoints or legacy /production.json.
+type productionSnapshot struct {
+	production  []deviceReading
+	consumption []deviceReading
+	storage     []storageReading
+
+	// grid is the net CT's lifetime import/export, if available
+	grid *gridReading
+}
+
+// gridReading holds lifetime grid energy measured by the net-consumption CT.
+type gridReading struct {
+	importWh float64
+	exportWh float64
+}
+
 // Describe implements prometheus.Collector.
 func (c *ProductionCollector) Describe(ch chan<- *prometheus.Desc) {
 	// Production
@@ -121,26 +296,194 @@ func (c *ProductionCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.rmsCurrent
 	ch <- c.powerFactor
 	ch <- c.productionWhTotal
+	ch <- c.productionWhToday
+	ch <- c.productionWhLastSevenDays
 	// Consumption
 	ch <- c.consumptionWatts
 	ch <- c.consumptionWhTotal
+	ch <- c.consumptionWhToday
+	ch <- c.consumptionWhLastSevenDays
+	// Storage
+	ch <- c.storageWatts
+	ch <- c.storageWh
+	ch <- c.storagePercentFull
 	// Net
 	ch <- c.netWatts
 	ch <- c.gridExportWhTotal
 	ch <- c.gridImportWhTotal
+	// Meter configuration
+	ch <- c.meterConfigured
 }
 
 // Collect implements prometheus.Collector.
 func (c *ProductionCollector) Collect(ch chan<- prometheus.Metric) {
+	// Refresh CT roles if stale or unknown
+	c.mu.Lock()
+	sinceRefresh := time.Since(c.lastMeterRefresh)
+	needsRefresh := sinceRefresh > meterTypeRefreshInterval || (!c.meterRolesKnown && sinceRefresh > meterRetryInterval)
+	c.mu.Unlock()
+	if needsRefresh {
+		c.refreshMeterRoles()
+	}
+	c.emitMeterRoles(ch)
+
+	snap := c.fetchSnapshot()
+	if snap == nil {
+		return
+	}
+
+	c.emitSnapshot(ch, snap)
+}
+
+// refreshMeterRoles records which CT measurement types are installed and enabled.
+func (c *ProductionCollector) refreshMeterRoles() {
+	meters, err := c.client.GetMeters()
+	if err != nil || meters == nil {
+		// Back off until the next retry and only warn once per outage
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.lastMeterRefresh = time.Now()
+		if c.meterWarned {
+			productionLog.WithError(err).Debug("Failed to fetch meter metadata")
+		} else if err != nil {
+			productionLog.WithError(err).Warn("Failed to fetch meter metadata, assuming consumption CTs may be present")
+			c.meterWarned = true
+		}
+		return
+	}
+
+	roles := make(map[string]bool)
+	var netMeterEid int64
+	for _, m := range *meters {
+		if m.State == "enabled" {
+			roles[m.MeasurementType] = true
+			if m.MeasurementType == measurementNetConsumption {
+				netMeterEid = m.Eid
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.meterRolesKnown && !roles[measurementNetConsumption] && !roles[measurementTotalConsumption] {
+		productionLog.Info("No consumption CTs configured, exporting production metrics only")
+	}
+	if c.meterWarned {
+		productionLog.Info("Meter metadata fetched")
+		c.meterWarned = false
+	}
+	c.meterRoles = roles
+	c.meterRolesKnown = true
+	c.netMeterEid = netMeterEid
+	c.lastMeterRefresh = time.Now()
+}
+
+// emitMeterRoles exports enphase_meter_configured once CT roles are known.
+func (c *ProductionCollector) emitMeterRoles(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.meterRolesKnown {
+		return
+	}
+	for _, mt := range []string{measurementProduction, measurementNetConsumption, measurementTotalConsumption} {
+		ch <- prometheus.MustNewConstMetric(
+			c.meterConfigured,
+			prometheus.GaugeValue,
+			boolToFloat(c.meterRoles[mt]),
+			mt,
+		)
+	}
+}
+
+// consumptionConfigured reports whether a consumption report is worth fetching.
+// Until meter metadata is known we assume consumption CTs may be present.
+func (c *ProductionCollector) consumptionConfigured() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.meterRolesKnown {
+		return true
+	}
+	return c.meterRoles[measurementNetConsumption] || c.meterRoles[measurementTotalConsumption]
+}
+
+// fetchSnapshot reads from the meter report endpoints, switching to
+// /production.json while the gateway reports them as unavailable.
+func (c *ProductionCollector) fetchSnapshot() *productionSnapshot {
+	c.mu.Lock()
+	legacy := c.legacy
+	probe := !legacy || time.Since(c.legacyProbe) > meterTypeRefreshInterval
+	c.mu.Unlock()
+
+	if probe {
+		snap, err := c.fetchReports()
+		switch {
+		case client.IsNotFound(err):
+			if !legacy {
+				productionLog.WithError(err).Warn("Meter report endpoints unavailable, falling back to /production.json")
+			}
+			c.mu.Lock()
+			c.legacy = true
+			c.legacyProbe = time.Now()
+			c.mu.Unlock()
+		case !legacy:
+			return snap
+		case snap != nil:
+			productionLog.Info("Meter report endpoints available again, leaving legacy mode")
+			c.mu.Lock()
+			c.legacy = false
+			c.mu.Unlock()
+			return snap
+		default:
+			// Other failures while re-probing keep legacy mode for now
+			c.mu.Lock()
+			c.legacyProbe = time.Now()
+			c.mu.Unlock()
+		}
+	}
+
+	return c.fetchLegacy()
+}
+
+// fetchReports builds a snapshot from /ivp/meters/reports/*.
+// Only a not-found error is returned so the caller can fall back; other
+// failures are logged here.
+func (c *ProductionCollector) fetchReports() (*productionSnapshot, error) {
 	// Fetch production report
 	start := time.Now()
 	prodReport, err := c.client.GetProductionReport()
 	duration := time.Since(start)
 	APICallDuration.WithLabelValues("production_report").Observe(duration.Seconds())
 	productionLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetProductionReport completed")
+	if client.IsNotFound(err) {
+		return nil, err
+	}
 	if err != nil {
 		productionLog.WithError(err).Error("Failed to get production report")
-		return
+		return nil, nil
+	}
+
+	if prodReport == nil {
+		return nil, nil
+	}
+
+	snap := &productionSnapshot{}
+
+	// Production metrics from cumulative data
+	prod := prodReport.Cumulative
+	prodWh, _ := reportEnergy(prodReport)
+	snap.production = append(snap.production, deviceReading{
+		label:       "eim",
+		watts:       prod.CurrW,
+		whTotal:     prodWh,
+		electrical:  true,
+		voltage:     prod.RmsVoltage,
+		current:     prod.RmsCurrent,
+		powerFactor: prod.PwrFactor,
+	})
+
+	// Production is exported even when consumption is missing or fails
+	if !c.consumptionConfigured() {
+		return snap, nil
 	}
 
 	// Fetch consumption report
@@ -149,91 +492,253 @@ func (c *ProductionCollector) Collect(ch chan<- prometheus.Metric) {
 	duration = time.Since(start)
 	APICallDuration.WithLabelValues("consumption_report").Observe(duration.Seconds())
 	productionLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetConsumptionReport completed")
+	if client.IsNotFound(err) {
+		productionLog.Debug("No consumption report, exporting production only")
+		return snap, nil
+	}
 	if err != nil {
-		productionLog.WithError(err).Error("Failed to get consumption report")
-		return
+		productionLog.WithError(err).Warn("Failed to get consumption report, exporting production only")
+		return snap, nil
 	}
 
-	if prodReport == nil || consReport == nil {
-		return
+	if consReport == nil {
+		return snap, nil
 	}
 
-	// Production metrics from cumulative data
-	prod := prodReport.Cumulative
-	ch <- prometheus.MustNewConstMetric(
-		c.productionWatts,
-		prometheus.GaugeValue,
-		prod.CurrW,
-		"eim",
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.rmsVoltage,
-		prometheus.GaugeValue,
-		prod.RmsVoltage,
-		"eim",
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.rmsCurrent,
-		prometheus.GaugeValue,
-		prod.RmsCurrent,
-		"eim",
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.powerFactor,
-		prometheus.GaugeValue,
-		prod.PwrFactor,
-		"eim",
-	)
+	// Consumption metrics
+	for i := range *consReport {
+		report := &(*consReport)[i]
+		whDelivered, whReceived := reportEnergy(report)
+		snap.consumption = append(snap.consumption, deviceReading{
+			label:      report.ReportType,
+			watts:      report.Cumulative.CurrW,
+			whTotal:    whDelivered,
+			whReceived: whReceived,
+		})
 
-	// Use lines[] summation for whDlvdCum to fix split-phase doubling
-	prodWhTotal := prod.WhDlvdCum
-	if len(prodReport.Lines) > 0 {
-		prodWhTotal = 0
-		for _, line := range prodReport.Lines {
-			prodWhTotal += line.WhDlvdCum
+		// The net CT measures grid energy directly: delivered = import, received = export
+		if report.ReportType == measurementNetConsumption {
+			snap.grid = &gridReading{importWh: whDelivered, exportWh: whReceived}
 		}
 	}
-	ch <- prometheus.MustNewConstMetric(
-		c.productionWhTotal,
-		prometheus.CounterValue,
-		prodWhTotal,
-		"eim",
-	)
 
-	// Consumption metrics
-	var totalConsumptionW float64
-	for _, report := range *consReport {
-		label := report.ReportType
+	return snap, nil
+}
+
+// fetchLegacy builds a snapshot from /production.json.
+func (c *ProductionCollector) fetchLegacy() *productionSnapshot {
+	start := time.Now()
+	resp, err := c.client.GetProductionJSON()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("production_json").Observe(duration.Seconds())
+	productionLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetProductionJSON completed")
+	if err != nil {
+		productionLog.WithError(err).Error("Failed to get production.json")
+		return nil
+	}
 
-		if report.ReportType == "total-consumption" {
-			totalConsumptionW = report.Cumulative.CurrW
+	if resp == nil {
+		return nil
+	}
+
+	snap := &productionSnapshot{}
+	for _, entry := range resp.Production {
+		// Metered units without an enabled production CT report an empty eim section
+		if entry.Type == "eim" && entry.ActiveCount == 0 {
+			continue
+		}
+		reading := legacyReading(entry.Type, entry)
+		// Inverters only report aggregate power and energy
+		reading.electrical = entry.Type == "eim"
+		reading.daily = entry.Type == "eim"
+		snap.production = append(snap.production, reading)
+	}
+	for _, entry := range resp.Consumption {
+		if entry.ActiveCount == 0 {
+			continue
+		}
+		reading := legacyReading(entry.MeasurementType, entry)
+		reading.daily = true
+		snap.consumption = append(snap.consumption, reading)
+	}
+	for _, entry := range resp.Storage {
+		if entry.ActiveCount == 0 {
+			continue
+		}
+		snap.storage = append(snap.storage, storageReading{
+			label:       entry.Type,
+			watts:       entry.WNow,
+			whNow:       entry.WhNow,
+			percentFull: entry.PercentFull,
+		})
+	}
+
+	// production.json only reports signed net energy, so read import and
+	// export from the net meter itself
+	if len(snap.consumption) > 0 {
+		snap.grid = c.fetchNetMeterEnergy()
+	}
+
+	return snap
+}
+
+// fetchNetMeterEnergy reads lifetime import/export from the net-consumption
+// meter in /ivp/meters/readings. Returns nil if no net meter is installed.
+func (c *ProductionCollector) fetchNetMeterEnergy() *gridReading {
+	c.mu.Lock()
+	eid := c.netMeterEid
+	c.mu.Unlock()
+	if eid == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	readings, err := c.client.GetMeterReadings()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("meters").Observe(duration.Seconds())
+	productionLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetMeterReadings completed")
+	if err != nil {
+		productionLog.WithError(err).Warn("Failed to get net meter readings, integrating grid energy from power")
+		return nil
+	}
+
+	if readings == nil {
+		return nil
+	}
+
+	for _, reading := range *readings {
+		if reading.Eid != eid {
+			continue
+		}
+		importWh, exportWh := readingEnergy(reading)
+		return &gridReading{importWh: importWh, exportWh: exportWh}
+	}
+	return nil
+}
+
+// emitSnapshot exports a normalized snapshot and updates grid accumulators.
+func (c *ProductionCollector) emitSnapshot(ch chan<- prometheus.Metric, snap *productionSnapshot) {
+	// Prefer the production CT over the inverter aggregate for net power
+	var productionW, productionWh float64
+	var productionSource string
+	var haveEim bool
+	for _, r := range snap.production {
+		if r.label == "eim" {
+			productionW, productionWh = r.watts, r.whTotal
+			productionSource = r.label
+			haveEim = true
+		} else if !haveEim {
+			productionW, productionWh = r.watts, r.whTotal
+			productionSource = r.label
 		}
 
 		ch <- prometheus.MustNewConstMetric(
-			c.consumptionWatts,
+			c.productionWatts,
 			prometheus.GaugeValue,
-			report.Cumulative.CurrW,
-			label,
+			r.watts,
+			r.label,
 		)
-
-		// Use lines[] summation for whDlvdCum to fix split-phase doubling
-		whTotal := report.Cumulative.WhDlvdCum
-		if len(report.Lines) > 0 {
-			whTotal = 0
-			for _, line := range report.Lines {
-				whTotal += line.WhDlvdCum
-			}
-		}
 		ch <- prometheus.MustNewConstMetric(
-			c.consumptionWhTotal,
+			c.productionWhTotal,
 			prometheus.CounterValue,
-			whTotal,
-			label,
+			r.whTotal,
+			r.label,
+		)
+		if r.electrical {
+			ch <- prometheus.MustNewConstMetric(
+				c.rmsVoltage,
+				prometheus.GaugeValue,
+				r.voltage,
+				r.label,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.rmsCurrent,
+				prometheus.GaugeValue,
+				r.current,
+				r.label,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.powerFactor,
+				prometheus.GaugeValue,
+				r.powerFactor,
+				r.label,
+			)
+		}
+		if r.daily {
+			ch <- prometheus.MustNewConstMetric(
+				c.productionWhToday,
+				prometheus.GaugeValue,
+				r.whToday,
+				r.label,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.productionWhLastSevenDays,
+				prometheus.GaugeValue,
+				r.whLastSevenDays,
+				r.label,
+			)
+		}
+	}
+
+	for _, r := range snap.storage {
+		ch <- prometheus.MustNewConstMetric(
+			c.storageWatts,
+			prometheus.GaugeValue,
+			r.watts,
+			r.label,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.storageWh,
+			prometheus.GaugeValue,
+			r.whNow,
+			r.label,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.storagePercentFull,
+			prometheus.GaugeValue,
+			r.percentFull,
+			r.label,
 		)
 	}
 
+	sample := EnergySample{Time: time.Now(), ProductionW: productionW, HasStorage: len(snap.storage) > 0}
+	for _, r := range snap.storage {
+		sample.StorageW += r.watts
+	}
+	c.mu.Lock()
+	liveStorage := c.liveStorage
+	c.mu.Unlock()
+	if liveStorage {
+		// Live data includes AC batteries, so it replaces the acb reading
+		if watts, ok := c.liveDataStorage(); ok {
+			sample.StorageW, sample.HasStorage = watts, true
+		}
+	}
+	c.mu.Lock()
+	sample.ProductionWh = c.energy.Production.delta(productionWh, productionSource)
+	c.mu.Unlock()
+
+	// Net and grid metrics can't be computed without consumption CTs
+	if len(snap.consumption) == 0 {
+		if len(snap.production) > 0 {
+			c.notifyObservers(sample)
+		}
+		return
+	}
+
+	consumption := c.deriveConsumption(snap.consumption, productionW, productionWh)
+
+	var totalConsumptionW float64
+	var totalConsumption *deviceReading
+	for i, r := range consumption {
+		if r.label == measurementTotalConsumption {
+			totalConsumptionW = r.watts
+			totalConsumption = &consumption[i]
+		}
+	}
+
 	// Net power (positive = exporting to grid, negative = importing from grid)
-	netWatts := prod.CurrW - totalConsumptionW
+	netWatts := productionW - totalConsumptionW
 	ch <- prometheus.MustNewConstMetric(
 		c.netWatts,
 		prometheus.GaugeValue,
@@ -243,7 +748,7 @@ func (c *ProductionCollector) Collect(ch chan<- prometheus.Metric) {
 	// Accumulate grid import/export energy based on instantaneous net power
 	c.mu.Lock()
 	elapsed := time.Since(c.lastScrapeTime).Seconds()
-	if elapsed > 0 && elapsed < 300 {
+	if elapsed > 0 && elapsed < maxIntegrationGap.Seconds() {
 		if netWatts > 0 {
 			c.gridExportAccum += netWatts * elapsed / 3600
 		} else if netWatts < 0 {
@@ -255,14 +760,294 @@ func (c *ProductionCollector) Collect(ch chan<- prometheus.Metric) {
 	importAccum := c.gridImportAccum
 	c.mu.Unlock()
 
-	ch <- prometheus.MustNewConstMetric(
-		c.gridExportWhTotal,
-		prometheus.CounterValue,
-		exportAccum,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		c.gridImportWhTotal,
-		prometheus.CounterValue,
-		importAccum,
-	)
+	for _, r := range consumption {
+		source := consumptionSourceMeasured
+		if r.derived {
+			source = consumptionSourceDerived
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.consumptionWatts,
+			prometheus.GaugeValue,
+			r.watts,
+			r.label, source,
+		)
+		// Derived net energy would fall while exporting, so it isn't a
+		// counter; grid import and export cover it instead
+		if !r.derived || r.label != measurementNetConsumption {
+			ch <- prometheus.MustNewConstMetric(
+				c.consumptionWhTotal,
+				prometheus.CounterValue,
+				r.whTotal,
+				r.label, source,
+			)
+		}
+		if r.daily {
+			ch <- prometheus.MustNewConstMetric(
+				c.consumptionWhToday,
+				prometheus.GaugeValue,
+				r.whToday,
+				r.label,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				c.consumptionWhLastSevenDays,
+				prometheus.GaugeValue,
+				r.whLastSevenDays,
+				r.label,
+			)
+		}
+	}
+
+	// Prefer the gateway's lifetime counters; integration is only a fallback
+	// for sites without a net CT. The source follows the installed CTs so a
+	// failed read doesn't flip the counters to another series.
+	c.mu.Lock()
+	if snap.grid != nil {
+		c.lastGrid = snap.grid
+	}
+	lastGrid := c.lastGrid
+	integrate := c.meterRolesKnown && !c.meterRoles[measurementNetConsumption]
+	c.mu.Unlock()
+
+	gridSource := gridSourceIntegrated
+	emitGrid := true
+	switch {
+	case lastGrid != nil:
+		exportAccum, importAccum = lastGrid.exportWh, lastGrid.importWh
+		gridSource = gridSourceMeasured
+	case !integrate:
+		// A net CT is (or may be) installed but hasn't been read yet
+		emitGrid = false
+	}
+	if emitGrid {
+		ch <- prometheus.MustNewConstMetric(
+			c.gridExportWhTotal,
+			prometheus.CounterValue,
+			exportAccum,
+			gridSource,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.gridImportWhTotal,
+			prometheus.CounterValue,
+			importAccum,
+			gridSource,
+		)
+	}
+
+	sample.ConsumptionW = totalConsumptionW
+	sample.HasConsumption = true
+	c.mu.Lock()
+	if totalConsumption != nil {
+		// Derived consumption moves with the production source it's derived from
+		source := consumptionSourceMeasured
+		if totalConsumption.derived {
+			source = consumptionSourceDerived + ":" + productionSource
+		}
+		sample.ConsumptionWh = c.energy.Consumption.delta(totalConsumption.whTotal, source)
+	}
+	if emitGrid {
+		sample.ImportWh = c.energy.Import.delta(importAccum, gridSource)
+		sample.ExportWh = c.energy.Export.delta(exportAccum, gridSource)
+	}
+	c.mu.Unlock()
+	c.notifyObservers(sample)
+}
+
+// UseLiveDataStorage takes battery power for energy samples from the live
+// data storage meter rather than the legacy acb section. It costs an extra
+// request per scrape, so it's only worth enabling with the live data collector.
+func (c *ProductionCollector) UseLiveDataStorage() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveStorage = true
+}
+
+// liveDataStorage returns battery power from live data in watts, positive when
+// discharging. ok is false if the request fails or no batteries are reported.
+func (c *ProductionCollector) liveDataStorage() (watts float64, ok bool) {
+	start := time.Now()
+	live, err := c.client.GetLiveData()
+	duration := time.Since(start)
+	APICallDuration.WithLabelValues("livedata").Observe(duration.Seconds())
+	productionLog.WithField("duration_ms", duration.Milliseconds()).Debug("GetLiveData completed")
+	if err != nil {
+		productionLog.WithError(err).Warn("Failed to get live data, battery flows use the acb section only")
+		return 0, false
+	}
+	if live == nil {
+		return 0, false
+	}
+
+	m := live.Meters
+	present := m.EncAggEnergy > 0 || m.AcbAggEnergy > 0 || m.Storage.AggPMw != 0
+	return m.Storage.AggPMw / 1000, present
+}
+
+// AddObserver registers an observer for energy samples. Observers are called
+// synchronously from Collect and must not block.
+func (c *ProductionCollector) AddObserver(o EnergyObserver) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observers = append(c.observers, o)
+}
+
+func (c *ProductionCollector) notifyObservers(sample EnergySample) {
+	c.mu.Lock()
+	observers := c.observers
+	c.mu.Unlock()
+	for _, o := range observers {
+		o.ObserveEnergy(sample)
+	}
+}
+
+// productionState is the persisted form of the grid accumulators and the
+// lifetime counters used for energy samples.
+type productionState struct {
+	GridExportWh float64        `json:"grid_export_wh"`
+	GridImportWh float64        `json:"grid_import_wh"`
+	LastScrape   time.Time      `json:"last_scrape"`
+	Energy       energyCounters `json:"energy"`
+}
+
+// StateKey implements state.Persister.
+func (c *ProductionCollector) StateKey() string {
+	return "production"
+}
+
+// SaveState implements state.Persister.
+func (c *ProductionCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(productionState{
+		GridExportWh: c.gridExportAccum,
+		GridImportWh: c.gridImportAccum,
+		LastScrape:   c.lastScrapeTime,
+		Energy:       c.energy,
+	})
+}
+
+// RestoreState implements state.Persister. Accumulators are always restored;
+// the last scrape time and lifetime counter baselines are only restored after
+// a short restart. After longer downtime the first scrape sets new baselines,
+// so energy from the gap isn't credited to the current day, week or month.
+func (c *ProductionCollector) RestoreState(data json.RawMessage, savedAt time.Time) error {
+	var st productionState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gridExportAccum = st.GridExportWh
+	c.gridImportAccum = st.GridImportWh
+	if age := time.Since(savedAt); age >= 0 && age < maxIntegrationGap && !st.LastScrape.After(time.Now()) {
+		c.lastScrapeTime = st.LastScrape
+		c.energy = st.Energy
+	}
+	return nil
+}
+
+// consumptionCTMode returns the measurement type of the installed consumption
+// CT, or "" if it's unknown or ambiguous.
+func (c *ProductionCollector) consumptionCTMode() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	net := c.meterRoles[measurementNetConsumption]
+	total := c.meterRoles[measurementTotalConsumption]
+	switch {
+	case net && !total:
+		return measurementNetConsumption
+	case total && !net:
+		return measurementTotalConsumption
+	default:
+		return ""
+	}
+}
+
+// deriveConsumption replaces the quantity the consumption CT doesn't measure
+// with one computed from production:
+//
+//	net CT ("load with solar"): total = production + net
+//	total CT ("load only"):     net = total - production
+//
+// Readings are returned unchanged when the CT mode is unknown.
+func (c *ProductionCollector) deriveConsumption(readings []deviceReading, productionW, productionWh float64) []deviceReading {
+	mode := c.consumptionCTMode()
+	if mode == "" {
+		return readings
+	}
+
+	var measured *deviceReading
+	for i := range readings {
+		if readings[i].label == mode {
+			measured = &readings[i]
+		}
+	}
+	if measured == nil {
+		return readings
+	}
+
+	derived := deviceReading{derived: true}
+	if mode == measurementNetConsumption {
+		// Net energy is import (delivered) minus export (received)
+		derived.label = measurementTotalConsumption
+		derived.watts = productionW + measured.watts
+		derived.whTotal = productionWh + measured.whTotal - measured.whReceived
+	} else {
+		// Only power is derived; net energy comes from the grid counters
+		derived.label = measurementNetConsumption
+		derived.watts = measured.watts - productionW
+	}
+
+	return []deviceReading{*measured, derived}
+}
+
+// reportEnergy returns whDlvdCum and whRcvdCum summed over lines[] to fix
+// split-phase doubling, falling back to cumulative values for single-phase reports.
+func reportEnergy(report *client.MeterReport) (delivered, received float64) {
+	if len(report.Lines) == 0 {
+		return report.Cumulative.WhDlvdCum, report.Cumulative.WhRcvdCum
+	}
+	for _, line := range report.Lines {
+		delivered += line.WhDlvdCum
+		received += line.WhRcvdCum
+	}
+	return delivered, received
+}
+
+// readingEnergy returns actEnergyDlvd and actEnergyRcvd summed over channels,
+// falling back to the meter totals when no per-phase channels are reported.
+func readingEnergy(reading client.MeterReading) (delivered, received float64) {
+	if len(reading.Channels) == 0 {
+		return reading.ActEnergyDlvd, reading.ActEnergyRcvd
+	}
+	for _, channel := range reading.Channels {
+		delivered += channel.ActEnergyDlvd
+		received += channel.ActEnergyRcvd
+	}
+	return delivered, received
+}
+
+// legacyReading converts a /production.json section into a deviceReading.
+// Lifetime energy uses lines[] summation like the report endpoints, while daily
+// and weekly totals use top-level values because lines[] don't reset at midnight.
+func legacyReading(label string, entry client.ProductionJSONEntry) deviceReading {
+	whTotal := entry.WhLifetime
+	if len(entry.Lines) > 0 {
+		whTotal = 0
+		for _, line := range entry.Lines {
+			whTotal += line.WhLifetime
+		}
+	}
+
+	return deviceReading{
+		label:           label,
+		watts:           entry.WNow,
+		whTotal:         whTotal,
+		voltage:         entry.RmsVoltage,
+		current:         entry.RmsCurrent,
+		powerFactor:     entry.PwrFactor,
+		whToday:         entry.WhToday,
+		whLastSevenDays: entry.WhLastSevenDays,
+	}
 }
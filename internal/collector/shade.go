@@ -0,0 +1,421 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rhwendt/enphase-exporter/internal/client"
+)
+
+var shadeLog = logrus.WithField("collector", "shade")
+
+// ShadeConfig configures recurring shade detection.
+type ShadeConfig struct {
+	// BucketSize is the time-of-day resolution; it must divide a day
+	BucketSize time.Duration
+	// Days is how many days of history are kept and analyzed
+	Days int
+	// MinDays is the number of days a bucket must be observed before it can
+	// be flagged
+	MinDays int
+	// Threshold is the relative performance below which a bucket is shaded
+	// on a day, e.g. 0.7 for 30% below the peer median
+	Threshold float64
+	// Recurrence is the fraction of observed days a bucket must be shaded on
+	// to be a recurring shade window
+	Recurrence float64
+	// MinMedianWatts skips samples where the peer median is below this
+	MinMedianWatts float64
+	// Groups maps serial numbers to peer groups, as for peer comparison
+	Groups map[string]string
+	// Labels adds layout labels to the per-inverter metrics
+	Labels InverterLabels
+}
+
+// Validate checks the shade configuration.
+func (c ShadeConfig) Validate() error {
+	if c.BucketSize < 5*time.Minute || c.BucketSize > 2*time.Hour || (24*time.Hour)%c.BucketSize != 0 {
+		return fmt.Errorf("bucket size must divide a day and be between 5m and 2h, got %s", c.BucketSize)
+	}
+	if c.Days < 2 {
+		return fmt.Errorf("days must be at least 2, got %d", c.Days)
+	}
+	if c.MinDays < 1 || c.MinDays > c.Days {
+		return fmt.Errorf("minimum days must be between 1 and %d, got %d", c.Days, c.MinDays)
+	}
+	if c.Threshold <= 0 || c.Threshold >= 1 {
+		return fmt.Errorf("threshold must be between 0 and 1, got %v", c.Threshold)
+	}
+	if c.Recurrence <= 0 || c.Recurrence > 1 {
+		return fmt.Errorf("recurrence must be between 0 and 1, got %v", c.Recurrence)
+	}
+	if c.MinMedianWatts < 0 {
+		return fmt.Errorf("minimum median watts must not be negative, got %v", c.MinMedianWatts)
+	}
+	return nil
+}
+
+// shadeBucket accumulates one inverter's output relative to its peers in one
+// time-of-day bucket on one day.
+type shadeBucket struct {
+	// Seconds observed, relative performance weighted by seconds, and
+	// energy below the peer median
+	Seconds      float64 `json:"seconds"`
+	RatioSeconds float64 `json:"ratio_seconds"`
+	LostWh       float64 `json:"lost_wh"`
+}
+
+// shadeDay is one inverter's buckets on one local day, by bucket index.
+type shadeDay struct {
+	Day     time.Time            `json:"day"`
+	Buckets map[int]*shadeBucket `json:"buckets"`
+}
+
+// shadeWindow is a recurring run of shaded buckets.
+type shadeWindow struct {
+	start, end   int // bucket indexes, end exclusive
+	daysShaded   int
+	daysObserved int
+	ratio        float64
+	lostWh       float64
+	lostWhPerDay float64
+}
+
+// ShadeCollector finds times of day when a panel recurrently produces less
+// than its peers, such as a tree or chimney shading it every morning.
+type ShadeCollector struct {
+	config   ShadeConfig
+	loc      *time.Location
+	daylight DaylightFunc
+
+	// lastReport is each inverter's last sampled report date
+	lastReport map[string]int64
+	panels     map[string][]*shadeDay
+	mu         sync.Mutex
+
+	shadedMinutes *prometheus.Desc
+	lostEnergy    *prometheus.Desc
+}
+
+// NewShadeCollector creates a ShadeCollector with time-of-day buckets in loc.
+// Samples are only taken while daylight reports the sun is up; a nil
+// daylight relies on MinMedianWatts alone.
+func NewShadeCollector(config ShadeConfig, loc *time.Location, daylight DaylightFunc) *ShadeCollector {
+	return &ShadeCollector{
+		config:     config,
+		loc:        loc,
+		daylight:   daylight,
+		lastReport: make(map[string]int64),
+		panels:     make(map[string][]*shadeDay),
+		shadedMinutes: prometheus.NewDesc(
+			"enphase_inverter_shaded_minutes_per_day",
+			"Length of the inverter's recurring shade windows in minutes per day",
+			config.Labels.names(),
+			nil,
+		),
+		lostEnergy: prometheus.NewDesc(
+			"enphase_inverter_shade_energy_lost_wh_per_day",
+			"Average energy below the peer median during the inverter's shade windows in watt-hours per day",
+			config.Labels.names(),
+			nil,
+		),
+	}
+}
+
+// bucket returns the local day and time-of-day bucket containing t.
+func (c *ShadeCollector) bucket(t time.Time) (time.Time, int) {
+	day := periodStart(periodDay, t, c.loc)
+	local := t.In(c.loc)
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+	return day, int(sinceMidnight / c.config.BucketSize)
+}
+
+// ObserveInverters implements InverterObserver. Each new report covers the
+// time since the inverter's previous one, like its energy, and is credited to
+// the bucket at the midpoint of that interval. Reports that haven't changed
+// since the last scrape are skipped.
+func (c *ShadeCollector) ObserveInverters(_ time.Time, inverters []client.Inverter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Intervals covered by new reports, by serial number
+	fresh := make(map[string]time.Duration)
+	for _, inv := range inverters {
+		last := c.lastReport[inv.SerialNumber]
+		if inv.LastReportDate <= last {
+			continue
+		}
+		c.lastReport[inv.SerialNumber] = inv.LastReportDate
+		if dt := time.Duration(inv.LastReportDate-last) * time.Second; last != 0 && dt <= maxReportGap {
+			fresh[inv.SerialNumber] = dt
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	byGroup := make(map[string][]client.Inverter)
+	for _, inv := range inverters {
+		g := peerGroup(c.config.Groups, c.config.Labels, inv.SerialNumber)
+		byGroup[g] = append(byGroup[g], inv)
+	}
+	for _, group := range byGroup {
+		// A median of one inverter is itself
+		if len(group) < 2 {
+			continue
+		}
+		watts := make([]float64, len(group))
+		for i, inv := range group {
+			watts[i] = float64(inv.LastReportWatts)
+		}
+		medianW := median(watts)
+		if medianW < c.config.MinMedianWatts || medianW <= 0 {
+			continue
+		}
+		for _, inv := range group {
+			dt, ok := fresh[inv.SerialNumber]
+			if !ok {
+				continue
+			}
+			mid := time.Unix(inv.LastReportDate, 0).Add(-dt / 2)
+			if c.daylight != nil && !c.daylight(mid) {
+				continue
+			}
+			day, index := c.bucket(mid)
+			w := float64(inv.LastReportWatts)
+			b := c.dayFor(inv.SerialNumber, day).bucket(index)
+			b.Seconds += dt.Seconds()
+			b.RatioSeconds += w / medianW * dt.Seconds()
+			b.LostWh += max(medianW-w, 0) * dt.Hours()
+		}
+	}
+}
+
+// dayFor returns the inverter's record for day, creating it and dropping days
+// beyond the history. Must be called with mu held.
+func (c *ShadeCollector) dayFor(serial string, day time.Time) *shadeDay {
+	days := c.panels[serial]
+	if n := len(days); n > 0 && days[n-1].Day.Equal(day) {
+		return days[n-1]
+	}
+	d := &shadeDay{Day: day, Buckets: make(map[int]*shadeBucket)}
+	days = append(days, d)
+	cutoff := day.AddDate(0, 0, -c.config.Days)
+	i := 0
+	for i < len(days) && !days[i].Day.After(cutoff) {
+		i++
+	}
+	c.panels[serial] = days[i:]
+	return d
+}
+
+// bucket returns the day's bucket at index, creating it.
+func (d *shadeDay) bucket(index int) *shadeBucket {
+	b := d.Buckets[index]
+	if b == nil {
+		b = &shadeBucket{}
+		d.Buckets[index] = b
+	}
+	return b
+}
+
+// windows finds the inverter's recurring shade windows. A bucket counts as
+// observed on a day once half of it has been sampled. Must be called with mu
+// held.
+func (c *ShadeCollector) windows(days []*shadeDay) []shadeWindow {
+	buckets := int(24 * time.Hour / c.config.BucketSize)
+	minSeconds := c.config.BucketSize.Seconds() / 2
+
+	type bucketStats struct {
+		observed, shaded      int
+		ratioSeconds, seconds float64
+		lostWh                float64
+	}
+	stats := make([]bucketStats, buckets)
+	shaded := make([]bool, buckets)
+	for i := range stats {
+		s := &stats[i]
+		for _, d := range days {
+			b := d.Buckets[i]
+			if b == nil || b.Seconds < minSeconds {
+				continue
+			}
+			s.observed++
+			s.seconds += b.Seconds
+			s.ratioSeconds += b.RatioSeconds
+			s.lostWh += b.LostWh
+			if b.RatioSeconds/b.Seconds < c.config.Threshold {
+				s.shaded++
+			}
+		}
+		shaded[i] = s.observed >= c.config.MinDays && float64(s.shaded) >= c.config.Recurrence*float64(s.observed)
+	}
+
+	var windows []shadeWindow
+	for i := 0; i < buckets; i++ {
+		if !shaded[i] {
+			continue
+		}
+		w := shadeWindow{start: i, daysShaded: stats[i].shaded}
+		var ratioSeconds, seconds float64
+		for ; i < buckets && shaded[i]; i++ {
+			s := stats[i]
+			w.daysShaded = min(w.daysShaded, s.shaded)
+			w.daysObserved = max(w.daysObserved, s.observed)
+			ratioSeconds += s.ratioSeconds
+			seconds += s.seconds
+			w.lostWh += s.lostWh
+			w.lostWhPerDay += s.lostWh / float64(s.observed)
+		}
+		w.end = i
+		w.ratio = ratioSeconds / seconds
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+// Describe implements prometheus.Collector.
+func (c *ShadeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.shadedMinutes
+	ch <- c.lostEnergy
+}
+
+// Collect implements prometheus.Collector.
+func (c *ShadeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for serial, days := range c.panels {
+		var minutes, lostWh float64
+		for _, w := range c.windows(days) {
+			minutes += float64(w.end-w.start) * c.config.BucketSize.Minutes()
+			lostWh += w.lostWhPerDay
+		}
+		labels := c.config.Labels.values(serial)
+		ch <- prometheus.MustNewConstMetric(
+			c.shadedMinutes,
+			prometheus.GaugeValue,
+			minutes,
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.lostEnergy,
+			prometheus.GaugeValue,
+			lostWh,
+			labels...,
+		)
+	}
+}
+
+// shadingResponse is the JSON served by the shading endpoint.
+type shadingResponse struct {
+	BucketMinutes int            `json:"bucket_minutes"`
+	Days          int            `json:"days"`
+	Panels        []panelShading `json:"panels"`
+}
+
+type panelShading struct {
+	SerialNumber string         `json:"serial_number"`
+	Array        string         `json:"array,omitempty"`
+	Windows      []shadingEntry `json:"windows"`
+}
+
+type shadingEntry struct {
+	Start               string  `json:"start"`
+	End                 string  `json:"end"`
+	Minutes             float64 `json:"minutes"`
+	DaysShaded          int     `json:"days_shaded"`
+	DaysObserved        int     `json:"days_observed"`
+	RelativePerformance float64 `json:"relative_performance"`
+	EnergyLostWhPerDay  float64 `json:"energy_lost_wh_per_day"`
+	EnergyLostWh        float64 `json:"energy_lost_wh"`
+}
+
+// clock formats a bucket boundary as a local time of day.
+func (c *ShadeCollector) clock(index int) string {
+	d := time.Duration(index) * c.config.BucketSize
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// ServeHTTP serves the recurring shade windows of panels that have any as
+// JSON, sorted by serial number.
+func (c *ShadeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	resp := shadingResponse{
+		BucketMinutes: int(c.config.BucketSize.Minutes()),
+		Days:          c.config.Days,
+		Panels:        []panelShading{},
+	}
+	for serial, days := range c.panels {
+		windows := c.windows(days)
+		if len(windows) == 0 {
+			continue
+		}
+		panel := panelShading{
+			SerialNumber: serial,
+			Array:        c.config.Labels.array(serial),
+			Windows:      make([]shadingEntry, len(windows)),
+		}
+		for i, win := range windows {
+			panel.Windows[i] = shadingEntry{
+				Start:               c.clock(win.start),
+				End:                 c.clock(win.end),
+				Minutes:             float64(win.end-win.start) * c.config.BucketSize.Minutes(),
+				DaysShaded:          win.daysShaded,
+				DaysObserved:        win.daysObserved,
+				RelativePerformance: win.ratio,
+				EnergyLostWhPerDay:  win.lostWhPerDay,
+				EnergyLostWh:        win.lostWh,
+			}
+		}
+		resp.Panels = append(resp.Panels, panel)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(resp.Panels, func(i, j int) bool {
+		return resp.Panels[i].SerialNumber < resp.Panels[j].SerialNumber
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		shadeLog.WithError(err).Warn("Failed to write shading report")
+	}
+}
+
+// StateKey implements state.Persister.
+func (c *ShadeCollector) StateKey() string {
+	return "shade"
+}
+
+// SaveState implements state.Persister.
+func (c *ShadeCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(c.panels)
+}
+
+// RestoreState implements state.Persister. Days beyond the history are
+// dropped with the next sample.
+func (c *ShadeCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var panels map[string][]*shadeDay
+	if err := json.Unmarshal(data, &panels); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for serial, days := range panels {
+		for _, d := range days {
+			if d != nil && d.Buckets != nil {
+				c.panels[serial] = append(c.panels[serial], d)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rhwendt/enphase-exporter/internal/solar"
+)
+
+// SunCollector exports the sun's position and today's sunrise, solar noon
+// and sunset for the site, so alerts can follow daylight instead of fixed
+// hours.
+type SunCollector struct {
+	site solar.Site
+	loc  *time.Location
+
+	daylight  *prometheus.Desc
+	sunrise   *prometheus.Desc
+	solarNoon *prometheus.Desc
+	sunset    *prometheus.Desc
+	elevation *prometheus.Desc
+	azimuth   *prometheus.Desc
+}
+
+// NewSunCollector creates a SunCollector. Sun events are for the current
+// calendar day in loc.
+func NewSunCollector(site solar.Site, loc *time.Location) *SunCollector {
+	return &SunCollector{
+		site: site,
+		loc:  loc,
+		daylight: prometheus.NewDesc(
+			"enphase_daylight",
+			"Whether the sun is above the horizon at the site (1 = day, 0 = night)",
+			nil,
+			nil,
+		),
+		sunrise: prometheus.NewDesc(
+			"enphase_sunrise_timestamp",
+			"Unix timestamp of today's sunrise",
+			nil,
+			nil,
+		),
+		solarNoon: prometheus.NewDesc(
+			"enphase_solar_noon_timestamp",
+			"Unix timestamp of today's solar noon",
+			nil,
+			nil,
+		),
+		sunset: prometheus.NewDesc(
+			"enphase_sunset_timestamp",
+			"Unix timestamp of today's sunset",
+			nil,
+			nil,
+		),
+		elevation: prometheus.NewDesc(
+			"enphase_sun_elevation_degrees",
+			"Apparent sun elevation above the horizon in degrees",
+			nil,
+			nil,
+		),
+		azimuth: prometheus.NewDesc(
+			"enphase_sun_azimuth_degrees",
+			"Sun azimuth clockwise from north in degrees",
+			nil,
+			nil,
+		),
+	}
+}
+
+// IsDaylight reports whether the sun is up at the site at t.
+func (c *SunCollector) IsDaylight(t time.Time) bool {
+	return solar.IsDaylight(t, c.site)
+}
+
+// Describe implements prometheus.Collector.
+func (c *SunCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.daylight
+	ch <- c.sunrise
+	ch <- c.solarNoon
+	ch <- c.sunset
+	ch <- c.elevation
+	ch <- c.azimuth
+}
+
+// Collect implements prometheus.Collector.
+func (c *SunCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	pos := solar.SunPosition(now, c.site)
+
+	ch <- prometheus.MustNewConstMetric(
+		c.daylight,
+		prometheus.GaugeValue,
+		boolToFloat(c.IsDaylight(now)),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.elevation,
+		prometheus.GaugeValue,
+		pos.Elevation(),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.azimuth,
+		prometheus.GaugeValue,
+		pos.Azimuth,
+	)
+
+	// Sunrise and sunset don't exist during polar day or night
+	times := solar.SunTimes(now.In(c.loc), c.site)
+	ch <- prometheus.MustNewConstMetric(
+		c.solarNoon,
+		prometheus.GaugeValue,
+		float64(times.SolarNoon.Unix()),
+	)
+	if !times.Sunrise.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			c.sunrise,
+			prometheus.GaugeValue,
+			float64(times.Sunrise.Unix()),
+		)
+	}
+	if !times.Sunset.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			c.sunset,
+			prometheus.GaugeValue,
+			float64(times.Sunset.Unix()),
+		)
+	}
+}
@@ -0,0 +1,211 @@
+package collector
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rhwendt/enphase-exporter/internal/tariff"
+)
+
+// TariffCollector prices energy samples with a time-of-use tariff.
+// Each sample is priced at the rate in effect when it was taken.
+type TariffCollector struct {
+	tariff *tariff.Tariff
+
+	// Running totals in currency units, by tariff period
+	importCost   map[string]float64
+	exportCredit map[string]float64
+	savings      map[string]float64
+	fixedCharges float64
+	lastCharged  time.Time
+	mu           sync.Mutex
+
+	importCostDesc   *prometheus.Desc
+	exportCreditDesc *prometheus.Desc
+	savingsDesc      *prometheus.Desc
+	fixedChargesDesc *prometheus.Desc
+	importRate       *prometheus.Desc
+	exportRate       *prometheus.Desc
+}
+
+// NewTariffCollector creates a TariffCollector for the given tariff.
+func NewTariffCollector(t *tariff.Tariff) *TariffCollector {
+	return &TariffCollector{
+		tariff:       t,
+		importCost:   make(map[string]float64),
+		exportCredit: make(map[string]float64),
+		savings:      make(map[string]float64),
+		importCostDesc: prometheus.NewDesc(
+			"enphase_cost_import_total",
+			"Cost of energy imported from the grid",
+			[]string{"tariff_period"},
+			nil,
+		),
+		exportCreditDesc: prometheus.NewDesc(
+			"enphase_credit_export_total",
+			"Credit earned for energy exported to the grid",
+			[]string{"tariff_period"},
+			nil,
+		),
+		savingsDesc: prometheus.NewDesc(
+			"enphase_savings_total",
+			"Import cost avoided by self-consumed solar plus export credit",
+			[]string{"tariff_period"},
+			nil,
+		),
+		fixedChargesDesc: prometheus.NewDesc(
+			"enphase_cost_fixed_total",
+			"Fixed daily charges accrued while the exporter was running",
+			nil,
+			nil,
+		),
+		importRate: prometheus.NewDesc(
+			"enphase_tariff_import_rate",
+			"Current import rate per kWh",
+			[]string{"season", "tariff_period"},
+			nil,
+		),
+		exportRate: prometheus.NewDesc(
+			"enphase_tariff_export_rate",
+			"Current export rate per kWh",
+			[]string{"season", "tariff_period"},
+			nil,
+		),
+	}
+}
+
+// ObserveEnergy implements EnergyObserver. Savings and grid costs need
+// consumption CTs, so production-only samples are ignored.
+func (c *TariffCollector) ObserveEnergy(s EnergySample) {
+	if !s.HasConsumption {
+		return
+	}
+
+	rate := c.tariff.Lookup(s.Time)
+	credit := s.ExportWh / 1000 * rate.ExportRate
+	selfConsumedWh := s.ProductionWh - s.ExportWh
+	if selfConsumedWh < 0 {
+		selfConsumedWh = 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.importCost[rate.Period] += s.ImportWh / 1000 * rate.ImportRate
+	c.exportCredit[rate.Period] += credit
+	c.savings[rate.Period] += selfConsumedWh/1000*rate.ImportRate + credit
+
+	if day := c.tariff.Day(s.Time); !day.Equal(c.lastCharged) {
+		c.fixedCharges += c.tariff.FixedDailyCharge()
+		c.lastCharged = day
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *TariffCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.importCostDesc
+	ch <- c.exportCreditDesc
+	ch <- c.savingsDesc
+	ch <- c.fixedChargesDesc
+	ch <- c.importRate
+	ch <- c.exportRate
+}
+
+// Collect implements prometheus.Collector.
+func (c *TariffCollector) Collect(ch chan<- prometheus.Metric) {
+	rate := c.tariff.Lookup(time.Now())
+	ch <- prometheus.MustNewConstMetric(
+		c.importRate,
+		prometheus.GaugeValue,
+		rate.ImportRate,
+		rate.Season, rate.Period,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.exportRate,
+		prometheus.GaugeValue,
+		rate.ExportRate,
+		rate.Season, rate.Period,
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Export every period so counters exist before their first use
+	for _, period := range c.tariff.PeriodNames() {
+		ch <- prometheus.MustNewConstMetric(
+			c.importCostDesc,
+			prometheus.CounterValue,
+			c.importCost[period],
+			period,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.exportCreditDesc,
+			prometheus.CounterValue,
+			c.exportCredit[period],
+			period,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.savingsDesc,
+			prometheus.CounterValue,
+			c.savings[period],
+			period,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.fixedChargesDesc,
+		prometheus.CounterValue,
+		c.fixedCharges,
+	)
+}
+
+// tariffState is the persisted form of the tariff totals.
+type tariffState struct {
+	ImportCost   map[string]float64 `json:"import_cost"`
+	ExportCredit map[string]float64 `json:"export_credit"`
+	Savings      map[string]float64 `json:"savings"`
+	FixedCharges float64            `json:"fixed_charges"`
+	LastCharged  time.Time          `json:"last_charged"`
+}
+
+// StateKey implements state.Persister.
+func (c *TariffCollector) StateKey() string {
+	return "tariff"
+}
+
+// SaveState implements state.Persister.
+func (c *TariffCollector) SaveState() (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(tariffState{
+		ImportCost:   c.importCost,
+		ExportCredit: c.exportCredit,
+		Savings:      c.savings,
+		FixedCharges: c.fixedCharges,
+		LastCharged:  c.lastCharged,
+	})
+}
+
+// RestoreState implements state.Persister.
+func (c *TariffCollector) RestoreState(data json.RawMessage, _ time.Time) error {
+	var st tariffState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for period, v := range st.ImportCost {
+		c.importCost[period] = v
+	}
+	for period, v := range st.ExportCredit {
+		c.exportCredit[period] = v
+	}
+	for period, v := range st.Savings {
+		c.savings[period] = v
+	}
+	c.fixedCharges = st.FixedCharges
+	c.lastCharged = st.LastCharged
+	return nil
+}
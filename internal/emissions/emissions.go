@@ -0,0 +1,161 @@
+package emissions
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the grid emission factor configuration. Factors are in kg CO2
+// per kWh. At least one of Factor, Hourly or CSVFile must be set.
+type Config struct {
+	// Factor is a constant factor, and the fallback for hours not covered
+	// by Hourly or the CSV file
+	Factor float64 `mapstructure:"factor"`
+	// Hourly is a 24-entry profile indexed by local hour of day
+	Hourly []float64 `mapstructure:"hourly"`
+	// CSVFile holds marginal factors by hour. The first column is either an
+	// hour of day (0-23) or an RFC 3339 timestamp of the start of an hour;
+	// the second is the factor. A header row is allowed.
+	CSVFile string `mapstructure:"csv_file"`
+}
+
+// Factors looks up the grid emission factor in effect at a point in time.
+type Factors struct {
+	loc      *time.Location
+	constant float64
+	hourly   *[24]float64
+	// Factors for specific hours, keyed by the hour's Unix time
+	dated map[int64]float64
+}
+
+// New validates cfg, loads the CSV file if set and builds Factors evaluated
+// in loc.
+func New(cfg Config, loc *time.Location) (*Factors, error) {
+	f := &Factors{loc: loc, constant: cfg.Factor}
+	if cfg.Factor < 0 {
+		return nil, fmt.Errorf("emission factor must not be negative, got %v", cfg.Factor)
+	}
+
+	if len(cfg.Hourly) > 0 {
+		if len(cfg.Hourly) != 24 {
+			return nil, fmt.Errorf("hourly profile needs 24 factors, got %d", len(cfg.Hourly))
+		}
+		var hourly [24]float64
+		for h, v := range cfg.Hourly {
+			if v < 0 {
+				return nil, fmt.Errorf("hourly factor for hour %d must not be negative", h)
+			}
+			hourly[h] = v
+		}
+		f.hourly = &hourly
+	}
+
+	if cfg.CSVFile != "" {
+		file, err := os.Open(cfg.CSVFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if err := f.loadCSV(file); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.CSVFile, err)
+		}
+	}
+
+	if f.constant == 0 && f.hourly == nil && len(f.dated) == 0 {
+		return nil, fmt.Errorf("no emission factor configured")
+	}
+
+	// Timestamped CSVs with nothing to fall back on use their mean
+	if f.constant == 0 && f.hourly == nil {
+		var sum float64
+		for _, v := range f.dated {
+			sum += v
+		}
+		f.constant = sum / float64(len(f.dated))
+	}
+
+	return f, nil
+}
+
+// loadCSV reads hour-of-day or timestamped factors. Hour-of-day rows must
+// cover all 24 hours.
+func (f *Factors) loadCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var hourly [24]float64
+	var seen [24]bool
+	hours := 0
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 2 {
+			return fmt.Errorf("line %d: expected hour and factor", line)
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return fmt.Errorf("line %d: invalid factor %q", line, record[1])
+		}
+		if value < 0 {
+			return fmt.Errorf("line %d: factor must not be negative", line)
+		}
+
+		key := strings.TrimSpace(record[0])
+		if h, err := strconv.Atoi(key); err == nil {
+			if h < 0 || h > 23 {
+				return fmt.Errorf("line %d: invalid hour %d", line, h)
+			}
+			if !seen[h] {
+				seen[h] = true
+				hours++
+			}
+			hourly[h] = value
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			return fmt.Errorf("line %d: expected an hour (0-23) or RFC 3339 timestamp, got %q", line, key)
+		}
+		if f.dated == nil {
+			f.dated = make(map[int64]float64)
+		}
+		f.dated[at.Truncate(time.Hour).Unix()] = value
+	}
+
+	if hours > 0 {
+		if hours != 24 {
+			return fmt.Errorf("hour-of-day factors cover %d of 24 hours", hours)
+		}
+		f.hourly = &hourly
+	}
+	return nil
+}
+
+// Lookup returns the factor in kg CO2 per kWh at the given time. A timestamped
+// factor for that hour wins over the hourly profile, which wins over the
+// constant factor.
+func (f *Factors) Lookup(at time.Time) float64 {
+	if v, ok := f.dated[at.Truncate(time.Hour).Unix()]; ok {
+		return v
+	}
+	if f.hourly != nil {
+		return f.hourly[at.In(f.loc).Hour()]
+	}
+	return f.constant
+}
@@ -0,0 +1,106 @@
+package emissions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "factors.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	hourly := make([]float64, 24)
+	for h := range hourly {
+		hourly[h] = 0.4
+	}
+	hourly[13] = 0.2
+
+	loc := time.FixedZone("UTC-7", -7*60*60)
+	tests := []struct {
+		name string
+		cfg  Config
+		at   time.Time
+		want float64
+	}{
+		{"constant", Config{Factor: 0.417}, time.Date(2024, 7, 10, 13, 0, 0, 0, loc), 0.417},
+		{"hourly profile uses local hour", Config{Hourly: hourly}, time.Date(2024, 7, 10, 20, 30, 0, 0, time.UTC), 0.2},
+		{"hourly profile other hour", Config{Hourly: hourly}, time.Date(2024, 7, 10, 14, 0, 0, 0, loc), 0.4},
+	}
+	for _, tt := range tests {
+		factors, err := New(tt.cfg, loc)
+		if err != nil {
+			t.Fatalf("%s: New failed: %v", tt.name, err)
+		}
+		if got := factors.Lookup(tt.at); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var hourOfDay strings.Builder
+	hourOfDay.WriteString("hour,kg_per_kwh\n")
+	for h := 0; h < 24; h++ {
+		factor := "0.5"
+		if h == 12 {
+			factor = "0.25"
+		}
+		fmt.Fprintf(&hourOfDay, "%d,%s\n", h, factor)
+	}
+	factors, err := New(Config{CSVFile: writeCSV(t, hourOfDay.String())}, time.UTC)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := factors.Lookup(time.Date(2024, 7, 10, 12, 45, 0, 0, time.UTC)); got != 0.25 {
+		t.Errorf("expected 0.25 at noon, got %v", got)
+	}
+
+	// Timestamped factors fall back to the constant factor, or to their mean
+	dated := "# marginal factors\n2024-07-10T12:00:00Z,0.3\n2024-07-10T13:00:00Z,0.5\n"
+	factors, err = New(Config{Factor: 0.417, CSVFile: writeCSV(t, dated)}, time.UTC)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := factors.Lookup(time.Date(2024, 7, 10, 13, 20, 0, 0, time.UTC)); got != 0.5 {
+		t.Errorf("expected 0.5 at 13:20, got %v", got)
+	}
+	if got := factors.Lookup(time.Date(2024, 7, 10, 14, 0, 0, 0, time.UTC)); got != 0.417 {
+		t.Errorf("expected constant fallback, got %v", got)
+	}
+	factors, err = New(Config{CSVFile: writeCSV(t, dated)}, time.UTC)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := factors.Lookup(time.Date(2024, 7, 11, 0, 0, 0, 0, time.UTC)); got != 0.4 {
+		t.Errorf("expected mean fallback 0.4, got %v", got)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"nothing configured", Config{}},
+		{"negative factor", Config{Factor: -1}},
+		{"short hourly profile", Config{Hourly: []float64{0.4, 0.3}}},
+		{"partial hour-of-day csv", Config{CSVFile: writeCSV(t, "0,0.4\n1,0.3\n")}},
+		{"bad factor", Config{CSVFile: writeCSV(t, "0,0.4\n1,abc\n")}},
+		{"missing file", Config{CSVFile: filepath.Join(t.TempDir(), "missing.csv")}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.cfg, time.UTC); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
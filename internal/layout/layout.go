@@ -0,0 +1,203 @@
+package layout
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Panel is the physical placement of one microinverter and its module.
+type Panel struct {
+	SerialNumber string  `mapstructure:"serial_number"`
+	Array        string  `mapstructure:"array"`
+	RoofFace     string  `mapstructure:"roof_face"`
+	Azimuth      float64 `mapstructure:"azimuth"`
+	Tilt         float64 `mapstructure:"tilt"`
+	String       string  `mapstructure:"string"`
+	Row          int     `mapstructure:"row"`
+	Column       int     `mapstructure:"column"`
+}
+
+// Layout maps inverter serial numbers to their panels.
+type Layout struct {
+	panels map[string]Panel
+	arrays []string
+}
+
+// New validates panels and builds a Layout.
+func New(panels []Panel) (*Layout, error) {
+	l := &Layout{panels: make(map[string]Panel, len(panels))}
+	arrays := map[string]bool{}
+	for i, p := range panels {
+		p.SerialNumber = strings.TrimSpace(p.SerialNumber)
+		if p.SerialNumber == "" {
+			return nil, fmt.Errorf("panel %d has no serial number", i+1)
+		}
+		if _, ok := l.panels[p.SerialNumber]; ok {
+			return nil, fmt.Errorf("serial number %s is mapped more than once", p.SerialNumber)
+		}
+		if p.Array == "" {
+			return nil, fmt.Errorf("serial number %s has no array", p.SerialNumber)
+		}
+		if p.Azimuth < 0 || p.Azimuth >= 360 {
+			return nil, fmt.Errorf("serial number %s: azimuth must be between 0 and 360, got %v", p.SerialNumber, p.Azimuth)
+		}
+		if p.Tilt < 0 || p.Tilt > 90 {
+			return nil, fmt.Errorf("serial number %s: tilt must be between 0 and 90, got %v", p.SerialNumber, p.Tilt)
+		}
+		l.panels[p.SerialNumber] = p
+		if !arrays[p.Array] {
+			arrays[p.Array] = true
+			l.arrays = append(l.arrays, p.Array)
+		}
+	}
+	sort.Strings(l.arrays)
+	return l, nil
+}
+
+// csvColumns are the recognized CSV header names. Only serial_number and
+// array are required.
+var csvColumns = []string{"serial_number", "array", "roof_face", "azimuth", "tilt", "string", "row", "column"}
+
+// ReadCSV reads panels from CSV with a header row naming the columns.
+func ReadCSV(r io.Reader) ([]Panel, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range csvColumns[:2] {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+	for name := range index {
+		if !slices.Contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	var panels []Panel
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return panels, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := index[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		number := func(name string) (float64, error) {
+			if v := field(name); v != "" {
+				n, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return 0, fmt.Errorf("line %d: invalid %s %q", line, name, v)
+				}
+				return n, nil
+			}
+			return 0, nil
+		}
+		integer := func(name string) (int, error) {
+			if v := field(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return 0, fmt.Errorf("line %d: invalid %s %q", line, name, v)
+				}
+				return n, nil
+			}
+			return 0, nil
+		}
+
+		p := Panel{
+			SerialNumber: field("serial_number"),
+			Array:        field("array"),
+			RoofFace:     field("roof_face"),
+			String:       field("string"),
+		}
+		if p.Azimuth, err = number("azimuth"); err != nil {
+			return nil, err
+		}
+		if p.Tilt, err = number("tilt"); err != nil {
+			return nil, err
+		}
+		if p.Row, err = integer("row"); err != nil {
+			return nil, err
+		}
+		if p.Column, err = integer("column"); err != nil {
+			return nil, err
+		}
+		panels = append(panels, p)
+	}
+}
+
+// LoadCSV reads panels from a CSV file.
+func LoadCSV(path string) ([]Panel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	panels, err := ReadCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return panels, nil
+}
+
+// Lookup returns the panel for a serial number.
+func (l *Layout) Lookup(serial string) (Panel, bool) {
+	p, ok := l.panels[serial]
+	return p, ok
+}
+
+// Arrays returns the array names in sorted order.
+func (l *Layout) Arrays() []string {
+	return l.arrays
+}
+
+// Serials returns the mapped serial numbers in sorted order.
+func (l *Layout) Serials() []string {
+	serials := make([]string, 0, len(l.panels))
+	for s := range l.panels {
+		serials = append(serials, s)
+	}
+	sort.Strings(serials)
+	return serials
+}
+
+// Check compares reporting serial numbers with the layout. Unmapped serials
+// report but aren't in the layout; missing ones are mapped but didn't report.
+func (l *Layout) Check(serials []string) (unmapped, missing []string) {
+	reporting := make(map[string]bool, len(serials))
+	for _, s := range serials {
+		reporting[s] = true
+		if _, ok := l.panels[s]; !ok {
+			unmapped = append(unmapped, s)
+		}
+	}
+	for s := range l.panels {
+		if !reporting[s] {
+			missing = append(missing, s)
+		}
+	}
+	sort.Strings(unmapped)
+	sort.Strings(missing)
+	return unmapped, missing
+}
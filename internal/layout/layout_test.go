@@ -0,0 +1,92 @@
+package layout
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	csv := `serial_number,array,roof_face,azimuth,tilt,string,row,column
+# south roof
+482212345678,south,main,180,25,A,1,1
+482212345679, south, main, 180, 25, A, 1, 2
+482212345680,west,garage,270,15,B,1,1
+`
+	panels, err := ReadCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("ReadCSV failed: %v", err)
+	}
+	if len(panels) != 3 {
+		t.Fatalf("expected 3 panels, got %d", len(panels))
+	}
+	want := Panel{SerialNumber: "482212345679", Array: "south", RoofFace: "main", Azimuth: 180, Tilt: 25, String: "A", Row: 1, Column: 2}
+	if panels[1] != want {
+		t.Errorf("got %+v, want %+v", panels[1], want)
+	}
+
+	l, err := New(panels)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got := l.Arrays(); !reflect.DeepEqual(got, []string{"south", "west"}) {
+		t.Errorf("unexpected arrays %v", got)
+	}
+	if p, ok := l.Lookup("482212345680"); !ok || p.Array != "west" {
+		t.Errorf("unexpected lookup %+v (ok=%v)", p, ok)
+	}
+
+	// Only serial number and array are required
+	panels, err = ReadCSV(strings.NewReader("array,serial_number\nsouth,1\n"))
+	if err != nil || len(panels) != 1 || panels[0].SerialNumber != "1" {
+		t.Errorf("unexpected minimal CSV result %+v (err=%v)", panels, err)
+	}
+}
+
+func TestReadCSV_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{"missing array column", "serial_number,roof_face\n1,main\n"},
+		{"unknown column", "serial_number,array,colour\n1,south,blue\n"},
+		{"bad azimuth", "serial_number,array,azimuth\n1,south,south\n"},
+		{"fractional row", "serial_number,array,row\n1,south,1.5\n"},
+	}
+	for _, tt := range tests {
+		if _, err := ReadCSV(strings.NewReader(tt.csv)); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		panels []Panel
+	}{
+		{"no serial", []Panel{{Array: "south"}}},
+		{"no array", []Panel{{SerialNumber: "1"}}},
+		{"duplicate", []Panel{{SerialNumber: "1", Array: "south"}, {SerialNumber: "1", Array: "west"}}},
+		{"bad tilt", []Panel{{SerialNumber: "1", Array: "south", Tilt: 120}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.panels); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	l, err := New([]Panel{{SerialNumber: "1", Array: "south"}, {SerialNumber: "2", Array: "south"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Serials(); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("unexpected serials %v", got)
+	}
+	unmapped, missing := l.Check([]string{"1", "3"})
+	if !reflect.DeepEqual(unmapped, []string{"3"}) || !reflect.DeepEqual(missing, []string{"2"}) {
+		t.Errorf("got unmapped %v, missing %v", unmapped, missing)
+	}
+}
@@ -0,0 +1,272 @@
+package solar
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Site is the location of the installation.
+type Site struct {
+	Latitude  float64 // degrees, north positive
+	Longitude float64 // degrees, east positive
+	Elevation float64 // meters above sea level
+}
+
+// Validate checks the site coordinates.
+func (s Site) Validate() error {
+	if s.Latitude < -90 || s.Latitude > 90 {
+		return fmt.Errorf("latitude must be between -90 and 90, got %v", s.Latitude)
+	}
+	if s.Longitude < -180 || s.Longitude > 180 {
+		return fmt.Errorf("longitude must be between -180 and 180, got %v", s.Longitude)
+	}
+	return nil
+}
+
+// Position is the apparent position of the sun, including atmospheric
+// refraction.
+type Position struct {
+	Zenith  float64 // degrees from vertical
+	Azimuth float64 // degrees clockwise from north
+}
+
+// Elevation returns the sun's angle above the horizon in degrees.
+func (p Position) Elevation() float64 {
+	return 90 - p.Zenith
+}
+
+func sin(deg float64) float64 { return math.Sin(deg * math.Pi / 180) }
+func cos(deg float64) float64 { return math.Cos(deg * math.Pi / 180) }
+func tan(deg float64) float64 { return math.Tan(deg * math.Pi / 180) }
+func asin(x float64) float64  { return math.Asin(x) * 180 / math.Pi }
+func acos(x float64) float64  { return math.Acos(clamp(x, -1, 1)) * 180 / math.Pi }
+
+func clamp(x, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, x))
+}
+
+// julianCentury returns Julian centuries since J2000.0.
+func julianCentury(t time.Time) float64 {
+	jd := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	return (jd - 2451545) / 36525
+}
+
+// sunDeclination returns the sun's declination in degrees and the equation
+// of time in minutes, following the NOAA solar calculator.
+func sunDeclination(t time.Time) (float64, float64) {
+	jc := julianCentury(t)
+
+	meanLong := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	meanAnomaly := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	eccentricity := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+	center := sin(meanAnomaly)*(1.914602-jc*(0.004817+0.000014*jc)) +
+		sin(2*meanAnomaly)*(0.019993-0.000101*jc) +
+		sin(3*meanAnomaly)*0.000289
+	omega := 125.04 - 1934.136*jc
+	apparentLong := meanLong + center - 0.00569 - 0.00478*sin(omega)
+
+	meanObliquity := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliquity := meanObliquity + 0.00256*cos(omega)
+	declination := asin(sin(obliquity) * sin(apparentLong))
+
+	y := tan(obliquity/2) * tan(obliquity/2)
+	eqTime := 4 * 180 / math.Pi * (y*sin(2*meanLong) -
+		2*eccentricity*sin(meanAnomaly) +
+		4*eccentricity*y*sin(meanAnomaly)*cos(2*meanLong) -
+		0.5*y*y*sin(4*meanLong) -
+		1.25*eccentricity*eccentricity*sin(2*meanAnomaly))
+
+	return declination, eqTime
+}
+
+// SunPosition returns the apparent sun position at t for the site, using the
+// NOAA solar position algorithm. It is accurate to about 0.05 degrees for
+// years 1800-2100.
+func SunPosition(t time.Time, site Site) Position {
+	pos := geometricPosition(t, site)
+	pos.Zenith -= refraction(90 - pos.Zenith)
+	return pos
+}
+
+// geometricPosition returns the sun position without atmospheric refraction.
+func geometricPosition(t time.Time, site Site) Position {
+	declination, eqTime := sunDeclination(t)
+
+	utc := t.UTC()
+	minutes := float64(utc.Hour()*60+utc.Minute()) + float64(utc.Second())/60 + float64(utc.Nanosecond())/6e10
+	trueSolarTime := math.Mod(minutes+eqTime+4*site.Longitude, 1440)
+	if trueSolarTime < 0 {
+		trueSolarTime += 1440
+	}
+	hourAngle := trueSolarTime/4 - 180
+
+	lat := site.Latitude
+	zenith := acos(sin(lat)*sin(declination) + cos(lat)*cos(declination)*cos(hourAngle))
+
+	var azimuth float64
+	if denom := cos(lat) * sin(zenith); math.Abs(denom) > 1e-9 {
+		a := acos((sin(lat)*cos(zenith) - sin(declination)) / denom)
+		if hourAngle > 0 {
+			azimuth = math.Mod(a+180, 360)
+		} else {
+			azimuth = math.Mod(540-a, 360)
+		}
+	} else if lat > 0 {
+		azimuth = 180 // sun at the zenith or a pole
+	}
+
+	return Position{Zenith: zenith, Azimuth: azimuth}
+}
+
+// refraction returns the atmospheric refraction correction in degrees for a
+// geometric elevation in degrees.
+func refraction(elevation float64) float64 {
+	var arcsec float64
+	switch {
+	case elevation > 85:
+		return 0
+	case elevation > 5:
+		t := tan(elevation)
+		arcsec = 58.1/t - 0.07/(t*t*t) + 0.000086/math.Pow(t, 5)
+	case elevation > -0.575:
+		e := elevation
+		arcsec = 1735 + e*(-518.2+e*(103.4+e*(-12.79+e*0.711)))
+	default:
+		arcsec = -20.772 / tan(elevation)
+	}
+	return arcsec / 3600
+}
+
+// sunriseZenith is the geometric zenith of the sun's center at sunrise and
+// sunset: the upper limb on the horizon, with standard refraction.
+const sunriseZenith = 90.833
+
+// IsDaylight reports whether the sun is above the horizon at t.
+func IsDaylight(t time.Time, site Site) bool {
+	return geometricPosition(t, site).Zenith < sunriseZenith
+}
+
+// Times are the sun events for one local day. Sunrise and Sunset are zero
+// when the sun doesn't rise or set that day; AlwaysUp tells polar day from
+// polar night.
+type Times struct {
+	Sunrise   time.Time
+	SolarNoon time.Time
+	Sunset    time.Time
+	AlwaysUp  bool
+}
+
+// SunTimes returns sunrise, solar noon and sunset on the calendar day of date
+// in date's location.
+func SunTimes(date time.Time, site Site) Times {
+	y, m, d := date.Date()
+	midnightUTC := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	atMinutes := func(minutes float64) time.Time {
+		return midnightUTC.Add(time.Duration(minutes * float64(time.Minute))).In(date.Location())
+	}
+
+	// Solar noon moves by the equation of time, so refine from a first guess
+	noonMinutes := 720 - 4*site.Longitude
+	for i := 0; i < 2; i++ {
+		_, eqTime := sunDeclination(atMinutes(noonMinutes))
+		noonMinutes = 720 - 4*site.Longitude - eqTime
+	}
+	times := Times{SolarNoon: atMinutes(noonMinutes)}
+
+	// eventMinutes finds sunrise (-1) or sunset (+1), refining the hour angle
+	// at the event time
+	eventMinutes := func(sign float64) (float64, bool) {
+		minutes := noonMinutes
+		for i := 0; i < 3; i++ {
+			declination, eqTime := sunDeclination(atMinutes(minutes))
+			arg := cos(sunriseZenith)/(cos(site.Latitude)*cos(declination)) - tan(site.Latitude)*tan(declination)
+			if arg < -1 || arg > 1 {
+				times.AlwaysUp = arg < -1
+				return 0, false
+			}
+			minutes = 720 - 4*(site.Longitude-sign*acos(arg)) - eqTime
+		}
+		return minutes, true
+	}
+	if minutes, ok := eventMinutes(-1); ok {
+		times.Sunrise = atMinutes(minutes)
+	}
+	if minutes, ok := eventMinutes(1); ok {
+		times.Sunset = atMinutes(minutes)
+	}
+	return times
+}
+
+// Irradiance is solar irradiance in W/m².
+type Irradiance struct {
+	GHI float64 // global horizontal
+	DNI float64 // direct normal
+	DHI float64 // diffuse horizontal
+}
+
+// ClearSky estimates cloudless irradiance for a sun position and site
+// elevation using the Meinel direct beam model with the Laue elevation
+// correction. Diffuse irradiance is taken as 10% of the beam. This is a
+// simple model meant for relative comparisons, not resource assessment.
+func ClearSky(pos Position, elevation float64) Irradiance {
+	if pos.Zenith >= 90 {
+		return Irradiance{}
+	}
+
+	// Kasten-Young air mass
+	airMass := 1 / (cos(pos.Zenith) + 0.50572*math.Pow(96.07995-pos.Zenith, -1.6364))
+	h := math.Max(elevation, 0) / 1000
+	dni := 1353 * ((1-0.14*h)*math.Pow(0.7, math.Pow(airMass, 0.678)) + 0.14*h)
+	dhi := 0.1 * dni
+	return Irradiance{
+		GHI: dni*cos(pos.Zenith) + dhi,
+		DNI: dni,
+		DHI: dhi,
+	}
+}
+
+// groundAlbedo is the reflectance of the ground for plane-of-array irradiance.
+const groundAlbedo = 0.2
+
+// PlaneOfArray returns the irradiance on a surface with the given tilt from
+// horizontal and azimuth clockwise from north, using an isotropic sky.
+func PlaneOfArray(pos Position, irr Irradiance, tilt, azimuth float64) float64 {
+	cosIncidence := cos(pos.Zenith)*cos(tilt) + sin(pos.Zenith)*sin(tilt)*cos(pos.Azimuth-azimuth)
+	beam := irr.DNI * math.Max(cosIncidence, 0)
+	sky := irr.DHI * (1 + cos(tilt)) / 2
+	ground := irr.GHI * groundAlbedo * (1 - cos(tilt)) / 2
+	return beam + sky + ground
+}
+
+// Array is a group of panels sharing an orientation.
+type Array struct {
+	Tilt            float64 // degrees from horizontal
+	Azimuth         float64 // degrees clockwise from north (180 faces south)
+	DCCapacityWatts float64 // nameplate DC capacity
+	Losses          float64 // fraction lost to inverters, wiring, soiling and heat
+}
+
+// Validate checks the array configuration.
+func (a Array) Validate() error {
+	if a.Tilt < 0 || a.Tilt > 90 {
+		return fmt.Errorf("tilt must be between 0 and 90 degrees, got %v", a.Tilt)
+	}
+	if a.Azimuth < 0 || a.Azimuth >= 360 {
+		return fmt.Errorf("azimuth must be between 0 and 360 degrees, got %v", a.Azimuth)
+	}
+	if a.DCCapacityWatts <= 0 {
+		return fmt.Errorf("DC capacity must be positive, got %v", a.DCCapacityWatts)
+	}
+	if a.Losses < 0 || a.Losses >= 1 {
+		return fmt.Errorf("losses must be a fraction between 0 and 1, got %v", a.Losses)
+	}
+	return nil
+}
+
+// ExpectedWatts returns the array's expected AC output under clear skies
+// with the sun at pos.
+func (a Array) ExpectedWatts(pos Position, site Site) float64 {
+	poa := PlaneOfArray(pos, ClearSky(pos, site.Elevation), a.Tilt, a.Azimuth)
+	return a.DCCapacityWatts * poa / 1000 * (1 - a.Losses)
+}
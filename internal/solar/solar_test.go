@@ -0,0 +1,174 @@
+package solar
+
+import (
+	"math"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestSunPosition(t *testing.T) {
+	tests := []struct {
+		name            string
+		at              time.Time
+		site            Site
+		zenith, azimuth float64
+	}{
+		// NREL SPA reference example (Reda & Andreas, 2008, Table A5.1)
+		{
+			"SPA reference",
+			time.Date(2003, 10, 17, 12, 30, 30, 0, time.FixedZone("MST", -7*60*60)),
+			Site{Latitude: 39.742476, Longitude: -105.1786, Elevation: 1830.14},
+			50.11162, 194.34024,
+		},
+		// Solar noon on the June solstice at Greenwich: 90 - 51.48 + 23.44
+		{
+			"Greenwich solstice noon",
+			time.Date(2024, 6, 20, 12, 2, 0, 0, time.UTC),
+			Site{Latitude: 51.4769, Longitude: 0},
+			28.04, 180,
+		},
+		// Sun over the Tropic of Capricorn at the December solstice
+		{
+			"Capricorn solstice noon",
+			time.Date(2024, 12, 21, 11, 58, 0, 0, time.UTC),
+			Site{Latitude: -23.44, Longitude: 0},
+			0, -1,
+		},
+	}
+	for _, tt := range tests {
+		pos := SunPosition(tt.at, tt.site)
+		if math.Abs(pos.Zenith-tt.zenith) > 0.1 {
+			t.Errorf("%s: zenith %.4f, want %.4f", tt.name, pos.Zenith, tt.zenith)
+		}
+		if tt.azimuth >= 0 && math.Abs(pos.Azimuth-tt.azimuth) > 0.5 {
+			t.Errorf("%s: azimuth %.4f, want %.4f", tt.name, pos.Azimuth, tt.azimuth)
+		}
+	}
+
+	// Morning sun is in the east, evening sun in the west
+	site := Site{Latitude: 40, Longitude: -105}
+	if az := SunPosition(time.Date(2024, 3, 20, 15, 0, 0, 0, time.UTC), site).Azimuth; az < 90 || az > 150 {
+		t.Errorf("expected morning azimuth in the east, got %.2f", az)
+	}
+	if az := SunPosition(time.Date(2024, 3, 20, 23, 0, 0, 0, time.UTC), site).Azimuth; az < 210 || az > 270 {
+		t.Errorf("expected afternoon azimuth in the west, got %.2f", az)
+	}
+}
+
+func TestClearSky(t *testing.T) {
+	// Sun overhead at sea level is one air mass: 1353 * 0.7 W/m² direct
+	irr := ClearSky(Position{Zenith: 0}, 0)
+	if math.Abs(irr.DNI-947.1) > 0.5 || math.Abs(irr.GHI-irr.DNI*1.1) > 1e-9 {
+		t.Errorf("unexpected overhead irradiance %+v", irr)
+	}
+	if high := ClearSky(Position{Zenith: 0}, 2000); high.DNI <= irr.DNI {
+		t.Errorf("expected more irradiance at altitude, got %v <= %v", high.DNI, irr.DNI)
+	}
+	if night := ClearSky(Position{Zenith: 95}, 0); night != (Irradiance{}) {
+		t.Errorf("expected no irradiance at night, got %+v", night)
+	}
+
+	// A panel facing the sun gets the full beam; one facing away only diffuse
+	pos := Position{Zenith: 40, Azimuth: 180}
+	irr = ClearSky(pos, 0)
+	facing := PlaneOfArray(pos, irr, 40, 180)
+	away := PlaneOfArray(pos, irr, 40, 0)
+	if math.Abs(facing-(irr.DNI+irr.DHI*(1+cos(40))/2+irr.GHI*groundAlbedo*(1-cos(40))/2)) > 1e-9 {
+		t.Errorf("unexpected facing POA %v", facing)
+	}
+	if away >= irr.DNI*0.5 {
+		t.Errorf("expected a north-facing panel to see little irradiance, got %v", away)
+	}
+}
+
+func TestArray(t *testing.T) {
+	array := Array{Tilt: 0, Azimuth: 180, DCCapacityWatts: 10000, Losses: 0.14}
+	if err := array.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pos := Position{Zenith: 0}
+	want := 10000 * ClearSky(pos, 0).GHI / 1000 * 0.86
+	if got := array.ExpectedWatts(pos, Site{}); math.Abs(got-want) > 1e-9 {
+		t.Errorf("expected %v W, got %v", want, got)
+	}
+
+	for _, bad := range []Array{
+		{Tilt: 95, DCCapacityWatts: 1000},
+		{Azimuth: 360, DCCapacityWatts: 1000},
+		{DCCapacityWatts: 0},
+		{DCCapacityWatts: 1000, Losses: 1},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", bad)
+		}
+	}
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestSunTimes(t *testing.T) {
+	denver := mustLoad(t, "America/Denver")
+	london := mustLoad(t, "Europe/London")
+	sydney := mustLoad(t, "Australia/Sydney")
+
+	// Published sunrise, solar noon and sunset times, to the minute
+	tests := []struct {
+		name                  string
+		date                  time.Time
+		site                  Site
+		sunrise, noon, sunset string
+	}{
+		{"Denver summer solstice", time.Date(2024, 6, 20, 0, 0, 0, 0, denver), Site{Latitude: 39.7392, Longitude: -104.9903}, "05:32", "13:01", "20:31"},
+		{"London winter solstice", time.Date(2024, 12, 21, 0, 0, 0, 0, london), Site{Latitude: 51.5074, Longitude: -0.1278}, "08:04", "11:58", "15:53"},
+		{"Sydney new year", time.Date(2024, 1, 1, 0, 0, 0, 0, sydney), Site{Latitude: -33.8688, Longitude: 151.2093}, "05:47", "12:58", "20:09"},
+	}
+	for _, tt := range tests {
+		times := SunTimes(tt.date, tt.site)
+		for _, ev := range []struct {
+			name string
+			at   time.Time
+			want string
+		}{{"sunrise", times.Sunrise, tt.sunrise}, {"noon", times.SolarNoon, tt.noon}, {"sunset", times.Sunset, tt.sunset}} {
+			if got := ev.at.Format("15:04"); got != ev.want {
+				t.Errorf("%s %s: got %s, want %s", tt.name, ev.name, got, ev.want)
+			}
+			if y, m, d := ev.at.Date(); y != tt.date.Year() || m != tt.date.Month() || d != tt.date.Day() {
+				t.Errorf("%s %s: on %v, want the same local day", tt.name, ev.name, ev.at)
+			}
+		}
+	}
+
+	// Midnight sun and polar night in Tromsø
+	tromso := Site{Latitude: 69.65, Longitude: 18.96}
+	if times := SunTimes(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), tromso); !times.Sunrise.IsZero() || !times.AlwaysUp {
+		t.Errorf("expected midnight sun, got %+v", times)
+	}
+	if times := SunTimes(time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), tromso); !times.Sunset.IsZero() || times.AlwaysUp {
+		t.Errorf("expected polar night, got %+v", times)
+	}
+}
+
+func TestIsDaylight(t *testing.T) {
+	denver := mustLoad(t, "America/Denver")
+	site := Site{Latitude: 39.7392, Longitude: -104.9903}
+
+	// 06:30 is daylight in June but dark in December, which a fixed hour
+	// window gets wrong
+	if !IsDaylight(time.Date(2024, 6, 20, 6, 30, 0, 0, denver), site) {
+		t.Error("expected daylight at 06:30 in June")
+	}
+	if IsDaylight(time.Date(2024, 12, 21, 6, 30, 0, 0, denver), site) {
+		t.Error("expected darkness at 06:30 in December")
+	}
+	if IsDaylight(time.Date(2024, 6, 20, 21, 0, 0, 0, denver), site) {
+		t.Error("expected darkness after sunset")
+	}
+}
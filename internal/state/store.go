@@ -0,0 +1,183 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var stateLog = logrus.WithField("component", "state")
+
+// fileVersion is bumped if the on-disk layout changes incompatibly.
+const fileVersion = 1
+
+// Persister is implemented by components whose exporter-computed values
+// should survive restarts.
+type Persister interface {
+	// StateKey identifies the component in the state file.
+	StateKey() string
+	// SaveState returns the component's state as JSON.
+	SaveState() (json.RawMessage, error)
+	// RestoreState loads previously saved state. savedAt is when the state
+	// was written, so components can avoid integrating across long downtime.
+	RestoreState(data json.RawMessage, savedAt time.Time) error
+}
+
+// stateFile is the on-disk representation of all component state.
+type stateFile struct {
+	Version    int                        `json:"version"`
+	SavedAt    time.Time                  `json:"saved_at"`
+	Components map[string]json.RawMessage `json:"components"`
+}
+
+// Store persists registered components to a single JSON file.
+type Store struct {
+	path       string
+	persisters []Persister
+	mu         sync.Mutex
+
+	stopSave chan struct{}
+	done     chan struct{}
+}
+
+// NewStore creates a Store backed by the file at path.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Register adds a component to be saved and restored.
+func (s *Store) Register(p Persister) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persisters = append(s.persisters, p)
+}
+
+// Load restores all registered components from the state file.
+// A missing file is not an error; components simply start fresh.
+func (s *Store) Load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		stateLog.WithField("path", s.path).Info("No saved state found, starting fresh")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to decode state file: %w", err)
+	}
+	if file.Version != fileVersion {
+		return fmt.Errorf("unsupported state file version %d", file.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.persisters {
+		raw, ok := file.Components[p.StateKey()]
+		if !ok {
+			continue
+		}
+		if err := p.RestoreState(raw, file.SavedAt); err != nil {
+			// One bad component shouldn't discard the others
+			stateLog.WithError(err).WithField("key", p.StateKey()).Warn("Failed to restore component state")
+			continue
+		}
+	}
+
+	stateLog.WithFields(logrus.Fields{
+		"path":     s.path,
+		"saved_at": file.SavedAt.Format(time.RFC3339),
+		"age":      time.Since(file.SavedAt).Round(time.Second),
+	}).Info("Restored exporter state")
+	return nil
+}
+
+// Save writes all registered components to the state file atomically by
+// writing a temporary file in the same directory and renaming it into place.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := stateFile{
+		Version:    fileVersion,
+		SavedAt:    time.Now(),
+		Components: make(map[string]json.RawMessage, len(s.persisters)),
+	}
+	for _, p := range s.persisters {
+		raw, err := p.SaveState()
+		if err != nil {
+			return fmt.Errorf("failed to save %s state: %w", p.StateKey(), err)
+		}
+		file.Components[p.StateKey()] = raw
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// StartPeriodicSave saves state in the background every interval.
+func (s *Store) StartPeriodicSave(interval time.Duration) {
+	s.stopSave = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Save(); err != nil {
+					stateLog.WithError(err).Error("Failed to save exporter state")
+				}
+			case <-s.stopSave:
+				return
+			}
+		}
+	}()
+
+	stateLog.WithField("interval", interval).Info("Started periodic state saving")
+}
+
+// StopPeriodicSave stops the background goroutine and waits for any
+// in-flight save to finish.
+func (s *Store) StopPeriodicSave() {
+	if s.stopSave != nil {
+		close(s.stopSave)
+		<-s.done
+	}
+}
@@ -0,0 +1,88 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// counter is a minimal Persister for testing
+type counter struct {
+	key     string
+	value   float64
+	savedAt time.Time
+}
+
+func (c *counter) StateKey() string { return c.key }
+
+func (c *counter) SaveState() (json.RawMessage, error) {
+	return json.Marshal(c.value)
+}
+
+func (c *counter) RestoreState(data json.RawMessage, savedAt time.Time) error {
+	c.savedAt = savedAt
+	return json.Unmarshal(data, &c.value)
+}
+
+func TestStore_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store := NewStore(path)
+	store.Register(&counter{key: "a", value: 42.5})
+	store.Register(&counter{key: "b", value: 7})
+	before := time.Now()
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// No temporary files should be left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file, got %d entries", len(entries))
+	}
+
+	restoredA := &counter{key: "a"}
+	restoredC := &counter{key: "c", value: 3}
+	reloaded := NewStore(path)
+	reloaded.Register(restoredA)
+	reloaded.Register(restoredC)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if restoredA.value != 42.5 {
+		t.Errorf("expected a=42.5, got %v", restoredA.value)
+	}
+	if restoredA.savedAt.Before(before.Add(-time.Second)) {
+		t.Errorf("unexpected savedAt %v", restoredA.savedAt)
+	}
+	// Components missing from the file keep their initial state
+	if restoredC.value != 3 {
+		t.Errorf("expected c to be untouched, got %v", restoredC.value)
+	}
+}
+
+func TestStore_LoadMissingFile(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "missing.json"))
+	store.Register(&counter{key: "a"})
+	if err := store.Load(); err != nil {
+		t.Errorf("expected missing file to be ignored, got %v", err)
+	}
+}
+
+func TestStore_LoadCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewStore(path)
+	if err := store.Load(); err == nil {
+		t.Error("expected error for corrupt state file")
+	}
+}
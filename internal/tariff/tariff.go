@@ -0,0 +1,236 @@
+package tariff
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultPeriod is the period name used when no configured period matches.
+const DefaultPeriod = "off-peak"
+
+// Config is the YAML tariff configuration. Rates are per kWh in the
+// utility's currency.
+type Config struct {
+	// FixedDailyCharge is charged once per local day regardless of usage
+	FixedDailyCharge float64  `mapstructure:"fixed_daily_charge"`
+	Seasons          []Season `mapstructure:"seasons"`
+}
+
+// Season applies to a set of months. A season without months covers the whole year.
+type Season struct {
+	Name   string `mapstructure:"name"`
+	Months []int  `mapstructure:"months"`
+
+	// Rates for times not covered by any period
+	ImportRate float64 `mapstructure:"import_rate"`
+	ExportRate float64 `mapstructure:"export_rate"`
+
+	Periods []Period `mapstructure:"periods"`
+}
+
+// Period is a time-of-use window within a season.
+type Period struct {
+	Name string `mapstructure:"name"`
+	// Days is any of "all", "weekday", "weekend" or a day name ("mon".."sun").
+	// Empty means every day.
+	Days []string `mapstructure:"days"`
+	// Start and End are local wall-clock times ("16:00"). End may be "24:00";
+	// windows that wrap past midnight ("22:00"-"06:00") are allowed.
+	Start      string  `mapstructure:"start"`
+	End        string  `mapstructure:"end"`
+	ImportRate float64 `mapstructure:"import_rate"`
+	ExportRate float64 `mapstructure:"export_rate"`
+}
+
+// Rate is the tariff in effect at a point in time.
+type Rate struct {
+	Season     string
+	Period     string
+	ImportRate float64
+	ExportRate float64
+}
+
+// Tariff is a validated tariff schedule in a local timezone.
+type Tariff struct {
+	loc              *time.Location
+	fixedDailyCharge float64
+	seasons          [13]*season // indexed by time.Month
+	periodNames      []string
+}
+
+type season struct {
+	name       string
+	importRate float64
+	exportRate float64
+	periods    []period
+}
+
+type period struct {
+	name       string
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since local midnight
+	importRate float64
+	exportRate float64
+}
+
+// New validates cfg and builds a Tariff evaluated in loc.
+func New(cfg Config, loc *time.Location) (*Tariff, error) {
+	if len(cfg.Seasons) == 0 {
+		return nil, fmt.Errorf("tariff has no seasons")
+	}
+
+	t := &Tariff{loc: loc, fixedDailyCharge: cfg.FixedDailyCharge}
+	names := map[string]bool{}
+	addName := func(name string) {
+		if !names[name] {
+			names[name] = true
+			t.periodNames = append(t.periodNames, name)
+		}
+	}
+
+	for i, sc := range cfg.Seasons {
+		name := sc.Name
+		if name == "" {
+			name = fmt.Sprintf("season-%d", i+1)
+		}
+		s := &season{name: name, importRate: sc.ImportRate, exportRate: sc.ExportRate}
+		addName(DefaultPeriod)
+
+		for _, pc := range sc.Periods {
+			p, err := parsePeriod(pc)
+			if err != nil {
+				return nil, fmt.Errorf("season %q: %w", name, err)
+			}
+			s.periods = append(s.periods, p)
+			addName(p.name)
+		}
+
+		months := sc.Months
+		if len(months) == 0 {
+			months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+		}
+		for _, m := range months {
+			if m < 1 || m > 12 {
+				return nil, fmt.Errorf("season %q: invalid month %d", name, m)
+			}
+			if t.seasons[m] != nil {
+				return nil, fmt.Errorf("season %q: month %d already covered by season %q", name, m, t.seasons[m].name)
+			}
+			t.seasons[m] = s
+		}
+	}
+
+	for m := time.January; m <= time.December; m++ {
+		if t.seasons[m] == nil {
+			return nil, fmt.Errorf("no season covers %s", m)
+		}
+	}
+
+	return t, nil
+}
+
+func parsePeriod(pc Period) (period, error) {
+	if pc.Name == "" {
+		return period{}, fmt.Errorf("period without a name")
+	}
+	p := period{name: pc.Name, importRate: pc.ImportRate, exportRate: pc.ExportRate}
+
+	var err error
+	if p.start, err = parseClock(pc.Start); err != nil {
+		return period{}, fmt.Errorf("period %q start: %w", pc.Name, err)
+	}
+	if p.end, err = parseClock(pc.End); err != nil {
+		return period{}, fmt.Errorf("period %q end: %w", pc.Name, err)
+	}
+	if p.start == p.end {
+		return period{}, fmt.Errorf("period %q is empty", pc.Name)
+	}
+
+	days := pc.Days
+	if len(days) == 0 {
+		days = []string{"all"}
+	}
+	for _, d := range days {
+		switch strings.ToLower(d) {
+		case "all":
+			p.days = [7]bool{true, true, true, true, true, true, true}
+		case "weekday", "weekdays":
+			for wd := time.Monday; wd <= time.Friday; wd++ {
+				p.days[wd] = true
+			}
+		case "weekend", "weekends":
+			p.days[time.Saturday] = true
+			p.days[time.Sunday] = true
+		default:
+			wd, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return period{}, fmt.Errorf("period %q: unknown day %q", pc.Name, d)
+			}
+			p.days[wd] = true
+		}
+	}
+
+	return p, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock parses "HH:MM" into minutes since midnight. "24:00" is allowed.
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Lookup returns the rate in effect at the given time. Periods match on local wall-clock
+// time, so a "16:00" boundary stays at 16:00 across DST transitions.
+func (t *Tariff) Lookup(at time.Time) Rate {
+	local := at.In(t.loc)
+	s := t.seasons[local.Month()]
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, p := range s.periods {
+		if p.matches(local.Weekday(), minute) {
+			return Rate{Season: s.name, Period: p.name, ImportRate: p.importRate, ExportRate: p.exportRate}
+		}
+	}
+	return Rate{Season: s.name, Period: DefaultPeriod, ImportRate: s.importRate, ExportRate: s.exportRate}
+}
+
+// matches reports whether the period covers minute on weekday. For windows
+// wrapping past midnight the day check applies to the day the window starts.
+func (p period) matches(wd time.Weekday, minute int) bool {
+	if p.start < p.end {
+		return p.days[wd] && minute >= p.start && minute < p.end
+	}
+	if minute >= p.start {
+		return p.days[wd]
+	}
+	return minute < p.end && p.days[(wd+6)%7]
+}
+
+// FixedDailyCharge returns the charge applied once per local day.
+func (t *Tariff) FixedDailyCharge() float64 {
+	return t.fixedDailyCharge
+}
+
+// Day returns local midnight of the day containing at, for fixed charges.
+func (t *Tariff) Day(at time.Time) time.Time {
+	local := at.In(t.loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, t.loc)
+}
+
+// PeriodNames returns every period name the tariff can return, in
+// configuration order.
+func (t *Tariff) PeriodNames() []string {
+	return t.periodNames
+}
@@ -0,0 +1,145 @@
+package tariff
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// touConfig is a two-season TOU plan with a weekday peak and an overnight
+// super-off-peak window.
+func touConfig() Config {
+	return Config{
+		FixedDailyCharge: 0.40,
+		Seasons: []Season{
+			{
+				Name:       "summer",
+				Months:     []int{6, 7, 8, 9},
+				ImportRate: 0.30,
+				ExportRate: 0.05,
+				Periods: []Period{
+					{Name: "peak", Days: []string{"weekday"}, Start: "16:00", End: "21:00", ImportRate: 0.55, ExportRate: 0.08},
+				},
+			},
+			{
+				Name:       "winter",
+				Months:     []int{1, 2, 3, 4, 5, 10, 11, 12},
+				ImportRate: 0.25,
+				ExportRate: 0.04,
+				Periods: []Period{
+					{Name: "peak", Days: []string{"weekday"}, Start: "16:00", End: "21:00", ImportRate: 0.40, ExportRate: 0.06},
+					{Name: "super-off-peak", Start: "23:00", End: "06:00", ImportRate: 0.12, ExportRate: 0.02},
+				},
+			},
+		},
+	}
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestLookup(t *testing.T) {
+	loc := mustLoad(t, "America/Los_Angeles")
+	tariff, err := New(touConfig(), loc)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		at     time.Time
+		period string
+		season string
+		rate   float64
+	}{
+		{"summer weekday peak", time.Date(2024, 7, 10, 17, 0, 0, 0, loc), "peak", "summer", 0.55},
+		{"summer weekend afternoon", time.Date(2024, 7, 13, 17, 0, 0, 0, loc), DefaultPeriod, "summer", 0.30},
+		{"peak end is exclusive", time.Date(2024, 7, 10, 21, 0, 0, 0, loc), DefaultPeriod, "summer", 0.30},
+		{"winter overnight before midnight", time.Date(2024, 1, 12, 23, 30, 0, 0, loc), "super-off-peak", "winter", 0.12},
+		{"winter overnight after midnight", time.Date(2024, 1, 13, 5, 59, 0, 0, loc), "super-off-peak", "winter", 0.12},
+		{"winter morning", time.Date(2024, 1, 13, 6, 0, 0, 0, loc), DefaultPeriod, "winter", 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tariff.Lookup(tt.at)
+			if got.Period != tt.period || got.Season != tt.season || got.ImportRate != tt.rate {
+				t.Errorf("got %+v, want period=%s season=%s import=%v", got, tt.period, tt.season, tt.rate)
+			}
+		})
+	}
+}
+
+func TestLookup_DST(t *testing.T) {
+	loc := mustLoad(t, "America/Los_Angeles")
+	tariff, err := New(touConfig(), loc)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// 23:30 UTC is 15:30 PST on Friday 2024-03-08 but 16:30 PDT on Monday
+	// 2024-03-11, after spring-forward. The peak follows the wall clock.
+	if got := tariff.Lookup(time.Date(2024, 3, 8, 23, 30, 0, 0, time.UTC)); got.Period != DefaultPeriod {
+		t.Errorf("before DST: expected %s, got %s", DefaultPeriod, got.Period)
+	}
+	if got := tariff.Lookup(time.Date(2024, 3, 11, 23, 30, 0, 0, time.UTC)); got.Period != "peak" {
+		t.Errorf("after DST: expected peak, got %s", got.Period)
+	}
+
+	// Spring-forward night: 01:59 PST is followed by 03:00 PDT, both inside
+	// the overnight window
+	springForward := time.Date(2024, 3, 10, 9, 59, 0, 0, time.UTC) // 01:59 PST
+	for _, at := range []time.Time{springForward, springForward.Add(time.Minute)} {
+		if got := tariff.Lookup(at); got.Period != "super-off-peak" {
+			t.Errorf("spring-forward %v: expected super-off-peak, got %s", at.In(loc), got.Period)
+		}
+	}
+
+	// Fall-back night: 01:30 occurs twice and both map to the same period
+	firstPass := time.Date(2024, 11, 3, 8, 30, 0, 0, time.UTC) // 01:30 PDT
+	secondPass := firstPass.Add(time.Hour)                     // 01:30 PST
+	if a, b := tariff.Lookup(firstPass), tariff.Lookup(secondPass); a.Period != "super-off-peak" || b.Period != "super-off-peak" {
+		t.Errorf("fall-back: expected super-off-peak twice, got %s and %s", a.Period, b.Period)
+	}
+
+	// The 25-hour fall-back day is still a single day for fixed charges
+	if !tariff.Day(firstPass).Equal(tariff.Day(time.Date(2024, 11, 4, 7, 59, 0, 0, time.UTC))) {
+		t.Error("expected 23:59 PST on the fall-back day to be the same day as 01:30 PDT")
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	loc := time.UTC
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"no seasons", Config{}},
+		{"missing month", Config{Seasons: []Season{{Months: []int{1, 2, 3}}}}},
+		{"overlapping months", Config{Seasons: []Season{{Name: "a"}, {Name: "b", Months: []int{6}}}}},
+		{"bad time", Config{Seasons: []Season{{Periods: []Period{{Name: "peak", Start: "4pm", End: "21:00"}}}}}},
+		{"bad day", Config{Seasons: []Season{{Periods: []Period{{Name: "peak", Days: []string{"funday"}, Start: "16:00", End: "21:00"}}}}}},
+		{"empty period", Config{Seasons: []Season{{Periods: []Period{{Name: "peak", Start: "16:00", End: "16:00"}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg, loc); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	// A single season without months covers the whole year
+	tariff, err := New(Config{Seasons: []Season{{ImportRate: 0.2}}}, loc)
+	if err != nil {
+		t.Fatalf("flat tariff: %v", err)
+	}
+	if names := tariff.PeriodNames(); len(names) != 1 || names[0] != DefaultPeriod {
+		t.Errorf("unexpected period names %v", names)
+	}
+}